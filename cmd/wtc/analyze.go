@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"wifi-trade-consensus/internal/analyze"
+)
+
+func runAnalyze(args []string) int {
+	flags := newFlagSet("analyze", "<run> [<run to compare>]")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: wtc analyze [flags] <run> [<run to compare>]")
+		fmt.Fprintln(flags.Output(), "a run is a consumer results file, a provider stats dump or a directory of them")
+		flags.PrintDefaults()
+	}
+	format := flags.String("format", analyze.FormatTable, "output format: table, json or csv")
+	faulty := flags.String("faulty", "", "comma separated ids of faulty providers, in addition to those in provider stats dumps")
+	output := flags.String("output", "", "write to this file instead of stdout")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	faultyProviders := map[string]bool{}
+	for _, id := range strings.Split(*faulty, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			faultyProviders[id] = true
+		}
+	}
+
+	reports := []analyze.Report{}
+	for _, path := range flags.Args() {
+		run, err := analyze.Load(path)
+		if err != nil {
+			fmt.Println("failed to load run:", err)
+			return exitFailure
+		}
+		for _, warning := range run.Warnings {
+			fmt.Fprintln(os.Stderr, "warning:", warning)
+		}
+		reports = append(reports, analyze.Analyze(run, faultyProviders))
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Println("failed to create output file:", err)
+			return exitFailure
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if err := analyze.Write(out, *format, reports); err != nil {
+		fmt.Println("failed to write report:", err)
+		return exitFailure
+	}
+	return exitOK
+}
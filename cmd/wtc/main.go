@@ -0,0 +1,129 @@
+// wtc runs every part of the wifi trade consensus experiment: the provider,
+// consumer and trigger nodes, a local simulation of all of them, and the
+// tools to validate configs, query providers and analyze results
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"wifi-trade-consensus/internal/pkg/config"
+)
+
+// Exit codes shared by every subcommand
+const (
+	exitOK      = 0
+	exitFailure = 1 // the command ran and failed
+	exitUsage   = 2 // bad subcommand, flags or arguments
+	exitConfig  = 3 // a config file is missing or invalid
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands = []command{
+	{"provider", "run a provider node", runProvider},
+	{"consumer", "run a consumer node", runConsumer},
+	{"trigger", "send the BUY workload to the consumers", runTrigger},
+	{"simulate", "run providers, consumers and the trigger of a config directory locally", runSimulate},
+	{"stats", "fetch GET_PROVIDER_STATS from running providers", runStats},
+	{"validate", "check every config file of a config directory", runValidate},
+	{"analyze", "compute the metrics of a run's results", runAnalyze},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
+
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		os.Exit(exitOK)
+	}
+	for _, command := range commands {
+		if command.name == name {
+			os.Exit(command.run(os.Args[2:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+	usage()
+	os.Exit(exitUsage)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: wtc <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, command := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", command.name, command.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nrun 'wtc <command> -h' for the flags of a command")
+	fmt.Fprintf(os.Stderr, "\nexit codes: %d ok, %d failure, %d usage error, %d invalid config\n", exitOK, exitFailure, exitUsage, exitConfig)
+}
+
+func newFlagSet(name string, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: wtc %s [flags] %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags returns the exit code to stop with, if parsing should stop the
+// command
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	err := flags.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// overrides collects repeated --set key=value flags
+type overrides []string
+
+func (o *overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *overrides) Set(value string) error {
+	if key, _, ok := strings.Cut(value, "="); !ok || key == "" {
+		return fmt.Errorf("want key=value, got %q", value)
+	}
+	*o = append(*o, value)
+	return nil
+}
+
+// configFlags registers the --<prefix>config and --<prefix>set flags of a
+// config file. The file defaults to cmd/<node>/<name><node_num>.json, the
+// layout used by the docker containers
+func configFlags(flags *flag.FlagSet, prefix string, node string, name string, envPrefix string) *config.Source {
+	source := &config.Source{EnvPrefix: envPrefix}
+	defaultPath := filepath.Join("cmd", node, name+os.Getenv("node_num")+".json")
+	flags.StringVar(&source.Path, prefix+"config", defaultPath, "path of the "+strings.ReplaceAll(name, "_", " ")+" file")
+	flags.Var((*overrides)(&source.Overrides), prefix+"set", "override a key of the "+strings.ReplaceAll(name, "_", " ")+", key=value, repeatable (env: "+envPrefix+"<KEY>)")
+	return source
+}
+
+// printConfig writes the effective settings as JSON, keyed by config file
+func printConfig(settings map[string]map[string]interface{}) {
+	jsonSettings, err := json.MarshalIndent(settings, "", "    ")
+	if err != nil {
+		fmt.Println("failed to marshal config:", err)
+		return
+	}
+	fmt.Println(string(jsonSettings))
+}
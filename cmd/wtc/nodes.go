@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"wifi-trade-consensus/internal/consumer"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/provider"
+	"wifi-trade-consensus/internal/trigger"
+)
+
+// invalidConfig prints why the config of source was rejected
+func invalidConfig(source *config.Source, err error) int {
+	fmt.Printf("invalid config file %s: %v\n", source.Path, err)
+	return exitConfig
+}
+
+// shutdownNode gives the transactions in flight drainTimeout seconds to
+// finish, a second interrupt kills the node
+func shutdownNode(node interface{ Shutdown(context.Context) error }, drainTimeout int) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(drainTimeout)*time.Second)
+	defer cancel()
+	if err := node.Shutdown(ctx); err != nil {
+		slog.Error("failed to shut down", logging.Err(err))
+		return exitFailure
+	}
+	slog.Info("shut down")
+	return exitOK
+}
+
+func runProvider(args []string) int {
+	flags := newFlagSet("provider", "")
+	source := configFlags(flags, "", "provider", "config", "WTC_PROVIDER_")
+	beaconSource := configFlags(flags, "beacon-", "provider", "beacon_config", "WTC_BEACON_")
+	printOnly := flags.Bool("print-config", false, "print the effective config and exit")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	beaconConfig, err := beaconSource.Load()
+	if err != nil {
+		fmt.Println(err)
+		return exitConfig
+	}
+	beaconSettings, err := provider.NewBeaconSettingsFromConfig(beaconConfig)
+	if err != nil {
+		return invalidConfig(beaconSource, err)
+	}
+	providerConfig, err := source.Load()
+	if err != nil {
+		fmt.Println(err)
+		return exitConfig
+	}
+	options, err := provider.NewOptionsFromConfig(providerConfig)
+	if err != nil {
+		return invalidConfig(source, err)
+	}
+	if token, _ := providerConfig["admin_token"].(string); token != "" {
+		providerConfig["admin_token"] = "REDACTED"
+	}
+	if *printOnly {
+		printConfig(map[string]map[string]interface{}{"config": providerConfig, "beacon_config": beaconConfig})
+		return exitOK
+	}
+
+	p := provider.New(*options)
+	// Packages without a logger of their own log through the node's
+	slog.SetDefault(p.Logger())
+	slog.Info("config loaded", "path", source.Path, "beacon_path", beaconSource.Path,
+		"beacon_settings", *beaconSettings, "options", *options)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Serve prometheus metrics if configured
+	if err := p.NewMetricsServer(); err != nil {
+		slog.Error("failed to create metrics server", logging.Err(err))
+		return exitFailure
+	}
+
+	// Reload params when the config file changes
+	p.WatchConfig(*source)
+
+	// Serve the admin API if configured
+	if err := p.NewAdminServer(); err != nil {
+		slog.Error("failed to create admin server", logging.Err(err))
+		return exitFailure
+	}
+
+	// Export spans if configured
+	if err := p.NewTracer(); err != nil {
+		slog.Error("failed to create tracer", logging.Err(err))
+		return exitFailure
+	}
+
+	// Archive evicted transactions if configured
+	if err := p.NewArchive(); err != nil {
+		slog.Error("failed to create archive", logging.Err(err))
+		return exitFailure
+	}
+	go p.NewTransactionSweeper(ctx)
+
+	// Begin beacon broadcast
+	go p.NewBeaconEmitter(ctx, *beaconSettings)
+
+	// Create new throughput server
+	if err := p.NewThroughputServer(); err != nil {
+		slog.Error("failed to create throughput server", logging.Err(err))
+		return exitFailure
+	}
+
+	// Create new listener for provider, until interrupted
+	if err := p.NewListener(ctx); err != nil {
+		slog.Error("failed to create new listener", logging.Err(err))
+		return exitFailure
+	}
+	stop()
+	return shutdownNode(p, options.DrainTimeout)
+}
+
+func runConsumer(args []string) int {
+	flags := newFlagSet("consumer", "")
+	source := configFlags(flags, "", "consumer", "config", "WTC_CONSUMER_")
+	printOnly := flags.Bool("print-config", false, "print the effective config and exit")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	settings, err := source.Load()
+	if err != nil {
+		fmt.Println(err)
+		return exitConfig
+	}
+	options, err := consumer.NewOptionsFromConfig(settings)
+	if err != nil {
+		return invalidConfig(source, err)
+	}
+	if *printOnly {
+		printConfig(map[string]map[string]interface{}{"config": settings})
+		return exitOK
+	}
+
+	consumer := consumer.New(*options)
+	slog.SetDefault(consumer.Logger())
+	slog.Info("config loaded", "path", source.Path, "options", *options)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := consumer.NewResultsLog(); err != nil {
+		slog.Error("failed to create results log", logging.Err(err))
+		return exitFailure
+	}
+
+	if err := consumer.NewOracle(); err != nil {
+		slog.Error("failed to load oracle", logging.Err(err))
+		return exitFailure
+	}
+
+	if err := consumer.NewMetricsServer(); err != nil {
+		slog.Error("failed to create metrics server", logging.Err(err))
+		return exitFailure
+	}
+
+	if err := consumer.NewTracer(); err != nil {
+		slog.Error("failed to create tracer", logging.Err(err))
+		return exitFailure
+	}
+
+	go consumer.NewTransactionSweeper(ctx)
+
+	if err := consumer.NewThroughputServer(); err != nil {
+		slog.Error("failed to create throughput server", logging.Err(err))
+		return exitFailure
+	}
+
+	if err := consumer.NewListener(ctx); err != nil {
+		slog.Error("failed to create new listener", logging.Err(err))
+		return exitFailure
+	}
+	stop()
+	return shutdownNode(consumer, options.DrainTimeout)
+}
+
+func runTrigger(args []string) int {
+	flags := newFlagSet("trigger", "")
+	source := configFlags(flags, "", "trigger", "config", "WTC_TRIGGER_")
+	printOnly := flags.Bool("print-config", false, "print the effective config and exit")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+
+	settings, err := source.Load()
+	if err != nil {
+		fmt.Println(err)
+		return exitConfig
+	}
+	opt, err := trigger.NewOptionsFromConfig(settings)
+	if err != nil {
+		return invalidConfig(source, err)
+	}
+	if token, _ := settings["admin_token"].(string); token != "" {
+		settings["admin_token"] = "REDACTED"
+	}
+	if *printOnly {
+		printConfig(map[string]map[string]interface{}{"config": settings})
+		return exitOK
+	}
+
+	t := trigger.New(opt)
+	slog.SetDefault(t.Logger())
+	slog.Info("config loaded", "path", source.Path)
+
+	// Listen for TRIGGER_RESULT replies if configured
+	if err := t.NewResultListener(); err != nil {
+		slog.Error("failed to create result listener", logging.Err(err))
+		return exitFailure
+	}
+	stopSummaryPrinter := t.NewSummaryPrinter()
+
+	// Run the scenario timeline if configured, otherwise only the workloads
+	if opt.ScenarioPath != "" {
+		if err := t.RunScenario(opt.ScenarioPath); err != nil {
+			slog.Error("failed to run scenario", logging.Err(err))
+			return exitFailure
+		}
+	} else {
+		t.Start()
+	}
+
+	stopSummaryPrinter()
+	slog.Info("final summary", "summary", t.Summary().String())
+	if t.Failed() {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// runSimulate starts every provider and consumer of a config directory as
+// child processes, runs the trigger against them and stops them once it is
+// done. The exit code is the trigger's
+func runSimulate(args []string) int {
+	flags := newFlagSet("simulate", "[dir]")
+	startupDelay := flags.Duration("startup-delay", 3*time.Second, "time given to providers and consumers to start before the trigger")
+	stopTimeout := flags.Duration("stop-timeout", 10*time.Second, "time given to providers and consumers to clean up before they are killed")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	dir := "cmd"
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	// A bad config would otherwise only show up in the logs of its node
+	if code := runValidate([]string{dir}); code != exitOK {
+		return code
+	}
+	nodes, triggerArgs, err := simulationNodes(dir)
+	if err != nil {
+		fmt.Println(err)
+		return exitConfig
+	}
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Println("failed to find the wtc executable:", err)
+		return exitFailure
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	running := []*exec.Cmd{}
+	exited := make(chan *exec.Cmd, len(nodes))
+	stop := func() {
+		stopNodes(running, exited, *stopTimeout)
+	}
+	for _, nodeArgs := range nodes {
+		cmd := newChild(self, nodeArgs)
+		if err := cmd.Start(); err != nil {
+			fmt.Printf("failed to start %s: %v\n", strings.Join(nodeArgs, " "), err)
+			stop()
+			return exitFailure
+		}
+		running = append(running, cmd)
+		go func() {
+			cmd.Wait()
+			exited <- cmd
+		}()
+	}
+
+	select {
+	case <-time.After(*startupDelay):
+	case cmd := <-exited:
+		exited <- cmd
+		fmt.Printf("%s exited before the trigger started\n", strings.Join(cmd.Args[1:], " "))
+		stop()
+		return exitFailure
+	case <-interrupt:
+		stop()
+		return exitFailure
+	}
+
+	trigger := newChild(self, triggerArgs)
+	if err := trigger.Start(); err != nil {
+		fmt.Println("failed to start trigger:", err)
+		stop()
+		return exitFailure
+	}
+	triggerDone := make(chan error, 1)
+	go func() {
+		triggerDone <- trigger.Wait()
+	}()
+
+	code := exitOK
+	select {
+	case err := <-triggerDone:
+		code = exitCode(err)
+	case cmd := <-exited:
+		exited <- cmd
+		fmt.Printf("%s exited before the trigger was done\n", strings.Join(cmd.Args[1:], " "))
+		stopChild(trigger)
+		<-triggerDone
+		code = exitFailure
+	case <-interrupt:
+		stopChild(trigger)
+		<-triggerDone
+		code = exitFailure
+	}
+
+	stop()
+	return code
+}
+
+// simulationNodes lists the arguments of every provider and consumer of the
+// config directory and of the trigger. Each provider config<N>.json needs a
+// beacon_config<N>.json next to it
+func simulationNodes(dir string) ([][]string, []string, error) {
+	nodes := [][]string{}
+
+	providerConfigs, _ := filepath.Glob(filepath.Join(dir, "provider", "config*.json"))
+	for _, path := range providerConfigs {
+		suffix := strings.TrimPrefix(filepath.Base(path), "config")
+		beaconPath := filepath.Join(dir, "provider", "beacon_config"+suffix)
+		if _, err := os.Stat(beaconPath); err != nil {
+			return nil, nil, fmt.Errorf("provider config %s has no beacon config: %w", path, err)
+		}
+		nodes = append(nodes, []string{"provider", "--config", path, "--beacon-config", beaconPath})
+	}
+
+	consumerConfigs, _ := filepath.Glob(filepath.Join(dir, "consumer", "config*.json"))
+	for _, path := range consumerConfigs {
+		nodes = append(nodes, []string{"consumer", "--config", path})
+	}
+
+	if len(providerConfigs) == 0 || len(consumerConfigs) == 0 {
+		return nil, nil, fmt.Errorf("%s needs at least one provider and one consumer config", dir)
+	}
+
+	triggerPath := filepath.Join(dir, "trigger", "config.json")
+	if _, err := os.Stat(triggerPath); err != nil {
+		return nil, nil, fmt.Errorf("trigger config not found: %w", err)
+	}
+	return nodes, []string{"trigger", "--config", triggerPath}, nil
+}
+
+func newChild(self string, args []string) *exec.Cmd {
+	cmd := exec.Command(self, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// stopChild interrupts a child so it can clean up, or kills it where
+// interrupts aren't supported
+func stopChild(cmd *exec.Cmd) {
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// stopNodes interrupts every node still running and kills those that haven't
+// exited after timeout. exited receives each node once it exits
+func stopNodes(running []*exec.Cmd, exited chan *exec.Cmd, timeout time.Duration) {
+	for _, cmd := range running {
+		stopChild(cmd)
+	}
+
+	deadline := time.After(timeout)
+	for remaining := len(running); remaining > 0; {
+		select {
+		case <-exited:
+			remaining--
+		case <-deadline:
+			for _, cmd := range running {
+				cmd.Process.Kill()
+			}
+			deadline = nil
+		}
+	}
+}
+
+// exitCode returns the exit code of a child, or exitFailure if it was killed
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/stats"
+	"wifi-trade-consensus/internal/trigger"
+)
+
+// runStats renders the cluster view of the providers given as arguments, or
+// of every provider in the trigger config
+func runStats(args []string) int {
+	flags := newFlagSet("stats", "[provider address...]")
+	source := configFlags(flags, "", "trigger", "config", "WTC_TRIGGER_")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of each request")
+	format := flags.String("format", stats.FormatTable, "output format: table or json")
+	threshold := flags.Float64("threshold", 0.2, "spread of a score across scorers counted as a disagreement, relative for last_price")
+	watch := flags.Duration("watch", 0, "refresh the view at this interval until interrupted")
+	output := flags.String("output", "", "write one raw stats dump per provider to this directory instead, for analyze")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if *format != stats.FormatTable && *format != stats.FormatJSON {
+		fmt.Fprintf(os.Stderr, "unknown output format: %s\n", *format)
+		return exitUsage
+	}
+
+	addresses := flags.Args()
+	if len(addresses) == 0 {
+		var err error
+		if addresses, err = providerAddresses(source); err != nil {
+			fmt.Println(err)
+			return exitConfig
+		}
+	}
+
+	if *output != "" {
+		return writeDumps(addresses, *timeout, *output)
+	}
+	if *watch <= 0 {
+		return renderCluster(addresses, *timeout, *threshold, *format)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+	for {
+		if *format == stats.FormatTable {
+			fmt.Print("\033[H\033[2J") // clear the terminal
+		}
+		renderCluster(addresses, *timeout, *threshold, *format)
+		select {
+		case <-ticker.C:
+		case <-interrupt:
+			return exitOK
+		}
+	}
+}
+
+// renderCluster fetches and prints the cluster view once, it fails if a
+// provider couldn't be queried
+func renderCluster(addresses []string, timeout time.Duration, threshold float64, format string) int {
+	replies, failures := stats.FetchAll(addresses, timeout)
+	cluster, err := stats.NewCluster(replies, failures, threshold)
+	if err != nil {
+		fmt.Println(err)
+		return exitFailure
+	}
+	if err := stats.Write(os.Stdout, format, cluster); err != nil {
+		fmt.Println("failed to write stats:", err)
+		return exitFailure
+	}
+	if len(failures) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// writeDumps writes the raw reply of every provider to its own file
+func writeDumps(addresses []string, timeout time.Duration, dir string) int {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Println("failed to create output dir:", err)
+		return exitFailure
+	}
+	replies, failures := stats.FetchAll(addresses, timeout)
+	for address, reply := range replies {
+		path := filepath.Join(dir, "provider_stats--"+strings.ReplaceAll(address, ":", "-")+".json")
+		if err := os.WriteFile(path, reply, 0644); err != nil {
+			fmt.Println("failed to write stats dump:", err)
+			return exitFailure
+		}
+	}
+	for address, err := range failures {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", address, err)
+	}
+	if len(failures) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// providerAddresses lists the providers of every consumer of the trigger
+// config, without duplicates
+func providerAddresses(source *config.Source) ([]string, error) {
+	settings, err := source.Load()
+	if err != nil {
+		return nil, err
+	}
+	opt, err := trigger.NewOptionsFromConfig(settings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", source.Path, err)
+	}
+
+	addresses := []string{}
+	seen := map[string]bool{}
+	for _, consumer := range opt.Consumers {
+		for _, provider := range consumer.ProviderList {
+			if !seen[provider.Address] {
+				seen[provider.Address] = true
+				addresses = append(addresses, provider.Address)
+			}
+		}
+	}
+	return addresses, nil
+}
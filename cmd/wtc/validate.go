@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"wifi-trade-consensus/internal/consumer"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/provider"
+	"wifi-trade-consensus/internal/trigger"
+)
+
+// Config files of each node, relative to the config directory
+var configChecks = []struct {
+	pattern  string
+	validate func(path string) error
+}{
+	{"provider/config*.json", provider.ValidateConfigFile},
+	{"provider/beacon_config*.json", provider.ValidateBeaconConfigFile},
+	{"consumer/config*.json", consumer.ValidateConfigFile},
+	{"trigger/config*.json", validateTrigger},
+}
+
+// runValidate checks every config file under the config directory, ./cmd by
+// default
+func runValidate(args []string) int {
+	flags := newFlagSet("validate", "[dir]")
+	if code, ok := parseFlags(flags, args); !ok {
+		return code
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return exitUsage
+	}
+	dir := "cmd"
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	// Consumers of a directory run side by side, see wtc simulate
+	consumerPaths, _ := filepath.Glob(filepath.Join(dir, "consumer/config*.json"))
+	conflicts := consumerConflicts(consumerPaths)
+
+	checked, invalid := 0, 0
+	for _, check := range configChecks {
+		paths, err := filepath.Glob(filepath.Join(dir, check.pattern))
+		if err != nil {
+			fmt.Println("failed to list config files:", err)
+			return exitFailure
+		}
+		for _, path := range paths {
+			checked++
+			err := check.validate(path)
+			if conflict, exists := conflicts[path]; exists && err == nil {
+				err = conflict
+			}
+			if err != nil {
+				invalid++
+				fmt.Printf("FAIL %s: %v\n", path, err)
+				continue
+			}
+			fmt.Printf("ok   %s\n", path)
+		}
+	}
+
+	if checked == 0 {
+		fmt.Println("no config files found in", dir)
+		return exitConfig
+	}
+	if invalid > 0 {
+		fmt.Printf("%d of %d config files are invalid\n", invalid, checked)
+		return exitConfig
+	}
+	return exitOK
+}
+
+// validateTrigger checks a trigger config file, and its udp_share against the
+// throughput engines of the providers of the same config directory
+func validateTrigger(path string) error {
+	if err := trigger.ValidateConfigFile(path); err != nil {
+		return err
+	}
+
+	providerPaths, _ := filepath.Glob(filepath.Join(filepath.Dir(filepath.Dir(path)), "provider/config*.json"))
+	engines := map[string]string{}
+	for _, providerPath := range providerPaths {
+		// Broken provider configs are reported on their own
+		if id, engine, err := provider.ThroughputEngineOfConfigFile(providerPath); err == nil {
+			engines[id] = engine
+		}
+	}
+	return trigger.ValidateProviderEngines(path, engines)
+}
+
+// consumerConflicts returns, by path, the consumer config files that reuse the
+// id or the listen port of an earlier one, wtc simulate runs them all on the
+// local host
+func consumerConflicts(paths []string) map[string]error {
+	conflicts := map[string]error{}
+	ids := map[string]string{}
+	ports := map[string]string{}
+	for _, path := range paths {
+		// Broken consumer configs are reported on their own
+		id, address, err := consumer.AddressOfConfigFile(path)
+		if err != nil {
+			continue
+		}
+		problems := config.Problems{}
+		if other, exists := ids[id]; exists {
+			problems.Add("id %q is already used by %s", id, other)
+		} else {
+			ids[id] = path
+		}
+		_, port, _ := net.SplitHostPort(address)
+		if other, exists := ports[port]; exists {
+			problems.Add("port of address %s is already used by %s", address, other)
+		} else {
+			ports[port] = path
+		}
+		if err := problems.Err(); err != nil {
+			conflicts[path] = err
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,260 @@
+package analyze
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Transaction states recorded by the consumer results log
+const (
+	stateSucceeded  = "succeeded"
+	stateFailed     = "failed"
+	stateIncomplete = "incomplete"
+	stateTimedOut   = "timed_out"
+)
+
+// errUnrecognized is returned for a file that is neither a results log, a
+// provider stats dump nor a legacy transactions file
+var errUnrecognized = errors.New("unrecognized results file")
+
+// Run is everything recorded by a single experiment
+type Run struct {
+	Label        string
+	Transactions []Transaction
+	Providers    []ProviderStats
+	Warnings     []string // files of a directory that were skipped
+}
+
+// Transaction is the part of a consumer transaction record used by the
+// analysis
+type Transaction struct {
+	TransactionID   string          `json:"transaction_id"`
+	ConsumerID      string          `json:"consumer_id"`
+	State           string          `json:"state"`
+	FailureReasons  []string        `json:"failure_reasons"`
+	QOSRequirements qosRequirements `json:"qos_requirements"`
+	Winner          struct {
+		ProviderID string `json:"provider_id"`
+	} `json:"winner"`
+	Price           float64                `json:"price"`
+	PriceConsumer   float64                `json:"price_consumer"`
+	UplinkSpeed     float64                `json:"uplink_speed"`
+	DownlinkSpeed   float64                `json:"downlink_speed"`
+	UplinkFlow      flow                   `json:"uplink_flow"`
+	DownlinkFlow    flow                   `json:"downlink_flow"`
+	Rating          float64                `json:"rating"`
+	RatingBreakdown map[string]ratingScore `json:"rating_breakdown"` // index: dimension, empty before it was recorded
+	Durations       durations              `json:"durations"`
+	Oracle          *verdict               `json:"oracle"`
+
+	hasRequirements bool // false for legacy files, which carry no QoS requirements or rating
+}
+
+type qosRequirements struct {
+	PriceConsumer         float64 `json:"price"`
+	UplinkSpeedConsumer   float64 `json:"uplink"`
+	DownlinkSpeedConsumer float64 `json:"downlink"`
+	MaxJitter             float64 `json:"max_jitter"` // ms, 0 is no bound
+	MaxLoss               float64 `json:"max_loss"`   // percent, 0 is no bound
+	MaxRTT                float64 `json:"max_rtt"`    // ms, 0 is no bound
+}
+
+// ratingScore is one dimension of the rating of a transaction
+type ratingScore struct {
+	Score float64 `json:"score"`
+}
+
+// durations in ms, 0 when the phase was never reached
+type durations struct {
+	Consensus float64 `json:"consensus"`
+	Flow      float64 `json:"flow"`
+	EndToEnd  float64 `json:"end_to_end"`
+}
+
+// flow measured in one direction, zero in files recorded before it was
+type flow struct {
+	BitsPerSecond       float64 `json:"bits_per_second"`
+	StdDevBitsPerSecond float64 `json:"std_dev_bits_per_second"`
+	MeanRTT             float64 `json:"mean_rtt"` // ms, 0 if unknown
+	Protocol            string  `json:"protocol"`
+	JitterMS            float64 `json:"jitter_ms"` // udp only
+	LostPercent         float64 `json:"lost_percent"`
+}
+
+// verdict of the ground truth oracle, see internal/pkg/oracle
+type verdict struct {
+	Winner  string  `json:"winner"`
+	Matched bool    `json:"matched"`
+	Regret  float64 `json:"regret"`
+	Rank    int     `json:"rank"`
+}
+
+// ProviderStats is the part of a GET_PROVIDER_STATS response used by the
+// analysis
+type ProviderStats struct {
+	ID                 string  `json:"id"`
+	Price              float64 `json:"price"`
+	Behaviour          string  `json:"behaviour"`
+	TransactionTimings map[string]struct {
+		VoteRound float64 `json:"vote_round"`
+	} `json:"transaction_timings"`
+}
+
+// legacyTransaction is an entry of the consumer_transactions--<time> files
+// written before the results log existed
+type legacyTransaction struct {
+	FlowMetrics struct {
+		ProviderInfo struct {
+			ProviderID string `json:"provider_id"`
+		} `json:"provider_info"`
+		Price                float64 `json:"price"`
+		PriceConsumer        float64 `json:"price_consumer"`
+		AverageUplinkSpeed   float64 `json:"average_uplink"`
+		AverageDownlinkSpeed float64 `json:"average_downlink"`
+	} `json:"flow_metrics"`
+}
+
+// Load reads a run from a results file, a provider stats dump or a directory
+// holding any number of them, the label of the run is the base name of path
+func Load(path string) (*Run, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	run := &Run{Label: filepath.Base(filepath.Clean(path))}
+	if !info.IsDir() {
+		if err := run.loadFile(path); err != nil {
+			return nil, err
+		}
+		return run, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dir %s: %w", path, err)
+	}
+	for _, entry := range entries {
+		// CSV results duplicate the JSONL ones
+		if entry.IsDir() || strings.EqualFold(filepath.Ext(entry.Name()), ".csv") {
+			continue
+		}
+		// Other files such as configs and logs often sit next to the results
+		if err := run.loadFile(filepath.Join(path, entry.Name())); errors.Is(err, errUnrecognized) {
+			run.Warnings = append(run.Warnings, fmt.Sprintf("skipped %v", err))
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if len(run.Transactions) == 0 && len(run.Providers) == 0 {
+		return nil, fmt.Errorf("no results found in %s", path)
+	}
+
+	return run, nil
+}
+
+// loadFile detects the kind of file from its content
+func (r *Run) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil
+	}
+
+	// A results log is a header line followed by transaction lines
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	record := struct {
+		RecordType string `json:"record_type"`
+	}{}
+	if json.Unmarshal(firstLine, &record) == nil && record.RecordType != "" {
+		// Provider archives repeat the consumers' transactions from the other side
+		if record.RecordType == "provider_transaction" {
+			return nil
+		}
+		return r.loadResultsLog(path, data)
+	}
+
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("%w %s: %v", errUnrecognized, path, err)
+	}
+
+	if _, exists := object["peer_score_matrix"]; exists {
+		stats := ProviderStats{}
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return fmt.Errorf("failed to unmarshal provider stats %s: %w", path, err)
+		}
+		r.Providers = append(r.Providers, stats)
+		return nil
+	}
+
+	return r.loadLegacyTransactions(path, object)
+}
+
+func (r *Run) loadResultsLog(path string, data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		record := struct {
+			RecordType string `json:"record_type"`
+			Transaction
+		}{}
+		if err := json.Unmarshal(text, &record); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		if record.RecordType != "transaction" {
+			continue
+		}
+		record.Transaction.hasRequirements = true
+		r.Transactions = append(r.Transactions, record.Transaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+func (r *Run) loadLegacyTransactions(path string, object map[string]json.RawMessage) error {
+	transactions := make([]Transaction, 0, len(object))
+	for transactionID, raw := range object {
+		legacy := legacyTransaction{}
+		if err := json.Unmarshal(raw, &legacy); err != nil {
+			return fmt.Errorf("%w %s: transaction %s: %v", errUnrecognized, path, transactionID, err)
+		}
+
+		metrics := legacy.FlowMetrics
+		transaction := Transaction{
+			TransactionID: transactionID,
+			State:         stateIncomplete,
+			Price:         metrics.Price,
+			PriceConsumer: metrics.PriceConsumer,
+			UplinkSpeed:   metrics.AverageUplinkSpeed,
+			DownlinkSpeed: metrics.AverageDownlinkSpeed,
+		}
+		transaction.QOSRequirements.PriceConsumer = metrics.PriceConsumer
+		transaction.Winner.ProviderID = metrics.ProviderInfo.ProviderID
+		// The legacy files only hold flow metrics once a winner was chosen
+		if transaction.Winner.ProviderID != "" {
+			transaction.State = stateSucceeded
+		}
+		transactions = append(transactions, transaction)
+	}
+	r.Transactions = append(r.Transactions, transactions...)
+	return nil
+}
@@ -0,0 +1,74 @@
+package analyze
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+		t.Fatalf("failed to write %s: %v", name, err)
+	}
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "consumer_results--c0--1.jsonl", `{"record_type": "header", "consumer_id": "c0"}
+{"record_type": "transaction", "transaction_id": "a", "state": "succeeded", "winner": {"provider_id": "p0"}}
+{"record_type": "transaction", "transaction_id": "b", "state": "failed"}
+`)
+	writeFile(t, dir, "consumer_results--c0--1.csv", "record_type,transaction_id\ntransaction,a\n")
+	writeFile(t, dir, "provider_stats--p0.json", `{"id": "p0", "behaviour": "honest", "peer_score_matrix": {}}`)
+	writeFile(t, dir, "provider_archive--p0.jsonl", `{"record_type": "provider_transaction", "transaction_id": "a"}`)
+	writeFile(t, dir, "consumer.log", "time=now level=INFO msg=started\n")
+
+	run, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if run.Label != filepath.Base(dir) {
+		t.Errorf("Label = %s, want %s", run.Label, filepath.Base(dir))
+	}
+	if len(run.Transactions) != 2 || len(run.Providers) != 1 {
+		t.Errorf("loaded %d transactions and %d providers, want 2 and 1", len(run.Transactions),
+			len(run.Providers))
+	}
+	if len(run.Warnings) != 1 || !strings.Contains(run.Warnings[0], "consumer.log") {
+		t.Errorf("Warnings = %v, want the log file skipped", run.Warnings)
+	}
+}
+
+func TestLoadUnrecognizedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "notes.txt", "not results")
+
+	if _, err := Load(filepath.Join(dir, "notes.txt")); err == nil {
+		t.Errorf("Load of an unrecognized file succeeded")
+	}
+	if _, err := Load(dir); err == nil {
+		t.Errorf("Load of a dir without results succeeded")
+	}
+}
+
+func TestLoadLegacyTransactions(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "consumer_transactions--1.json", `{
+		"a": {"flow_metrics": {"provider_info": {"provider_id": "p0"}, "price": 0.4, "price_consumer": 0.5}},
+		"b": {"flow_metrics": {}}
+	}`)
+
+	run, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	states := map[string]string{}
+	for _, transaction := range run.Transactions {
+		states[transaction.TransactionID] = transaction.State
+	}
+	if states["a"] != stateSucceeded || states["b"] != stateIncomplete {
+		t.Errorf("states = %v, want a succeeded and b incomplete", states)
+	}
+}
@@ -0,0 +1,279 @@
+package analyze
+
+import (
+	"math"
+	"sort"
+)
+
+// Report holds the metrics of a run
+type Report struct {
+	Label        string         `json:"label"`
+	Transactions int            `json:"transactions"`
+	States       map[string]int `json:"states"`
+
+	// Share of finished transactions with known requirements whose measured
+	// uplink and downlink speeds met them
+	QOSSatisfactionRate float64 `json:"qos_satisfaction_rate"`
+
+	MeanPrice         float64            `json:"mean_price"` // price of the winner
+	MeanPriceConsumer float64            `json:"mean_price_consumer"`
+	MeanPriceRatio    float64            `json:"mean_price_ratio"` // price / price_consumer
+	OverBudgetRate    float64            `json:"over_budget_rate"` // share with price > price_consumer
+	WinnerShare       map[string]float64 `json:"winner_share"`     // index: provider id
+	FaultyProviders   []string           `json:"faulty_providers"`
+	FaultyWinRate     float64            `json:"faulty_win_rate"` // share of wins by faulty providers
+
+	// Agreement with the ground truth oracle, over the transactions it judged
+	OracleJudged    int     `json:"oracle_judged"`
+	OracleMatchRate float64 `json:"oracle_match_rate"`
+	MeanRegret      float64 `json:"mean_regret"` // in FF terms
+	MeanRank        float64 `json:"mean_rank"`
+
+	ConsensusLatency summary  `json:"consensus_latency"` // ms
+	EndToEndLatency  summary  `json:"end_to_end_latency"`
+	VoteRoundLatency summary  `json:"vote_round_latency"` // from provider stats dumps
+	RTT              summary  `json:"rtt"`                // ms, mean RTT of each flow direction that reports it
+	ThroughputCV     summary  `json:"throughput_cv"`      // interval std dev / mean of each flow direction
+	Jitter           summary  `json:"jitter"`             // ms, of each udp flow direction
+	Loss             summary  `json:"loss"`               // percent, of each udp flow direction
+	Rating           summary  `json:"rating"`
+	RatingHistogram  []bucket `json:"rating_histogram"`
+
+	// Mean score of each rating dimension over the rated transactions that
+	// scored it
+	RatingDimensions map[string]float64 `json:"rating_dimensions"` // index: dimension
+}
+
+// summary describes the distribution of a sample
+type summary struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	Min   float64 `json:"min"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// bucket counts the ratings in [From, To)
+type bucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
+const ratingBuckets = 5 // ratings are in [0, 1]
+
+// Analyze computes the report of run, faulty is the set of provider ids to
+// count as faulty in addition to those whose stats dump says so
+func Analyze(run *Run, faulty map[string]bool) Report {
+	report := Report{
+		Label:        run.Label,
+		Transactions: len(run.Transactions),
+		States:       map[string]int{},
+		WinnerShare:  map[string]float64{},
+
+		RatingDimensions: map[string]float64{},
+	}
+	dimensionCounts := map[string]int{}
+
+	faultyProviders := map[string]bool{}
+	for id, isFaulty := range faulty {
+		if isFaulty {
+			faultyProviders[id] = true
+		}
+	}
+	voteRounds := []float64{}
+	for _, provider := range run.Providers {
+		if provider.Behaviour == "faulty" {
+			faultyProviders[provider.ID] = true
+		}
+		for _, timings := range provider.TransactionTimings {
+			if timings.VoteRound > 0 {
+				voteRounds = append(voteRounds, timings.VoteRound)
+			}
+		}
+	}
+	for id := range faultyProviders {
+		report.FaultyProviders = append(report.FaultyProviders, id)
+	}
+	sort.Strings(report.FaultyProviders)
+
+	satisfied, judged := 0, 0
+	priced, overBudget := 0, 0
+	totalPrice, totalPriceConsumer, totalRatio := 0.0, 0.0, 0.0
+	wins, faultyWins := 0, 0
+	matched, totalRegret, totalRank := 0, 0.0, 0
+	consensus, endToEnd, ratings := []float64{}, []float64{}, []float64{}
+	rtts, cvs := []float64{}, []float64{}
+	jitters, losses := []float64{}, []float64{}
+	report.RatingHistogram = make([]bucket, ratingBuckets)
+	for idx := range report.RatingHistogram {
+		report.RatingHistogram[idx].From = float64(idx) / ratingBuckets
+		report.RatingHistogram[idx].To = float64(idx+1) / ratingBuckets
+	}
+
+	for _, transaction := range run.Transactions {
+		report.States[transaction.State]++
+		if transaction.Durations.Consensus > 0 {
+			consensus = append(consensus, transaction.Durations.Consensus)
+		}
+		if transaction.Durations.EndToEnd > 0 {
+			endToEnd = append(endToEnd, transaction.Durations.EndToEnd)
+		}
+
+		for _, flow := range []flow{transaction.UplinkFlow, transaction.DownlinkFlow} {
+			if flow.MeanRTT > 0 {
+				rtts = append(rtts, flow.MeanRTT)
+			}
+			if flow.BitsPerSecond > 0 {
+				cvs = append(cvs, flow.StdDevBitsPerSecond/flow.BitsPerSecond)
+			}
+			if flow.Protocol == "UDP" {
+				jitters = append(jitters, flow.JitterMS)
+				losses = append(losses, flow.LostPercent)
+			}
+		}
+
+		if transaction.Oracle != nil && transaction.Oracle.Rank > 0 {
+			report.OracleJudged++
+			totalRegret += transaction.Oracle.Regret
+			totalRank += transaction.Oracle.Rank
+			if transaction.Oracle.Matched {
+				matched++
+			}
+		}
+
+		winner := transaction.Winner.ProviderID
+		if winner == "" {
+			continue
+		}
+		wins++
+		report.WinnerShare[winner]++
+		if faultyProviders[winner] {
+			faultyWins++
+		}
+
+		priced++
+		totalPrice += transaction.Price
+		totalPriceConsumer += transaction.PriceConsumer
+		if transaction.PriceConsumer > 0 {
+			totalRatio += transaction.Price / transaction.PriceConsumer
+		}
+		if transaction.Price > transaction.PriceConsumer {
+			overBudget++
+		}
+
+		if transaction.State == stateIncomplete || transaction.State == stateTimedOut || !transaction.hasRequirements {
+			continue
+		}
+		ratings = append(ratings, transaction.Rating)
+		idx := int(math.Min(math.Max(transaction.Rating, 0)*ratingBuckets, ratingBuckets-1))
+		report.RatingHistogram[idx].Count++
+		for dimension, score := range transaction.RatingBreakdown {
+			report.RatingDimensions[dimension] += score.Score
+			dimensionCounts[dimension]++
+		}
+
+		judged++
+		requirements := transaction.QOSRequirements
+		if transaction.State == stateSucceeded &&
+			transaction.UplinkSpeed >= requirements.UplinkSpeedConsumer &&
+			transaction.DownlinkSpeed >= requirements.DownlinkSpeedConsumer &&
+			withinBounds(transaction, requirements) {
+			satisfied++
+		}
+	}
+
+	for id := range report.WinnerShare {
+		report.WinnerShare[id] /= float64(wins)
+	}
+	for dimension, count := range dimensionCounts {
+		report.RatingDimensions[dimension] /= float64(count)
+	}
+	report.QOSSatisfactionRate = ratio(satisfied, judged)
+	report.FaultyWinRate = ratio(faultyWins, wins)
+	report.OverBudgetRate = ratio(overBudget, priced)
+	report.OracleMatchRate = ratio(matched, report.OracleJudged)
+	if report.OracleJudged > 0 {
+		report.MeanRegret = totalRegret / float64(report.OracleJudged)
+		report.MeanRank = float64(totalRank) / float64(report.OracleJudged)
+	}
+	if priced > 0 {
+		report.MeanPrice = totalPrice / float64(priced)
+		report.MeanPriceConsumer = totalPriceConsumer / float64(priced)
+		report.MeanPriceRatio = totalRatio / float64(priced)
+	}
+	report.ConsensusLatency = summarize(consensus)
+	report.EndToEndLatency = summarize(endToEnd)
+	report.VoteRoundLatency = summarize(voteRounds)
+	report.RTT = summarize(rtts)
+	report.ThroughputCV = summarize(cvs)
+	report.Jitter = summarize(jitters)
+	report.Loss = summarize(losses)
+	report.Rating = summarize(ratings)
+
+	return report
+}
+
+func ratio(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) / float64(total)
+}
+
+func summarize(sample []float64) summary {
+	if len(sample) == 0 {
+		return summary{}
+	}
+
+	sorted := append([]float64{}, sample...)
+	sort.Float64s(sorted)
+	total := 0.0
+	for _, val := range sorted {
+		total += val
+	}
+
+	return summary{
+		Count: len(sorted),
+		Mean:  total / float64(len(sorted)),
+		Min:   sorted[0],
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile linearly interpolates between the closest ranks of a sorted
+// sample
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// withinBounds reports whether the flow directions of transaction kept the
+// RTT bound of its requirements and the udp ones the jitter and loss bounds,
+// 0 is no bound
+func withinBounds(transaction Transaction, requirements qosRequirements) bool {
+	for _, flow := range []flow{transaction.UplinkFlow, transaction.DownlinkFlow} {
+		if requirements.MaxRTT > 0 && flow.MeanRTT > requirements.MaxRTT {
+			return false
+		}
+		if flow.Protocol != "UDP" {
+			continue
+		}
+		if requirements.MaxJitter > 0 && flow.JitterMS > requirements.MaxJitter {
+			return false
+		}
+		if requirements.MaxLoss > 0 && flow.LostPercent > requirements.MaxLoss {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package analyze
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{10, 20, 30, 40, 50}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 10},
+		{50, 30},
+		{90, 46},
+		{99, 49.6},
+		{100, 50},
+	}
+	for _, test := range tests {
+		if got := percentile(sorted, test.p); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", test.p, got, test.want)
+		}
+	}
+	if got := percentile([]float64{7}, 95); got != 7 {
+		t.Errorf("percentile of a single value = %v, want 7", got)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	if got := summarize(nil); got != (summary{}) {
+		t.Errorf("summarize(nil) = %+v, want zero", got)
+	}
+
+	sample := []float64{4, 1, 3, 2}
+	got := summarize(sample)
+	want := summary{Count: 4, Mean: 2.5, Min: 1, P50: 2.5, P90: 3.7, P95: 3.85, P99: 3.97, Max: 4}
+	for name, pair := range map[string][2]float64{
+		"mean": {got.Mean, want.Mean}, "min": {got.Min, want.Min}, "p50": {got.P50, want.P50},
+		"p90": {got.P90, want.P90}, "p95": {got.P95, want.P95}, "p99": {got.P99, want.P99},
+		"max": {got.Max, want.Max},
+	} {
+		if math.Abs(pair[0]-pair[1]) > 1e-9 {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+	if got.Count != want.Count {
+		t.Errorf("count = %d, want %d", got.Count, want.Count)
+	}
+	// The sample is left as it was
+	if sample[0] != 4 || sample[3] != 2 {
+		t.Errorf("summarize sorted its sample: %v", sample)
+	}
+}
+
+func newTransaction(state string, winner string, price float64, rating float64) Transaction {
+	transaction := Transaction{
+		State:           state,
+		Price:           price,
+		PriceConsumer:   1,
+		UplinkSpeed:     10,
+		DownlinkSpeed:   10,
+		Rating:          rating,
+		hasRequirements: true,
+	}
+	transaction.QOSRequirements = qosRequirements{PriceConsumer: 1, UplinkSpeedConsumer: 5, DownlinkSpeedConsumer: 5}
+	transaction.Winner.ProviderID = winner
+	return transaction
+}
+
+func TestAnalyze(t *testing.T) {
+	slow := newTransaction(stateSucceeded, "p1", 0.5, 0.5)
+	slow.DownlinkSpeed = 1
+	jittery := newTransaction(stateSucceeded, "p1", 0.5, 0.3)
+	jittery.QOSRequirements.MaxJitter = 2
+	jittery.UplinkFlow = flow{Protocol: "UDP", JitterMS: 4, LostPercent: 1}
+	run := &Run{
+		Label: "run",
+		Transactions: []Transaction{
+			newTransaction(stateSucceeded, "p0", 0.5, 1),
+			newTransaction(stateSucceeded, "p0", 2, 0.9), // over budget
+			slow,
+			jittery,
+			newTransaction(stateFailed, "", 0, 0),
+			newTransaction(stateIncomplete, "p0", 0.5, 0), // not judged
+		},
+		Providers: []ProviderStats{{ID: "p1", Behaviour: "faulty"}},
+	}
+
+	report := Analyze(run, map[string]bool{"p2": true, "p3": false})
+
+	if report.Transactions != 6 || report.States[stateSucceeded] != 4 || report.States[stateFailed] != 1 {
+		t.Errorf("transactions = %d, states %v", report.Transactions, report.States)
+	}
+	// Only the first two of the four judged transactions met their requirements
+	if report.QOSSatisfactionRate != 0.5 {
+		t.Errorf("QOSSatisfactionRate = %v, want 0.5", report.QOSSatisfactionRate)
+	}
+	if report.WinnerShare["p0"] != 0.6 || report.WinnerShare["p1"] != 0.4 {
+		t.Errorf("WinnerShare = %v, want p0 0.6, p1 0.4", report.WinnerShare)
+	}
+	if len(report.FaultyProviders) != 2 || report.FaultyProviders[0] != "p1" || report.FaultyProviders[1] != "p2" {
+		t.Errorf("FaultyProviders = %v, want [p1 p2]", report.FaultyProviders)
+	}
+	if report.FaultyWinRate != 0.4 {
+		t.Errorf("FaultyWinRate = %v, want 0.4", report.FaultyWinRate)
+	}
+	if report.OverBudgetRate != 0.2 || math.Abs(report.MeanPrice-0.8) > 1e-9 {
+		t.Errorf("OverBudgetRate = %v, MeanPrice = %v, want 0.2, 0.8", report.OverBudgetRate, report.MeanPrice)
+	}
+	if report.Rating.Count != 4 || math.Abs(report.Rating.Mean-0.675) > 1e-9 {
+		t.Errorf("Rating = %+v, want 4 ratings averaging 0.675", report.Rating)
+	}
+	// A rating of 1 falls in the last bucket
+	histogram := []int{0, 1, 1, 0, 2}
+	for idx, want := range histogram {
+		if report.RatingHistogram[idx].Count != want {
+			t.Errorf("RatingHistogram[%d] = %d, want %d", idx, report.RatingHistogram[idx].Count, want)
+		}
+	}
+	if report.Jitter.Count != 1 || report.Jitter.Mean != 4 {
+		t.Errorf("Jitter = %+v, want one udp direction with 4 ms", report.Jitter)
+	}
+}
+
+func TestAnalyzeEmptyRun(t *testing.T) {
+	report := Analyze(&Run{Label: "empty"}, nil)
+	if report.Transactions != 0 || report.QOSSatisfactionRate != 0 || report.MeanPrice != 0 ||
+		report.Rating.Count != 0 {
+		t.Errorf("report of an empty run = %+v", report)
+	}
+}
@@ -0,0 +1,189 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+// Output formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+	FormatCSV   = "csv"
+)
+
+// row is a single named metric
+type row struct {
+	name  string
+	value float64
+}
+
+// rows flattens the report in a fixed order, per provider rows are sorted by
+// provider id
+func (r Report) rows() []row {
+	rows := []row{
+		{"transactions", float64(r.Transactions)},
+	}
+	for _, state := range sortedKeys(r.States) {
+		rows = append(rows, row{"state/" + state, float64(r.States[state])})
+	}
+	rows = append(rows,
+		row{"qos_satisfaction_rate", r.QOSSatisfactionRate},
+		row{"mean_price", r.MeanPrice},
+		row{"mean_price_consumer", r.MeanPriceConsumer},
+		row{"mean_price_ratio", r.MeanPriceRatio},
+		row{"over_budget_rate", r.OverBudgetRate},
+		row{"faulty_win_rate", r.FaultyWinRate},
+		row{"oracle_judged", float64(r.OracleJudged)},
+		row{"oracle_match_rate", r.OracleMatchRate},
+		row{"mean_regret", r.MeanRegret},
+		row{"mean_rank", r.MeanRank},
+	)
+	for _, id := range sortedKeys(r.WinnerShare) {
+		rows = append(rows, row{"winner_share/" + id, r.WinnerShare[id]})
+	}
+	rows = append(rows, r.ConsensusLatency.rows("consensus_latency_ms")...)
+	rows = append(rows, r.EndToEndLatency.rows("end_to_end_latency_ms")...)
+	rows = append(rows, r.VoteRoundLatency.rows("vote_round_latency_ms")...)
+	rows = append(rows, r.RTT.rows("rtt_ms")...)
+	rows = append(rows, r.ThroughputCV.rows("throughput_cv")...)
+	rows = append(rows, r.Jitter.rows("jitter_ms")...)
+	rows = append(rows, r.Loss.rows("loss_percent")...)
+	rows = append(rows, r.Rating.rows("rating")...)
+	for _, bucket := range r.RatingHistogram {
+		rows = append(rows, row{fmt.Sprintf("rating_histogram/%.1f-%.1f", bucket.From, bucket.To), float64(bucket.Count)})
+	}
+	for _, dimension := range sortedKeys(r.RatingDimensions) {
+		rows = append(rows, row{"rating_dimension/" + dimension, r.RatingDimensions[dimension]})
+	}
+	return rows
+}
+
+func (s summary) rows(prefix string) []row {
+	return []row{
+		{prefix + "/count", float64(s.Count)},
+		{prefix + "/mean", s.Mean},
+		{prefix + "/min", s.Min},
+		{prefix + "/p50", s.P50},
+		{prefix + "/p90", s.P90},
+		{prefix + "/p95", s.P95},
+		{prefix + "/p99", s.P99},
+		{prefix + "/max", s.Max},
+	}
+}
+
+// Write writes the reports side by side in format, with a delta column when
+// two runs are compared
+func Write(w io.Writer, format string, reports []Report) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if len(reports) == 1 {
+			return encoder.Encode(reports[0])
+		}
+		return encoder.Encode(reports)
+	case FormatTable, FormatCSV:
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+
+	header, table := compare(reports)
+	if format == FormatCSV {
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		if err := writer.WriteAll(table); err != nil {
+			return err
+		}
+		return writer.Error()
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, line := range append([][]string{header}, table...) {
+		for idx, cell := range line {
+			if idx > 0 {
+				fmt.Fprint(writer, "\t")
+			}
+			fmt.Fprint(writer, cell)
+		}
+		fmt.Fprintln(writer)
+	}
+	return writer.Flush()
+}
+
+// compare lines up the rows of every report, a metric missing from a report
+// (e.g. a provider that never won) is left empty
+func compare(reports []Report) ([]string, [][]string) {
+	header := []string{"metric"}
+	names := []string{}
+	values := make([]map[string]float64, len(reports))
+	for idx, report := range reports {
+		header = append(header, report.Label)
+		values[idx] = map[string]float64{}
+		for _, row := range report.rows() {
+			if !containsName(names, row.name) {
+				names = append(names, row.name)
+			}
+			values[idx][row.name] = row.value
+		}
+	}
+	if len(reports) == 2 {
+		header = append(header, "delta")
+	}
+
+	table := [][]string{}
+	for _, name := range names {
+		line := []string{name}
+		for idx := range reports {
+			val, exists := values[idx][name]
+			if !exists {
+				line = append(line, "")
+				continue
+			}
+			line = append(line, formatFloat(val))
+		}
+		if len(reports) == 2 {
+			first, inFirst := values[0][name]
+			second, inSecond := values[1][name]
+			if inFirst && inSecond {
+				line = append(line, formatFloat(second-first))
+			} else {
+				line = append(line, "")
+			}
+		}
+		table = append(table, line)
+	}
+	return header, table
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatFloat rounds to 6 significant digits without switching to exponent
+// notation, provider prices are around 1e-7
+func formatFloat(val float64) string {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(val, 'g', 6, 64), 64)
+	return strconv.FormatFloat(rounded, 'f', -1, 64)
+}
@@ -1,14 +1,16 @@
 package consumer
 
 import (
-	"fmt"
 	"math"
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
 )
 
 func (c *consumer) calculateFFSfinal(tran transaction) (FFS, providerInfo) {
 	FFSfinal := FFS{}
 	winner := providerInfo{}
 	highestFF := -10.0
+	log := c.log.With(logging.Event(events.INFORM_VOTE), logging.Transaction(tran.transactionID))
 	for _, targetProvider := range tran.providerList {
 		populationN := 0.0
 		FFsum := 0.0
@@ -19,14 +21,14 @@ func (c *consumer) calculateFFSfinal(tran transaction) (FFS, providerInfo) {
 
 			FFS, exists := tran.allFFS[scorerProvider.ProviderID]
 			if !exists {
-				fmt.Println("FFS not found for scorer provider:", scorerProvider.ProviderID)
+				log.Debug("FFS not found for scorer provider", logging.KeyPeer, scorerProvider.ProviderID)
 				continue
 			}
-			fmt.Println("provider id:", scorerProvider.ProviderID, ";FFS:", FFS)
+			log.Debug("received FFS", logging.KeyPeer, scorerProvider.ProviderID, "ffs", FFS)
 
 			FF, exists := FFS[targetProvider.ProviderID]
 			if !exists {
-				fmt.Println("FF not found for target provider:", targetProvider.ProviderID)
+				log.Debug("FF not found for target provider", logging.KeyPeer, targetProvider.ProviderID)
 				continue
 			}
 
@@ -46,13 +48,13 @@ func (c *consumer) calculateFFSfinal(tran transaction) (FFS, providerInfo) {
 
 			FFS, exists := tran.allFFS[scorerProvider.ProviderID]
 			if !exists {
-				fmt.Println("FFS not found for scorer provider:", scorerProvider.ProviderID)
+				log.Debug("FFS not found for scorer provider", logging.KeyPeer, scorerProvider.ProviderID)
 				continue
 			}
 
 			FF, exists := FFS[targetProvider.ProviderID]
 			if !exists {
-				fmt.Println("FF not found for target provider:", targetProvider.ProviderID)
+				log.Debug("FF not found for target provider", logging.KeyPeer, targetProvider.ProviderID)
 				continue
 			}
 
@@ -70,13 +72,13 @@ func (c *consumer) calculateFFSfinal(tran transaction) (FFS, providerInfo) {
 
 			FFS, exists := tran.allFFS[scorerProvider.ProviderID]
 			if !exists {
-				fmt.Println("FFS not found for scorer provider:", scorerProvider.ProviderID)
+				log.Debug("FFS not found for scorer provider", logging.KeyPeer, scorerProvider.ProviderID)
 				continue
 			}
 
 			FF, exists := FFS[targetProvider.ProviderID]
 			if !exists {
-				fmt.Println("FF not found for target provider:", targetProvider.ProviderID)
+				log.Debug("FF not found for target provider", logging.KeyPeer, targetProvider.ProviderID)
 				continue
 			}
 
@@ -87,9 +89,20 @@ func (c *consumer) calculateFFSfinal(tran transaction) (FFS, providerInfo) {
 
 		}
 
-		FFSfinal[targetProvider.ProviderID] = FFfinal / sampleN
+		// Nobody scored the provider when it is the only one left, it is
+		// neither trusted nor distrusted
+		FFSfinal[targetProvider.ProviderID] = 0
+		if sampleN > 0 {
+			FFSfinal[targetProvider.ProviderID] = FFfinal / sampleN
+		}
 
-		if FFSfinal[targetProvider.ProviderID] > highestFF {
+		// A saturated provider has no ports for the flow, it only wins when
+		// every provider is saturated
+		better := FFSfinal[targetProvider.ProviderID] > highestFF
+		if winner.ProviderID != "" && targetProvider.Saturated != winner.Saturated {
+			better = !targetProvider.Saturated
+		}
+		if better {
 			highestFF = FFSfinal[targetProvider.ProviderID]
 			winner = targetProvider
 		}
@@ -101,9 +114,3 @@ func (c *consumer) calculateFFSfinal(tran transaction) (FFS, providerInfo) {
 func calculateZScore(FF float64, mu float64, sigma float64) float64 {
 	return (FF - mu) / (sigma + math.SmallestNonzeroFloat64)
 }
-
-func calculateConsumerRating(actualUplink float64, actualDownlink float64, uplinkReq float64, downlinkReq float64) float64 {
-	uplinkRating := math.Min(1, actualUplink/uplinkReq)
-	downlinkRating := math.Min(1, actualDownlink/downlinkReq)
-	return (uplinkRating + downlinkRating) / 2
-}
@@ -0,0 +1,133 @@
+package consumer
+
+import (
+	"time"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/throughput"
+	"wifi-trade-consensus/internal/pkg/units"
+)
+
+// NewOptionsFromConfig decodes and validates the settings of a consumer config
+// file
+func NewOptionsFromConfig(settings map[string]interface{}) (*options, error) {
+	options := options{}
+	if err := config.Decode(settings, &options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+func (options options) validate() error {
+	problems := config.Problems{}
+	if options.ID == "" {
+		problems.Add("id must be set")
+	}
+	problems.Address("address", options.Address)
+	problems.OptionalAddress("advertise_address", options.AdvertiseAddress)
+	if options.Iperf3ServerCount < 0 {
+		problems.Add("iperf3_server_count must be >= 0, got %d", options.Iperf3ServerCount)
+	}
+	problems.PortRange("iperf3_base_server_port", options.Iperf3BaseServerPort, options.Iperf3ServerCount)
+	if _, err := throughput.New(options.ThroughputEngine); err != nil {
+		problems.Merge(err)
+	}
+	problems.Merge(options.QOSRequirements.validate())
+	if options.ThroughputEngine == throughput.EngineNative && options.QOSRequirements.Protocol == string(throughput.UDP) {
+		problems.Add("protocol %s needs the %s throughput_engine", throughput.UDP, throughput.EngineIperf3)
+	}
+	problems.Positive("tau", options.Tau)
+	problems.OptionalAddress("metrics_address", options.MetricsAddress)
+	if options.DrainTimeout < 0 {
+		problems.Add("drain_timeout must be >= 0, got %d", options.DrainTimeout)
+	}
+	problems.Merge(options.Rating.validate())
+	problems.Merge(options.Servers.Validate())
+	problems.Merge(options.Retention.Validate())
+	problems.Merge(options.Logging.Validate())
+	return problems.Err()
+}
+
+// validate checks the QoS requirements of a TRIGGER_BUY or the config file.
+// The price and speeds divide the fittingness of every provider and epsilon
+// its price fittingness, so none may be 0
+func (qos qosRequirements) validate() error {
+	problems := config.Problems{}
+	problems.Positive("price", qos.PriceConsumer)
+	problems.Positive("uplink", qos.UplinkSpeedConsumer)
+	problems.Positive("downlink", qos.DownlinkSpeedConsumer)
+	problems.Closed("mu", qos.Mu, 0, 1)
+	problems.Closed("delta", qos.Delta, 0, 1)
+	if qos.Epsilon < 1 {
+		problems.Add("epsilon must be >= 1, got %v", qos.Epsilon)
+	}
+	switch throughput.Protocol(qos.Protocol) {
+	case "", throughput.TCP:
+	case throughput.UDP:
+		// iperf3 would otherwise send udp at its 1 Mbit/s default
+		if qos.Bitrate <= 0 && !qos.RateCapped {
+			problems.Add("protocol %s needs a bitrate or rate_capped", throughput.UDP)
+		}
+	default:
+		problems.Add("protocol must be %s or %s, got %q", throughput.TCP, throughput.UDP, qos.Protocol)
+	}
+	if qos.MaxJitter < 0 {
+		problems.Add("max_jitter must be >= 0, got %v", qos.MaxJitter)
+	}
+	problems.Closed("max_loss", qos.MaxLoss, 0, 100)
+	if qos.MaxRTT < 0 {
+		problems.Add("max_rtt must be >= 0, got %v", qos.MaxRTT)
+	}
+	if qos.Duration < 0 {
+		problems.Add("duration must be >= 0, got %v", qos.Duration)
+	}
+	return problems.Err()
+}
+
+// transfer describes the transfer of one direction of the flow. A session
+// lasts Duration instead of moving FlowSize, an explicit Bitrate caps both
+// directions and RateCapped caps each at its purchased speed
+func (qos qosRequirements) transfer(title string, direction throughput.Direction) throughput.Transfer {
+	transfer := throughput.Transfer{
+		Title:     title,
+		Size:      qos.FlowSize,
+		Duration:  time.Duration(qos.Duration * float64(time.Second)),
+		Direction: direction,
+		Protocol:  throughput.Protocol(qos.Protocol),
+		Bitrate:   qos.Bitrate,
+	}
+	if transfer.Bitrate == 0 && qos.RateCapped {
+		speed := qos.UplinkSpeedConsumer
+		if direction == throughput.Reverse {
+			speed = qos.DownlinkSpeedConsumer
+		}
+		transfer.Bitrate = units.Speed(speed)
+	}
+	return transfer
+}
+
+// ValidateConfigFile checks a consumer config file without starting a consumer
+func ValidateConfigFile(path string) error {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return err
+	}
+	_, err = NewOptionsFromConfig(settings)
+	return err
+}
+
+// AddressOfConfigFile returns the id and listen address of the consumer config
+// file
+func AddressOfConfigFile(path string) (string, string, error) {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return "", "", err
+	}
+	options, err := NewOptionsFromConfig(settings)
+	if err != nil {
+		return "", "", err
+	}
+	return options.ID, options.Address, nil
+}
@@ -1,23 +1,28 @@
 package consumer
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
-	"os"
-	"os/exec"
-	"os/signal"
-	"path/filepath"
+	"strconv"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 	"wifi-trade-consensus/internal/pkg/events"
-	"wifi-trade-consensus/internal/pkg/iperf3"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/oracle"
 	"wifi-trade-consensus/internal/pkg/payload"
+	"wifi-trade-consensus/internal/pkg/retention"
+	"wifi-trade-consensus/internal/pkg/throughput"
+	phases "wifi-trade-consensus/internal/pkg/timeline"
+	"wifi-trade-consensus/internal/pkg/tracing"
+	"wifi-trade-consensus/internal/pkg/units"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 )
 
 type PayloadMeta = payload.Meta
@@ -28,9 +33,27 @@ type FFS map[string]float64 // index: provider id
 
 type transactionEndPayload struct {
 	PayloadMeta
-	Rating        float64 `json:"rating"`
+	Rating          float64                `json:"rating"`
+	RatingBreakdown map[string]ratingScore `json:"rating_breakdown,omitempty"` // index: dimension
+	UplinkSpeed     float64                `json:"uplink_speed"`
+	DownlinkSpeed   float64                `json:"downlink_speed"`
+	Refused         bool                   `json:"refused,omitempty"` // the winner refused the flow, which isn't rated
+}
+
+// triggerResultPayload reports the outcome of a TRIGGER_BUY to the trigger
+type triggerResultPayload struct {
+	PayloadMeta
+	ConsumerID    string  `json:"consumer_id"`
+	Success       bool    `json:"success"`
+	FailureReason string  `json:"failure_reason,omitempty"`
+	WinnerID      string  `json:"winner_id"`
+	Price         float64 `json:"price"`
 	UplinkSpeed   float64 `json:"uplink_speed"`
 	DownlinkSpeed float64 `json:"downlink_speed"`
+	Rating        float64 `json:"rating"`
+	// Phase latencies in ms, see durations
+	ConsensusLatency float64 `json:"consensus_latency"`
+	EndToEndLatency  float64 `json:"end_to_end_latency"`
 }
 
 type startFlowPayload struct {
@@ -38,9 +61,19 @@ type startFlowPayload struct {
 	Winner providerInfo `json:"winner"`
 }
 
+// startFlowReply is the answer of the winner to START_FLOW
+type startFlowReply struct {
+	Ports []int  `json:"ports"`           // leased data-plane ports, uplink then downlink
+	Error string `json:"error,omitempty"` // why none were leased
+}
+
+// startFlowReplyTimeout bounds the wait for the winner to lease the ports
+const startFlowReplyTimeout = 5 * time.Second
+
 type buyPayload struct {
 	PayloadMeta
 	ProviderList providers `json:"provider_list"`
+	Seed         int64     `json:"seed,omitempty"` // random seed of the trigger, recorded with the transaction
 	qosRequirements
 }
 
@@ -51,17 +84,51 @@ type informVotePayload struct {
 	Price  float64 `json:"price"`
 }
 
+// scenarioEventPayload is a scenario timeline event sent by the trigger
+type scenarioEventPayload struct {
+	PayloadMeta
+	scenarioEvent
+}
+
+type scenarioEvent struct {
+	Scenario    string   `json:"scenario"`
+	At          string   `json:"at"` // offset from the start of the scenario
+	EventType   string   `json:"event_type"`
+	Targets     []string `json:"targets"`
+	Description string   `json:"description"`
+	Timestamp   int64    `json:"timestamp"` // unix ms
+}
+
 type consumer struct {
 	id                   string
 	address              string
+	advertiseAddress     string // address providers use to reach this consumer
 	transactions         transactions
 	qosRequirements      qosRequirements
 	iperf3BaseServerPort string
 	iperf3ServerCount    int
-	iperf3Cmds           []*exec.Cmd
+	engine               throughput.Engine // also the client engine when a winner doesn't say
+	servers              []throughput.Server
+	ratingModel          ratingModel
+	serverOptions        throughput.ServerOptions
 	mutex                sync.Mutex
 	outputDir            string
 	tau                  float64
+	resultsCSV           bool
+	results              *resultsLog
+	oraclePath           string
+	oracle               *oracle.Oracle
+	metricsAddress       string
+	metrics              *consumerMetrics
+	log                  *slog.Logger
+	tracePath            string
+	tracer               *tracing.Tracer
+	retention            retention.Options
+	options              options // config snapshot, recorded in the results header
+	// Lifecycle
+	listener net.Listener
+	handlers atomic.Int32 // connections being handled
+	draining atomic.Bool  // set once Shutdown starts, new transactions are turned away
 }
 
 type transactions map[string]transaction
@@ -71,11 +138,26 @@ type transaction struct {
 	transactionTime int64
 	consumerID      string
 	consumerAddress string
+	triggerAddress  string // where to send TRIGGER_RESULT, empty if the trigger doesn't collect results
+	seed            int64  // of the trigger that sent the BUY, 0 if unknown
 	providerList    providers
 	providerCount   int
 	qosRequirements qosRequirements
 	allFFS          allFFS
-	FlowMetrics     flowMetrics `json:"flow_metrics"`
+	FFSfinal        FFS
+	rating          float64
+	ratingBreakdown map[string]ratingScore
+	failureReasons  []string
+	flowStartTime   int64 // unix ms
+	flowEndTime     int64
+	endTime         int64
+	decided         bool // every INFORM_VOTE is in and the winner is being decided
+	completed       bool
+	timeline        *phases.Timeline
+	span            *tracing.Span   // TRIGGER_BUY received to the transaction recorded
+	verdict         *oracle.Verdict // nil without an oracle
+	FlowMetrics     flowMetrics     `json:"flow_metrics"`
+	ScenarioEvents  []scenarioEvent `json:"scenario_events"` // scenario events that happened while in flight
 }
 
 type providers []providerInfo
@@ -85,135 +167,177 @@ type providerInfo struct {
 	Address              string  `json:"address"`
 	Iperf3BaseServerPort string  `json:"iperf3_base_server_port"`
 	Iperf3ServerCount    int     `json:"iperf3_server_count"`
+	ThroughputEngine     string  `json:"throughput_engine,omitempty"` // engine of the servers, iperf3 if empty
+	Saturated            bool    `json:"saturated,omitempty"`         // no ports free for another flow
+	HealthyPorts         []int   `json:"healthy_ports,omitempty"`     // ports whose servers passed their health check
 	Price                float64 `json:"price"`
 }
 
+// fallbackPorts are tried when the provider leased no ports for the flow, its
+// healthy ports or its whole range if it didn't advertise them
+func (info providerInfo) fallbackPorts() []int {
+	if len(info.HealthyPorts) > 0 {
+		return info.HealthyPorts
+	}
+	basePort, err := strconv.Atoi(info.Iperf3BaseServerPort)
+	if err != nil {
+		return nil
+	}
+	ports := make([]int, 0, info.Iperf3ServerCount)
+	for i := 0; i < info.Iperf3ServerCount; i++ {
+		ports = append(ports, basePort+i)
+	}
+	return ports
+}
+
 // mapstructure tags are for config file mapping
 // json tags are for tcp body mapping
 type options struct {
-	ID                   string          `mapstructure:"id" json:"id"`
-	Address              string          `mapstructure:"address" json:"address"`
-	Iperf3BaseServerPort string          `mapstructure:"iperf3_base_server_port" json:"iperf3_base_server_port"`
-	Iperf3ServerCount    int             `mapstructure:"iperf3_server_count" json:"iperf3_server_count"`
-	QOSRequirements      qosRequirements `mapstructure:"params" json:"params"`
-	OutputDir            string          `mapstructure:"output_dir" json:"output_dir"`
-	Tau                  float64         `mapstructure:"tau" json:"tau"`
+	ID                   string                   `mapstructure:"id" json:"id"`
+	Address              string                   `mapstructure:"address" json:"address"`
+	AdvertiseAddress     string                   `mapstructure:"advertise_address" json:"advertise_address"` // defaults to address
+	Iperf3BaseServerPort string                   `mapstructure:"iperf3_base_server_port" json:"iperf3_base_server_port"`
+	Iperf3ServerCount    int                      `mapstructure:"iperf3_server_count" json:"iperf3_server_count"`
+	ThroughputEngine     string                   `mapstructure:"throughput_engine" json:"throughput_engine"` // iperf3 (default) or native
+	QOSRequirements      qosRequirements          `mapstructure:",squash" json:"params"`
+	OutputDir            string                   `mapstructure:"output_dir" json:"output_dir"`
+	Tau                  float64                  `mapstructure:"tau" json:"tau"`
+	ResultsCSV           bool                     `mapstructure:"results_csv" json:"results_csv"`         // also write results as CSV
+	OraclePath           string                   `mapstructure:"oracle_path" json:"oracle_path"`         // ground truth to judge consensus with, optional
+	MetricsAddress       string                   `mapstructure:"metrics_address" json:"metrics_address"` // serve prometheus metrics, optional
+	TracePath            string                   `mapstructure:"trace_path" json:"trace_path"`           // export spans to this file, optional
+	DrainTimeout         int                      `mapstructure:"drain_timeout" json:"drain_timeout"`     // s the transactions in flight get to finish on shutdown
+	Rating               ratingModel              `mapstructure:"rating" json:"rating"`                   // weights and curves of the rating dimensions
+	Servers              throughput.ServerOptions `mapstructure:",squash" json:"servers"`
+	Retention            retention.Options        `mapstructure:",squash" json:"retention"`
+	Logging              logging.Options          `mapstructure:",squash" json:"logging"`
 }
 
 type qosRequirements struct {
-	PriceConsumer         float64 `mapstructure:"price" json:"price"`         // consumer price requirement
-	UplinkSpeedConsumer   float64 `mapstructure:"uplink" json:"uplink"`       // consumer uplink speed requirement
-	DownlinkSpeedConsumer float64 `mapstructure:"downlink" json:"downlink"`   // consumer downlink speed requirement
-	Mu                    float64 `mapstructure:"mu" json:"mu"`               // uplink weight
-	Delta                 float64 `mapstructure:"delta" json:"delta"`         // downlink weight
-	Epsilon               float64 `mapstructure:"epsilon" json:"epsilon"`     // price range multiplier limit
-	FlowSize              string  `mapstructure:"flow_size" json:"flow_size"` // size of data to upload/download to/from provider
+	PriceConsumer         float64       `mapstructure:"price" json:"price"`                       // consumer price requirement
+	UplinkSpeedConsumer   float64       `mapstructure:"uplink" json:"uplink"`                     // consumer uplink speed requirement
+	DownlinkSpeedConsumer float64       `mapstructure:"downlink" json:"downlink"`                 // consumer downlink speed requirement
+	Mu                    float64       `mapstructure:"mu" json:"mu"`                             // uplink weight
+	Delta                 float64       `mapstructure:"delta" json:"delta"`                       // downlink weight
+	Epsilon               float64       `mapstructure:"epsilon" json:"epsilon"`                   // price range multiplier limit
+	FlowSize              units.Bytes   `mapstructure:"flow_size" json:"flow_size"`               // size of data to upload/download to/from provider
+	Duration              float64       `mapstructure:"duration" json:"duration,omitempty"`       // s, a session lasting this long instead of FlowSize
+	RateCapped            bool          `mapstructure:"rate_capped" json:"rate_capped,omitempty"` // cap each direction at the purchased speed
+	Protocol              string        `mapstructure:"protocol" json:"protocol,omitempty"`       // tcp (default) or udp
+	Bitrate               units.Bitrate `mapstructure:"bitrate" json:"bitrate,omitempty"`         // caps both directions, the rate of a udp flow, e.g. 10M
+	MaxJitter             float64       `mapstructure:"max_jitter" json:"max_jitter,omitempty"`   // ms, udp only, not rated if 0
+	MaxLoss               float64       `mapstructure:"max_loss" json:"max_loss,omitempty"`       // percent of datagrams lost, udp only, not rated if 0
+	MaxRTT                float64       `mapstructure:"max_rtt" json:"max_rtt,omitempty"`         // ms, not rated if 0
 }
 
 type flowMetrics struct {
-	ProviderInfo              providerInfo `json:"provider_info"`
-	Price                     float64      `json:"price"`
-	PriceConsumer             float64      `json:"price_consumer"`
-	AverageUplinkSpeed        float64      `json:"average_uplink"`
-	AverageDownlinkSpeed      float64      `json:"average_downlink"`
-	TransactionStartTimestamp int64        `json:"transaction_start_timestamp"`
+	ProviderInfo              providerInfo    `json:"provider_info"`
+	Price                     float64         `json:"price"`
+	PriceConsumer             float64         `json:"price_consumer"`
+	AverageUplinkSpeed        float64         `json:"average_uplink"`
+	AverageDownlinkSpeed      float64         `json:"average_downlink"`
+	Uplink                    throughput.Flow `json:"uplink"` // as received by the winner
+	Downlink                  throughput.Flow `json:"downlink"`
+	TransactionStartTimestamp int64           `json:"transaction_start_timestamp"`
 }
 
-func NewOptionsFromConfigFile() (*options, error) {
-	options := options{}
-	qosRequirements := qosRequirements{}
-
-	viper.SetConfigName("config") // Name of config file (without extension)
-	viper.SetConfigType("json")   // REQUIRED if the config file does not have the extension in the name
-	viper.AddConfigPath(".")      // Path to look for the config file in
-	viper.AddConfigPath("cmd/consumer")
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// config not found, ignore err
-			return nil, fmt.Errorf("config file not found")
-		} else {
-			// other errors, ignore err
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
-	}
-
-	err := viper.Unmarshal(&qosRequirements)
+func New(opt options) *consumer {
+	logger, err := logging.New(opt.ID, opt.Logging)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal qosRequirements config file: %w", err)
+		logger, _ = logging.New(opt.ID, logging.Options{})
+		logger.Warn("falling back to default logging", logging.Err(err))
 	}
 
-	err = viper.Unmarshal(&options)
+	engine, err := throughput.New(opt.ThroughputEngine)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal options config file: %w", err)
+		engine, _ = throughput.New(throughput.EngineIperf3)
+		logger.Warn("falling back to the iperf3 engine", logging.Err(err))
 	}
 
-	options.QOSRequirements = qosRequirements
-
-	return &options, nil
-}
-
-func New(opt options) consumer {
-	consumer := consumer{
+	consumer := &consumer{
 		id:                   opt.ID,
 		address:              opt.Address,
+		advertiseAddress:     opt.AdvertiseAddress,
 		transactions:         make(transactions),
 		qosRequirements:      opt.QOSRequirements,
 		iperf3BaseServerPort: opt.Iperf3BaseServerPort,
 		iperf3ServerCount:    opt.Iperf3ServerCount,
+		engine:               engine,
+		serverOptions:        opt.Servers,
+		ratingModel:          opt.Rating,
 		outputDir:            opt.OutputDir,
 		tau:                  opt.Tau,
+		resultsCSV:           opt.ResultsCSV,
+		oraclePath:           opt.OraclePath,
+		metricsAddress:       opt.MetricsAddress,
+		tracePath:            opt.TracePath,
+		metrics:              newConsumerMetrics(opt.ID),
+		log:                  logger,
+		retention:            opt.Retention,
+		options:              opt,
+	}
+	if consumer.advertiseAddress == "" {
+		consumer.advertiseAddress = opt.Address
 	}
-
-	// Register cleanup for interrupt signal i.e. Ctrl^c
-	channel := make(chan os.Signal)
-	signal.Notify(channel, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-channel
-		if err := consumer.persistResults(); err != nil {
-			fmt.Println("failed to persist results:", err)
-		}
-		if err := consumer.cleanup(); err != nil {
-			fmt.Println("failed to cleanup:", err)
-		}
-		fmt.Println("preparing to shutdown...")
-		time.Sleep(time.Second * 3)
-		os.Exit(1)
-	}()
 
 	return consumer
 }
 
-func (c *consumer) NewListener() error {
+// Logger is the logger of the consumer, tagged with its id
+func (c *consumer) Logger() *slog.Logger {
+	return c.log
+}
+
+// NewListener handles the connections of the consumer until ctx is cancelled,
+// the listener stays open for the transactions in flight until Shutdown
+func (c *consumer) NewListener(ctx context.Context) error {
 	l, err := net.Listen("tcp", c.address)
 	if err != nil {
 		return fmt.Errorf("failed to create new listener: %w", err)
 	}
-	defer l.Close()
+	c.mutex.Lock()
+	c.listener = l
+	c.mutex.Unlock()
+	c.log.Info("listening for new connections", "address", c.address)
 
+	go c.accept(l)
+	<-ctx.Done()
+	return nil
+}
+
+// accept handles the connections of l until it is closed
+func (c *consumer) accept(l net.Listener) {
 	for {
 		// Wait for a connection
-		fmt.Println("listening for new connection at", c.address)
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			fmt.Println("failed to accept new connection:", err)
+			c.log.Error("failed to accept new connection", logging.Err(err))
+			continue
 		}
 		// Concurrently handle the new connections
+		c.handlers.Add(1)
 		go func(conn net.Conn) {
+			defer c.handlers.Add(-1)
 			defer conn.Close()
 
+			remote := slog.String("remote", conn.RemoteAddr().String())
 			data, err := io.ReadAll(conn)
 			if err != nil {
-				fmt.Println("failed to read connection data:", err)
+				c.log.Error("failed to read connection data", remote, logging.Err(err))
 			}
 
 			payloadMeta := payload.Meta{}
 			err = json.Unmarshal(data, &payloadMeta)
 			if err != nil {
-				fmt.Printf("failed to unmarshal payload meta from %s: %v\n", conn.RemoteAddr().String(), err)
+				c.log.Error("failed to unmarshal payload meta", remote, logging.Err(err))
 				return
 			}
-			fmt.Printf("received payload meta from %s: %v\n", conn.RemoteAddr(), payloadMeta)
+			log := c.log.With(logging.Event(payloadMeta.PayloadType), logging.Transaction(payloadMeta.TransactionID),
+				logging.KeyPeer, payloadMeta.OriginID)
+			c.metrics.received(payloadMeta.PayloadType)
 
 			switch payloadMeta.PayloadType {
 
@@ -221,72 +345,156 @@ func (c *consumer) NewListener() error {
 			case events.TRIGGER_BUY:
 				buyPayload := buyPayload{}
 				if err := json.Unmarshal(data, &buyPayload); err != nil {
-					fmt.Printf("failed to unmarshal TRIGGER_BUY payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
+					return
+				}
+				log.Info("received payload", "payload", buyPayload)
+				// New transactions are turned away while shutting down
+				if c.draining.Load() {
+					log.Warn("shutting down, rejecting payload")
+					c.sendTriggerResult(transaction{
+						transactionID:  buyPayload.TransactionID,
+						triggerAddress: buyPayload.OriginAddress,
+					}, triggerResultPayload{FailureReason: errShuttingDown.Error()})
+					return
+				}
+				// Requirements the fittingness can't be computed for are turned away
+				if err := buyPayload.qosRequirements.validate(); err != nil {
+					log.Warn("invalid QoS requirements, rejecting payload", logging.Err(err))
+					c.sendTriggerResult(transaction{
+						transactionID:  buyPayload.TransactionID,
+						triggerAddress: buyPayload.OriginAddress,
+					}, triggerResultPayload{FailureReason: "invalid QoS requirements: " + err.Error()})
 					return
 				}
-				fmt.Printf("received TRIGGER_BUY payload from %s: %#v\n", conn.RemoteAddr().String(), buyPayload)
 				c.triggerBuyEvent(buyPayload)
 
 			// Handle INFORM_VOTE event
 			case events.INFORM_VOTE:
 				informVotePayload := informVotePayload{}
 				if err := json.Unmarshal(data, &informVotePayload); err != nil {
-					fmt.Printf("failed to unmarshal INFORM_VOTE payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
 					return
 				}
-				fmt.Printf("received INFORM_VOTE payload from %s: %v\n", conn.RemoteAddr().String(), informVotePayload)
+				log.Info("received payload", "payload", informVotePayload)
 				c.handleInformVote(informVotePayload)
 
+			// Handle LEAVE event
+			case events.LEAVE:
+				log.Info("received payload", "payload", payloadMeta)
+				c.handleLeave(payloadMeta)
+
+			// Handle SCENARIO_EVENT event
+			case events.SCENARIO_EVENT:
+				scenarioEventPayload := scenarioEventPayload{}
+				if err := json.Unmarshal(data, &scenarioEventPayload); err != nil {
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
+					return
+				}
+				log.Info("received payload", "payload", scenarioEventPayload)
+				c.handleScenarioEvent(scenarioEventPayload)
+
 			// Handle unknown events
 			default:
-				fmt.Printf("failed to determine event type: %v", payloadMeta)
+				log.Error("failed to determine event type", remote, "payload_type", payloadMeta.PayloadType)
 				return
 			}
 		}(conn)
 	}
 }
 
-func (c *consumer) NewIperf3Server() error {
-	cmds, err := iperf3.StartServers(c.iperf3BaseServerPort, c.iperf3ServerCount)
+// NewThroughputServer starts the measurement servers of throughput_engine
+func (c *consumer) NewThroughputServer() error {
+	servers, err := c.engine.StartServers(c.iperf3BaseServerPort, c.iperf3ServerCount, c.serverOptions)
 	if err != nil {
-		return fmt.Errorf("failed to start iperf3 server: %w", err)
+		return fmt.Errorf("failed to start %s server: %w", c.engine.Name(), err)
 	}
-	c.iperf3Cmds = cmds
+	c.servers = servers
 
 	return nil
 }
 
+// cleanup flushes the trace file and stops the throughput servers
 func (c *consumer) cleanup() error {
-	fmt.Println("running cleanup...")
-	for _, cmd := range c.iperf3Cmds {
-		if err := iperf3.StopServer(cmd); err != nil {
-			return fmt.Errorf("failed to stop iperf3 server: %w", err)
+	c.log.Info("running cleanup")
+	// Export the transactions still in flight before closing the trace file
+	c.mutex.Lock()
+	for _, transaction := range c.transactions {
+		if !transaction.completed {
+			transaction.span.SetError(errTransactionIncomplete)
+			transaction.span.End()
 		}
-
 	}
-	fmt.Println("cleanup ran")
+	c.mutex.Unlock()
+	if err := c.tracer.Close(); err != nil {
+		return fmt.Errorf("failed to close trace file: %w", err)
+	}
+	for _, server := range c.servers {
+		if err := server.Stop(); err != nil {
+			return fmt.Errorf("failed to stop %s server: %w", c.engine.Name(), err)
+		}
+	}
+	c.log.Info("cleanup ran")
 	return nil
 }
 
-func (c *consumer) persistResults() error {
-	fmt.Println("persisting results to file...")
-	err := os.MkdirAll(c.outputDir, 0777)
-	if err != nil {
-		return fmt.Errorf("failed to make new dir: %w", err)
+// NewOracle loads the ground truth oracle, consensus is only judged when
+// oracle_path is set
+func (c *consumer) NewOracle() error {
+	if c.oraclePath == "" {
+		return nil
 	}
 
-	jsonResult, err := json.Marshal(c.transactions)
+	o, err := oracle.Load(c.oraclePath)
 	if err != nil {
-		return fmt.Errorf("failed to marshal transaction results: %w", err)
+		return err
+	}
+	c.oracle = o
+	c.log.Info("judging consensus with oracle", "path", c.oraclePath, "providers", len(o.Providers))
+	return nil
+}
+
+// NewTracer exports the spans of this consumer to trace_path, nothing is
+// traced when it isn't set
+func (c *consumer) NewTracer() error {
+	if c.tracePath == "" {
+		return nil
 	}
 
-	timeString := time.Now().Format("2006-01-02--15-04-05") // Golang weird time format constants
-	filename := filepath.Join(c.outputDir, "consumer_transactions--"+timeString)
-	err = os.WriteFile(filename, jsonResult, 0777)
+	tracer, err := tracing.New(c.id, c.tracePath)
 	if err != nil {
-		return fmt.Errorf("failed to write transactions to file: %w", err)
+		return err
+	}
+	c.tracer = tracer
+	c.log.Info("exporting spans", "path", c.tracePath)
+	return nil
+}
+
+// persistResults records the transactions still in flight as incomplete and
+// closes the results log, completed transactions are already recorded
+func (c *consumer) persistResults() error {
+	if c.results == nil {
+		return nil
+	}
+	c.log.Info("persisting results to file")
+
+	c.mutex.Lock()
+	incomplete := []transaction{}
+	for _, transaction := range c.transactions {
+		if !transaction.completed {
+			incomplete = append(incomplete, transaction)
+		}
+	}
+	c.mutex.Unlock()
+
+	for _, transaction := range incomplete {
+		c.recordTransaction(transaction, transactionIncomplete)
+	}
+
+	if err := c.results.close(); err != nil {
+		return fmt.Errorf("failed to close results log: %w", err)
 	}
 
-	fmt.Println("results persisted to:", filename)
+	c.log.Info("results persisted", "incomplete_transactions", len(incomplete))
 	return nil
 }
@@ -2,42 +2,80 @@ package consumer
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"wifi-trade-consensus/internal/pkg/events"
-	"wifi-trade-consensus/internal/pkg/iperf3"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/metrics"
+	"wifi-trade-consensus/internal/pkg/oracle"
+	"wifi-trade-consensus/internal/pkg/throughput"
+	phases "wifi-trade-consensus/internal/pkg/timeline"
+	"wifi-trade-consensus/internal/pkg/tracing"
 
 	"github.com/google/uuid"
 )
 
+var (
+	errTransactionNotFound   = errors.New("transaction doesn't exist")
+	errTransactionIncomplete = errors.New("transaction didn't complete before shutdown")
+)
+
 func (c *consumer) triggerBuyEvent(triggerBuyPayload buyPayload) {
 	// Send BUY concurrrently to all providers in list
 	providerList := triggerBuyPayload.ProviderList
 	qosRequirements := triggerBuyPayload.qosRequirements
-	transactionID := uuid.New()
+	// The trigger picks the transaction id when it collects results
+	transactionID := triggerBuyPayload.TransactionID
+	if transactionID == uuid.Nil {
+		transactionID = uuid.New()
+	}
+	log := c.log.With(logging.Event(events.BUY), logging.Transaction(transactionID))
+	span := c.tracer.Start("transaction", tracing.KindServer, transactionID, triggerBuyPayload.Traceparent)
+	span.SetAttribute("providers", len(providerList))
 
 	// Init new transaction record
+	timeline := phases.New()
+	c.mutex.Lock()
 	c.transactions[transactionID.String()] = transaction{
 		transactionID:   transactionID,
 		transactionTime: time.Now().UnixMilli(),
 		consumerID:      c.id,
-		consumerAddress: c.address,
+		consumerAddress: c.advertiseAddress,
+		triggerAddress:  triggerBuyPayload.OriginAddress,
+		seed:            triggerBuyPayload.Seed,
 		providerList:    providerList,
 		providerCount:   len(providerList),
 		allFFS:          make(allFFS),
 		qosRequirements: qosRequirements,
+		timeline:        timeline,
+		span:            span,
 	}
+	c.mutex.Unlock()
 
+	wg := sync.WaitGroup{}
+	sentCount := atomic.Int32{}
 	for _, provider := range providerList {
+		wg.Add(1)
 		go func(provider providerInfo) {
+			defer wg.Done()
+			sendSpan := c.tracer.Start("send BUY", tracing.KindClient, transactionID, span.Traceparent())
+			sendSpan.SetAttribute(logging.KeyPeer, provider.ProviderID)
+			defer sendSpan.End()
+
 			conn, err := net.Dial("tcp", provider.Address)
 			if err != nil {
-				fmt.Printf("failed to dial provider: %v\n", err)
+				c.metrics.sent(events.BUY, metrics.OutcomeDialFailed)
+				sendSpan.SetError(err)
+				log.Error("failed to dial provider", logging.KeyPeer, provider.ProviderID, logging.Err(err))
 				return
-			} else {
-				fmt.Println("sending BUY to", provider.Address)
 			}
 			defer conn.Close()
 
@@ -46,7 +84,8 @@ func (c *consumer) triggerBuyEvent(triggerBuyPayload buyPayload) {
 					PayloadType:   events.BUY,
 					TransactionID: transactionID,
 					OriginID:      c.id,
-					OriginAddress: c.address,
+					OriginAddress: c.advertiseAddress,
+					Traceparent:   sendSpan.Traceparent(),
 				},
 				ProviderList:    providerList,
 				qosRequirements: qosRequirements,
@@ -54,137 +93,227 @@ func (c *consumer) triggerBuyEvent(triggerBuyPayload buyPayload) {
 
 			jsonPayload, err := json.Marshal(payload)
 			if err != nil {
-				fmt.Printf("failed to marshal payload: %v\n", err)
+				log.Error("failed to marshal payload", logging.Err(err))
 				return
 			}
 
 			_, err = conn.Write(jsonPayload)
 			if err != nil {
-				fmt.Printf("failed to send BUY from %s to %s: %v\n", c.address, provider.Address, err)
+				c.metrics.sent(events.BUY, metrics.OutcomeSendFailed)
+				sendSpan.SetError(err)
+				log.Error("failed to send payload", logging.KeyPeer, provider.ProviderID, logging.Err(err))
+				return
 			}
+			c.metrics.sent(events.BUY, metrics.OutcomeSent)
+			log.Debug("sent payload", logging.KeyPeer, provider.ProviderID)
+			sentCount.Add(1)
+			timeline.Mark(phases.BUY_SENT, provider.ProviderID)
 		}(provider)
 	}
+	wg.Wait()
+
+	// Without any provider there is no consensus to wait for
+	if sentCount.Load() == 0 {
+		c.mutex.Lock()
+		transaction, exists := c.transactions[transactionID.String()]
+		if !exists {
+			// Already recorded as timed out by the sweeper
+			c.mutex.Unlock()
+			return
+		}
+		transaction.completed = true
+		transaction.failureReasons = []string{"failed to send BUY to any provider"}
+		transaction.endTime = time.Now().UnixMilli()
+		c.transactions[transactionID.String()] = transaction
+		c.mutex.Unlock()
+
+		c.recordTransaction(transaction, transactionFailed)
+		c.sendTriggerResult(transaction, triggerResultPayload{
+			FailureReason: strings.Join(transaction.failureReasons, "; "),
+		})
+		span.SetError(errors.New(strings.Join(transaction.failureReasons, "; ")))
+		span.End()
+	}
 }
 
 func (c *consumer) handleInformVote(payload informVotePayload) {
 	// TODO: check logic and steps
 	transactionID := payload.TransactionID.String()
-
-	providerCount := c.transactions[transactionID].providerCount
-	providerList := c.transactions[transactionID].providerList
-	allFFS := c.transactions[transactionID].allFFS
+	log := c.log.With(logging.Event(events.INFORM_VOTE), logging.Transaction(payload.TransactionID))
+	span := c.tracer.Start("handle INFORM_VOTE", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute(logging.KeyPeer, payload.OriginID)
+	defer span.End()
 
 	c.mutex.Lock()
+	transaction, exists := c.transactions[transactionID]
+	if !exists {
+		c.mutex.Unlock()
+		span.SetError(errTransactionNotFound)
+		log.Warn("transaction doesn't exist")
+		return
+	}
+	providerList := transaction.providerList
+	allFFS := transaction.allFFS
+
+	transaction.timeline.Mark(phases.INFORM_VOTE_RECEIVED, payload.OriginID)
 	allFFS[payload.OriginID] = payload.FFSnew
 	for idx, provider := range providerList {
 		if provider.ProviderID == payload.OriginID {
-			c.transactions[transactionID].providerList[idx] = payload.providerInfo
-			c.transactions[transactionID].providerList[idx].Price = payload.Price
+			providerList[idx] = payload.providerInfo
+			providerList[idx].Price = payload.Price
 		}
 	}
+	c.mutex.Unlock()
 
-	if len(allFFS) < providerCount {
-		fmt.Printf("received inform vote for transaction %s, but allFFS count is not enough, have %d want %d\n",
-			transactionID, len(allFFS), providerCount)
-		// TODO: create new goroutine with timeout
+	c.decideWinner(transactionID, span, log)
+}
+
+// decideWinner runs the flow with the winner and ends the transaction once
+// the INFORM_VOTE of every provider is in, only the first call that finds
+// them all goes on
+func (c *consumer) decideWinner(transactionID string, span *tracing.Span, log *slog.Logger) {
+	c.mutex.Lock()
+	transaction, exists := c.transactions[transactionID]
+	if !exists || transaction.decided {
 		c.mutex.Unlock()
 		return
-	} else {
-		fmt.Printf("received all inform votes for transaction %s, have %d want %d\n",
-			transactionID, len(allFFS), providerCount)
 	}
-	c.mutex.Unlock()
+	providerList := transaction.providerList
+	allFFS := transaction.allFFS
 
-	transaction, exists := c.transactions[transactionID]
-	if !exists {
-		fmt.Printf("transaction doesn't exist: %s", transactionID)
+	if len(allFFS) < transaction.providerCount {
+		log.Info("waiting for inform votes", "have", len(allFFS), "want", transaction.providerCount)
+		// TODO: create new goroutine with timeout
+		c.mutex.Unlock()
 		return
+	} else {
+		log.Info("received all inform votes", "have", len(allFFS), "want", transaction.providerCount)
 	}
+	transaction.decided = true
+	c.transactions[transactionID] = transaction
+	c.mutex.Unlock()
 
 	// Calculate FFSfinal and determine winner
 	FFSfinal, winner := c.calculateFFSfinal(transaction)
+	transaction.timeline.Mark(phases.WINNER_DECIDED, winner.ProviderID)
 
-	fmt.Println("FFSfinal:", FFSfinal)
+	log.Info("decided winner", "winner", winner.ProviderID, "ffs_final", FFSfinal)
+	span.SetAttribute("winner", winner.ProviderID)
+	span.End()
+	transaction.FFSfinal = FFSfinal
+	transaction.verdict = c.judgeConsensus(transaction, winner)
 
-	// Send START_FLOW event to all peers concurrently
+	// Send START_FLOW event to all peers concurrently, the winner replies with
+	// the ports it leased to the flow
 	for _, provider := range transaction.providerList {
-		go func(provider providerInfo) {
-			conn, err := net.Dial("tcp", provider.Address)
-			if err != nil {
-				fmt.Printf("failed to dial provider: %v\n", err)
-			}
-			defer conn.Close()
-
-			payload := startFlowPayload{
-				PayloadMeta: PayloadMeta{
-					PayloadType:   events.START_FLOW,
-					TransactionID: transaction.transactionID,
-					OriginID:      c.id,
-					OriginAddress: c.address,
-				},
-				Winner: winner,
-			}
+		if provider.ProviderID != winner.ProviderID {
+			go c.sendStartFlow(transaction, provider, winner, log)
+		}
+	}
+	reply := c.sendStartFlow(transaction, winner, winner, log)
+	refused := reply != nil && reply.Error != ""
 
-			jsonPayload, err := json.Marshal(payload)
+	// Get provider throughput server ip and ports, the winner's servers decide
+	// the engine. A winner that leased no ports has its fallback ports tried
+	winnerIP := strings.Split(winner.Address, ":")[0]
+	engine := c.engine
+	if winner.ThroughputEngine != "" {
+		winnerEngine, err := throughput.New(winner.ThroughputEngine)
+		if err != nil {
+			log.Warn("unknown winner throughput engine, using own", "winner", winner.ProviderID, logging.Err(err))
+		} else {
+			engine = winnerEngine
+		}
+	}
+	uplinkPorts := winner.fallbackPorts()
+	downlinkPorts := uplinkPorts
+	if reply != nil && len(reply.Ports) >= 2 {
+		uplinkPorts, downlinkPorts = reply.Ports[:1], reply.Ports[1:2]
+	}
+	flowSpan := c.tracer.Start("flow", tracing.KindInternal, transaction.transactionID, transaction.span.Traceparent())
+	flowSpan.SetAttribute("winner", winner.ProviderID)
+	flowSpan.SetAttribute("flow_size", transaction.qosRequirements.FlowSize.String())
+	if transaction.qosRequirements.Duration > 0 {
+		flowSpan.SetAttribute("duration", transaction.qosRequirements.Duration)
+	}
+	if transaction.qosRequirements.Protocol != "" {
+		flowSpan.SetAttribute("protocol", transaction.qosRequirements.Protocol)
+	}
+	transaction.flowStartTime = time.Now().UnixMilli()
+	transaction.timeline.Mark(phases.FLOW_START, winner.ProviderID)
+	c.metrics.activeFlows.Add(1)
+	upChannel := make(chan *throughput.Results, 1)
+	downChannel := make(chan *throughput.Results, 1)
+	if refused {
+		log.Warn("winner refused the flow", "winner", winner.ProviderID, "reason", reply.Error)
+		upChannel <- nil
+		downChannel <- nil
+	} else {
+		log.Info("sending throughput streams (forward/reverse) to winner", "winner", winner.ProviderID,
+			"ip", winnerIP, "uplink_ports", uplinkPorts, "downlink_ports", downlinkPorts, "engine", engine.Name())
+		go func(upChannel chan *throughput.Results) {
+			iperf3Res, err := startStream(engine, winnerIP, uplinkPorts,
+				transaction.qosRequirements.transfer(transaction.transactionID.String(), throughput.Forward))
 			if err != nil {
-				fmt.Printf("failed to marshal payload: %v\n", err)
+				log.Error("failed to send stream to winner", logging.Err(err))
+				upChannel <- nil
 				return
 			}
+			upChannel <- iperf3Res
+		}(upChannel)
 
-			_, err = conn.Write(jsonPayload)
+		time.Sleep(time.Millisecond * 10)
+		go func(downChannel chan *throughput.Results) {
+			iperf3Res, err := startStream(engine, winnerIP, downlinkPorts,
+				transaction.qosRequirements.transfer(transaction.transactionID.String(), throughput.Reverse))
 			if err != nil {
-				fmt.Printf("failed to send START_FLOW from %s to %s: %v\n", c.address, provider.Address, err)
+				log.Error("failed to send reverse stream to winner", logging.Err(err))
+				downChannel <- nil
+				return
 			}
-		}(provider)
+			downChannel <- iperf3Res
+		}(downChannel)
 	}
 
-	fmt.Println("sending iperf3 streams (forward/reverse) to winner:", winner)
-
-	// Get provider iperf3 server ip and port
-	winnerIP := strings.Split(winner.Address, ":")[0]
-
-	fmt.Println("winner ip:", winnerIP)
-	fmt.Println("base server port:", winner.Iperf3BaseServerPort)
-	upChannel := make(chan *iperf3.Results)
-	go func(upChannel chan *iperf3.Results) {
-		iperf3Res, err := iperf3.StartStream(winnerIP, winner.Iperf3BaseServerPort, winner.Iperf3ServerCount,
-			transaction.qosRequirements.FlowSize, payload.TransactionID.String())
-		if err != nil {
-			fmt.Println("failed to send stream to winner:", err)
-			upChannel <- nil
-		}
-		upChannel <- iperf3Res
-	}(upChannel)
-
-	time.Sleep(time.Millisecond * 10)
-	downChannel := make(chan *iperf3.Results)
-	go func(downChannel chan *iperf3.Results) {
-		iperf3Res, err := iperf3.StartReverseStream(winnerIP, winner.Iperf3BaseServerPort, winner.Iperf3ServerCount,
-			transaction.qosRequirements.FlowSize, payload.TransactionID.String())
-		if err != nil {
-			fmt.Println("failed to send reverse stream to winner:", err)
-			downChannel <- nil
-		}
-		downChannel <- iperf3Res
-	}(downChannel)
-
 	uplinkBitsPerSecond := 0.0
 	downlinkBitsPerSecond := 0.0
+	failureReasons := []string{}
+	if refused {
+		failureReasons = append(failureReasons, "winner refused the flow: "+reply.Error)
+	}
 	uplinkResults := <-upChannel
 	downlinkResults := <-downChannel
+	transaction.flowEndTime = time.Now().UnixMilli()
+	transaction.timeline.Mark(phases.FLOW_END, winner.ProviderID)
+	c.metrics.activeFlows.Add(-1)
+	// Speeds are what the receiving side got, in reverse mode the sent side is
+	// the winner's
 	if uplinkResults != nil {
-		uplinkBitsPerSecond = uplinkResults.End.SumSent.BitsPerSecond
+		transaction.FlowMetrics.Uplink = uplinkResults.Flow()
+		uplinkBitsPerSecond = transaction.FlowMetrics.Uplink.BitsPerSecond
+		c.metrics.throughput.Observe(uplinkBitsPerSecond/8/1000000, "uplink")
+	} else if !refused {
+		failureReasons = append(failureReasons, "uplink stream failed")
 	}
 	if downlinkResults != nil {
-		fmt.Println("received downlink results:", *downlinkResults)
-		downlinkBitsPerSecond = downlinkResults.End.SumSent.BitsPerSecond
-	} else {
-		fmt.Println("received nil downlink results")
+		log.Debug("received downlink results", "results", *downlinkResults)
+		transaction.FlowMetrics.Downlink = downlinkResults.Flow()
+		downlinkBitsPerSecond = transaction.FlowMetrics.Downlink.BitsPerSecond
+		c.metrics.throughput.Observe(downlinkBitsPerSecond/8/1000000, "downlink")
+	} else if !refused {
+		log.Warn("received nil downlink results")
+		failureReasons = append(failureReasons, "downlink stream failed")
 	}
 
 	// Calculate upload and downlink speeds
 	actualUplink := uplinkBitsPerSecond / 8 / 1000000
 	actualDownlink := downlinkBitsPerSecond / 8 / 1000000
+	flowSpan.SetAttribute("uplink_speed", actualUplink)
+	flowSpan.SetAttribute("downlink_speed", actualDownlink)
+	if len(failureReasons) > 0 {
+		flowSpan.SetError(errors.New(strings.Join(failureReasons, "; ")))
+	}
+	flowSpan.End()
 
 	// Record metric, to be written into output file for data analysis
 	for idx, provider := range providerList {
@@ -197,47 +326,280 @@ func (c *consumer) handleInformVote(payload informVotePayload) {
 	transaction.FlowMetrics.AverageDownlinkSpeed = actualDownlink
 	transaction.FlowMetrics.ProviderInfo = winner
 	transaction.FlowMetrics.TransactionStartTimestamp = transaction.transactionTime
-	c.transactions[transactionID] = transaction
 
-	uplinkRequirement := c.qosRequirements.UplinkSpeedConsumer
-	downlinkRequirement := c.qosRequirements.DownlinkSpeedConsumer
-	consumerRating := calculateConsumerRating(actualUplink, actualDownlink, uplinkRequirement, downlinkRequirement)
+	// Rate the flow against what this transaction asked for. A winner out of
+	// ports served nothing, that isn't bad service, so there is no rating
+	consumerRating, ratingBreakdown := 0.0, map[string]ratingScore(nil)
+	if !refused {
+		consumerRating, ratingBreakdown = calculateConsumerRating(c.ratingModel, transaction.qosRequirements,
+			transaction.FlowMetrics)
+	}
+	transaction.rating = consumerRating
+	transaction.ratingBreakdown = ratingBreakdown
+	transaction.failureReasons = failureReasons
+	transaction.endTime = time.Now().UnixMilli()
 
 	// Send TRANSACTION_END to all providers, including rating for current transaction
+	wg := sync.WaitGroup{}
 	for _, provider := range transaction.providerList {
+		wg.Add(1)
 		go func(provider providerInfo) {
+			defer wg.Done()
+			sendSpan := c.tracer.Start("send TRANSACTION_END", tracing.KindClient, transaction.transactionID,
+				transaction.span.Traceparent())
+			sendSpan.SetAttribute(logging.KeyPeer, provider.ProviderID)
+			defer sendSpan.End()
+
 			conn, err := net.Dial("tcp", provider.Address)
 			if err != nil {
-				fmt.Printf("failed to dial provider: %v\n", err)
+				c.metrics.sent(events.TRANSACTION_END, metrics.OutcomeDialFailed)
+				sendSpan.SetError(err)
+				log.Error("failed to dial provider", logging.Event(events.TRANSACTION_END), logging.KeyPeer,
+					provider.ProviderID, logging.Err(err))
+				return
 			}
 			defer conn.Close()
 
 			transactionEndPayload := transactionEndPayload{
 				PayloadMeta: PayloadMeta{
 					PayloadType:   events.TRANSACTION_END,
-					TransactionID: payload.TransactionID,
+					TransactionID: transaction.transactionID,
 					OriginID:      c.id,
-					OriginAddress: c.address,
+					OriginAddress: c.advertiseAddress,
+					Traceparent:   sendSpan.Traceparent(),
 				},
-				Rating:        consumerRating,
-				UplinkSpeed:   actualUplink,
-				DownlinkSpeed: actualDownlink,
+				Rating:          consumerRating,
+				RatingBreakdown: ratingBreakdown,
+				UplinkSpeed:     actualUplink,
+				DownlinkSpeed:   actualDownlink,
+				Refused:         refused,
 			}
 
 			jsonPayload, err := json.Marshal(transactionEndPayload)
 			if err != nil {
-				fmt.Printf("failed to marshal payload: %v\n", err)
+				log.Error("failed to marshal payload", logging.Err(err))
 				return
 			}
 
 			_, err = conn.Write(jsonPayload)
 			if err != nil {
-				fmt.Printf("failed to send TRANSACTION_END from %s to %s: %v\n", c.address, provider.Address, err)
+				c.metrics.sent(events.TRANSACTION_END, metrics.OutcomeSendFailed)
+				sendSpan.SetError(err)
+				log.Error("failed to send payload", logging.Event(events.TRANSACTION_END), logging.KeyPeer,
+					provider.ProviderID, logging.Err(err))
+				return
 			}
+			c.metrics.sent(events.TRANSACTION_END, metrics.OutcomeSent)
+			transaction.timeline.Mark(phases.TRANSACTION_END_SENT, provider.ProviderID)
 		}(provider)
 	}
+	wg.Wait()
+
+	c.mutex.Lock()
+	inFlight, exists := c.transactions[transactionID]
+	if !exists {
+		// Already recorded as timed out by the sweeper
+		c.mutex.Unlock()
+		log.Warn("transaction expired before it ended")
+		return
+	}
+	// Keep scenario events recorded while the flow was running
+	transaction.ScenarioEvents = inFlight.ScenarioEvents
+	transaction.completed = true
+	c.transactions[transactionID] = transaction
+	c.mutex.Unlock()
+
+	if len(failureReasons) == 0 {
+		c.recordTransaction(transaction, transactionSucceeded)
+	} else {
+		c.recordTransaction(transaction, transactionFailed)
+	}
+
+	durations := calculateDurations(transaction.timeline)
+	if durations.EndToEnd > 0 {
+		c.metrics.consensusLatency.Observe(durations.Consensus / 1000)
+		c.metrics.endToEndLatency.Observe(durations.EndToEnd / 1000)
+	}
+	c.sendTriggerResult(transaction, triggerResultPayload{
+		Success:          len(failureReasons) == 0,
+		FailureReason:    strings.Join(failureReasons, "; "),
+		WinnerID:         winner.ProviderID,
+		Price:            transaction.FlowMetrics.Price,
+		UplinkSpeed:      actualUplink,
+		DownlinkSpeed:    actualDownlink,
+		Rating:           consumerRating,
+		ConsensusLatency: durations.Consensus,
+		EndToEndLatency:  durations.EndToEnd,
+	})
+
+	transaction.span.SetAttribute("winner", winner.ProviderID)
+	transaction.span.SetAttribute("rating", consumerRating)
+	if len(failureReasons) > 0 {
+		transaction.span.SetError(errors.New(strings.Join(failureReasons, "; ")))
+	}
+	transaction.span.End()
+}
+
+// startStream runs transfer against the first of ports whose server takes it
+func startStream(engine throughput.Engine, ip string, ports []int, transfer throughput.Transfer) (*throughput.Results, error) {
+	for _, port := range ports {
+		results, err := engine.StartStream(ip, strconv.Itoa(port), 1, transfer)
+		if err == nil {
+			return results, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to send stream, none of ports %v available", ports)
+}
+
+// sendStartFlow tells provider who won, the reply of the winner is returned.
+// It is nil if the winner didn't reply, as providers that don't lease ports
+func (c *consumer) sendStartFlow(transaction transaction, provider providerInfo, winner providerInfo,
+	log *slog.Logger) *startFlowReply {
+	sendSpan := c.tracer.Start("send START_FLOW", tracing.KindClient, transaction.transactionID,
+		transaction.span.Traceparent())
+	sendSpan.SetAttribute(logging.KeyPeer, provider.ProviderID)
+	defer sendSpan.End()
+
+	conn, err := net.Dial("tcp", provider.Address)
+	if err != nil {
+		c.metrics.sent(events.START_FLOW, metrics.OutcomeDialFailed)
+		sendSpan.SetError(err)
+		log.Error("failed to dial provider", logging.Event(events.START_FLOW), logging.KeyPeer, provider.ProviderID,
+			logging.Err(err))
+		return nil
+	}
+	defer conn.Close()
+
+	payload := startFlowPayload{
+		PayloadMeta: PayloadMeta{
+			PayloadType:   events.START_FLOW,
+			TransactionID: transaction.transactionID,
+			OriginID:      c.id,
+			OriginAddress: c.advertiseAddress,
+			Traceparent:   sendSpan.Traceparent(),
+		},
+		Winner: winner,
+	}
+
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		log.Error("failed to marshal payload", logging.Err(err))
+		return nil
+	}
+
+	_, err = conn.Write(jsonPayload)
+	if err != nil {
+		c.metrics.sent(events.START_FLOW, metrics.OutcomeSendFailed)
+		sendSpan.SetError(err)
+		log.Error("failed to send payload", logging.Event(events.START_FLOW), logging.KeyPeer, provider.ProviderID,
+			logging.Err(err))
+		return nil
+	}
+	c.metrics.sent(events.START_FLOW, metrics.OutcomeSent)
+	transaction.timeline.Mark(phases.START_FLOW_SENT, provider.ProviderID)
+	if provider.ProviderID != winner.ProviderID {
+		return nil
+	}
+
+	// The provider reads until EOF before it replies
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(startFlowReplyTimeout))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		log.Warn("failed to read START_FLOW reply", logging.KeyPeer, provider.ProviderID, logging.Err(err))
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	reply := startFlowReply{}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		log.Warn("failed to unmarshal START_FLOW reply", logging.KeyPeer, provider.ProviderID, logging.Err(err))
+		return nil
+	}
+	return &reply
+}
+
+// Report the outcome of a transaction to the trigger that requested it
+func (c *consumer) sendTriggerResult(transaction transaction, result triggerResultPayload) {
+	if transaction.triggerAddress == "" {
+		return
+	}
+
+	result.PayloadMeta = PayloadMeta{
+		PayloadType:   events.TRIGGER_RESULT,
+		TransactionID: transaction.transactionID,
+		OriginID:      c.id,
+		OriginAddress: c.advertiseAddress,
+	}
+	result.ConsumerID = c.id
+	log := c.log.With(logging.Event(events.TRIGGER_RESULT), logging.Transaction(transaction.transactionID))
+
+	jsonPayload, err := json.Marshal(result)
+	if err != nil {
+		log.Error("failed to marshal payload", logging.Err(err))
+		return
+	}
+
+	conn, err := net.Dial("tcp", transaction.triggerAddress)
+	if err != nil {
+		c.metrics.sent(events.TRIGGER_RESULT, metrics.OutcomeDialFailed)
+		log.Error("failed to dial trigger", logging.Err(err))
+		return
+	}
+	defer conn.Close()
+
+	if _, err = conn.Write(jsonPayload); err != nil {
+		c.metrics.sent(events.TRIGGER_RESULT, metrics.OutcomeSendFailed)
+		log.Error("failed to send payload", "address", transaction.triggerAddress, logging.Err(err))
+		return
+	}
+	c.metrics.sent(events.TRIGGER_RESULT, metrics.OutcomeSent)
+}
+
+// Record the scenario event on every transaction still in flight
+func (c *consumer) handleScenarioEvent(payload scenarioEventPayload) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for transactionID, transaction := range c.transactions {
+		if transaction.completed {
+			continue
+		}
+		transaction.ScenarioEvents = append(transaction.ScenarioEvents, payload.scenarioEvent)
+		c.transactions[transactionID] = transaction
+	}
 }
 
 // func (c *consumer) sendTransactionEnd(provider Provider) {
 
 // }
+
+// Compare the winner with the provider the oracle would have picked
+func (c *consumer) judgeConsensus(transaction transaction, winner providerInfo) *oracle.Verdict {
+	if c.oracle == nil {
+		return nil
+	}
+
+	candidates := []string{}
+	quotedPrices := map[string]float64{}
+	for _, provider := range transaction.providerList {
+		candidates = append(candidates, provider.ProviderID)
+		quotedPrices[provider.ProviderID] = provider.Price
+	}
+
+	qos := transaction.qosRequirements
+	verdict := c.oracle.Judge(oracle.QOS{
+		PriceConsumer:         qos.PriceConsumer,
+		UplinkSpeedConsumer:   qos.UplinkSpeedConsumer,
+		DownlinkSpeedConsumer: qos.DownlinkSpeedConsumer,
+		Mu:                    qos.Mu,
+		Epsilon:               qos.Epsilon,
+	}, candidates, quotedPrices, winner.ProviderID)
+	c.log.Info("judged consensus", logging.Transaction(transaction.transactionID), "oracle_winner", verdict.Winner,
+		"winner", winner.ProviderID, "rank", verdict.Rank, "regret", verdict.Regret)
+
+	return &verdict
+}
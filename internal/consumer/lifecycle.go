@@ -0,0 +1,176 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/metrics"
+	"wifi-trade-consensus/internal/pkg/shutdown"
+	"wifi-trade-consensus/internal/pkg/tracing"
+
+	"github.com/google/uuid"
+)
+
+var errShuttingDown = errors.New("consumer is shutting down")
+
+// Shutdown stops taking new transactions, sends LEAVE to the providers and
+// waits for the transactions in flight until ctx is done. The listener is
+// closed afterwards, the rest are recorded as incomplete and the trace file
+// and throughput servers are cleaned up
+func (c *consumer) Shutdown(ctx context.Context) error {
+	c.draining.Store(true)
+	c.log.Info("shutting down, draining transactions")
+	c.sendLeave()
+
+	if inFlight, err := shutdown.Drain(ctx, c.inFlightCount); err != nil {
+		c.log.Warn("abandoning transactions in flight", "count", inFlight, logging.Err(err))
+	} else {
+		c.log.Info("drained transactions")
+	}
+
+	c.mutex.Lock()
+	listener := c.listener
+	c.mutex.Unlock()
+	if listener != nil {
+		if err := listener.Close(); err != nil {
+			c.log.Error("failed to close listener", logging.Err(err))
+		}
+	}
+	if handling, err := shutdown.Drain(ctx, func() int { return int(c.handlers.Load()) }); err != nil {
+		c.log.Warn("abandoning connections still being handled", "count", handling, logging.Err(err))
+	}
+
+	if err := c.metrics.registry.Close(); err != nil {
+		c.log.Error("failed to close metrics server", logging.Err(err))
+	}
+	if err := c.persistResults(); err != nil {
+		return fmt.Errorf("failed to persist results: %w", err)
+	}
+	if err := c.cleanup(); err != nil {
+		return fmt.Errorf("failed to cleanup: %w", err)
+	}
+	return nil
+}
+
+// inFlightCount counts the transactions that haven't completed
+func (c *consumer) inFlightCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	count := 0
+	for _, transaction := range c.transactions {
+		if !transaction.completed {
+			count++
+		}
+	}
+	return count
+}
+
+// sendLeave tells the providers of every transaction held that this consumer
+// is going away
+func (c *consumer) sendLeave() {
+	c.mutex.Lock()
+	addresses := map[string]bool{}
+	for _, transaction := range c.transactions {
+		for _, provider := range transaction.providerList {
+			addresses[provider.Address] = true
+		}
+	}
+	c.mutex.Unlock()
+
+	jsonPayload, err := json.Marshal(PayloadMeta{
+		PayloadType:   events.LEAVE,
+		OriginID:      c.id,
+		OriginAddress: c.advertiseAddress,
+	})
+	if err != nil {
+		c.log.Error("failed to marshal payload", logging.Event(events.LEAVE), logging.Err(err))
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", address, shutdown.LeaveTimeout)
+			if err != nil {
+				c.metrics.sent(events.LEAVE, metrics.OutcomeDialFailed)
+				c.log.Debug("failed to send LEAVE", "address", address, logging.Err(err))
+				return
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write(jsonPayload); err != nil {
+				c.metrics.sent(events.LEAVE, metrics.OutcomeSendFailed)
+				c.log.Debug("failed to send LEAVE", "address", address, logging.Err(err))
+				return
+			}
+			c.metrics.sent(events.LEAVE, metrics.OutcomeSent)
+		}(address)
+	}
+	wg.Wait()
+	c.log.Info("sent LEAVE", "providers", len(addresses))
+}
+
+// handleLeave drops a provider that refused the BUY of a transaction, it is
+// shutting down and won't vote. The winner is decided without it, or the
+// transaction fails once every provider refused. A LEAVE without a
+// transaction needs nothing, providers are picked per BUY by the trigger
+func (c *consumer) handleLeave(payload PayloadMeta) {
+	if payload.TransactionID == uuid.Nil {
+		return
+	}
+	transactionID := payload.TransactionID.String()
+	log := c.log.With(logging.Event(events.LEAVE), logging.Transaction(payload.TransactionID),
+		logging.KeyPeer, payload.OriginID)
+	span := c.tracer.Start("handle LEAVE", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute(logging.KeyPeer, payload.OriginID)
+	defer span.End()
+
+	c.mutex.Lock()
+	transaction, exists := c.transactions[transactionID]
+	if !exists || transaction.decided {
+		c.mutex.Unlock()
+		log.Warn("provider left a transaction that is no longer voting")
+		return
+	}
+	providerList := providers{}
+	for _, provider := range transaction.providerList {
+		if provider.ProviderID != payload.OriginID {
+			providerList = append(providerList, provider)
+		}
+	}
+	transaction.providerList = providerList
+	transaction.providerCount = len(providerList)
+	delete(transaction.allFFS, payload.OriginID)
+	if len(providerList) == 0 {
+		transaction.decided = true
+		transaction.completed = true
+		transaction.failureReasons = []string{"every provider refused the BUY"}
+		transaction.endTime = time.Now().UnixMilli()
+	}
+	c.transactions[transactionID] = transaction
+	c.mutex.Unlock()
+
+	log.Info("provider left the transaction", "remaining", len(providerList))
+	if len(providerList) > 0 {
+		// The votes of the remaining providers may all be in already
+		c.decideWinner(transactionID, span, log)
+		return
+	}
+
+	c.recordTransaction(transaction, transactionFailed)
+	c.sendTriggerResult(transaction, triggerResultPayload{
+		FailureReason: strings.Join(transaction.failureReasons, "; "),
+	})
+	transaction.span.SetError(errors.New(strings.Join(transaction.failureReasons, "; ")))
+	transaction.span.End()
+}
@@ -0,0 +1,67 @@
+package consumer
+
+import (
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/metrics"
+)
+
+// Throughput buckets in megabytes per second
+var throughputBuckets = []float64{1, 2, 5, 10, 20, 30, 50, 75, 100, 150}
+
+type consumerMetrics struct {
+	registry         *metrics.Registry
+	messagesReceived *metrics.Counter   // event
+	messagesSent     *metrics.Counter   // event, outcome
+	dialFailures     *metrics.Counter   // event
+	consensusLatency *metrics.Histogram // first BUY sent to winner decided
+	endToEndLatency  *metrics.Histogram
+	transactions     *metrics.Counter // state
+	activeFlows      *metrics.Gauge
+	throughput       *metrics.Histogram // direction
+}
+
+func newConsumerMetrics(id string) *consumerMetrics {
+	registry := metrics.NewRegistry(id)
+	return &consumerMetrics{
+		registry: registry,
+		messagesReceived: registry.Counter("wifi_trade_messages_received_total",
+			"Messages received by event type.", "event"),
+		messagesSent: registry.Counter("wifi_trade_messages_sent_total",
+			"Messages sent by event type and outcome.", "event", "outcome"),
+		dialFailures: registry.Counter("wifi_trade_dial_failures_total",
+			"Failed dials by the event type being sent.", "event"),
+		consensusLatency: registry.Histogram("wifi_trade_consensus_duration_seconds",
+			"Time from the first BUY sent to the winner decided.", metrics.DurationBuckets),
+		endToEndLatency: registry.Histogram("wifi_trade_transaction_duration_seconds",
+			"Time from the first BUY sent to the last TRANSACTION_END sent.",
+			[]float64{1, 5, 10, 30, 60, 120, 300, 600}),
+		transactions: registry.Counter("wifi_trade_transactions_total",
+			"Transactions by final state.", "state"),
+		activeFlows: registry.Gauge("wifi_trade_active_flows",
+			"Throughput flows currently running."),
+		throughput: registry.Histogram("wifi_trade_throughput_megabytes_per_second",
+			"Throughput measured by the throughput engine.", throughputBuckets, "direction"),
+	}
+}
+
+func (m *consumerMetrics) received(event int) {
+	m.messagesReceived.Inc(events.Name(event))
+}
+
+// sent records the outcome of an outbound message, a dial failure is also
+// counted on its own
+func (m *consumerMetrics) sent(event int, outcome string) {
+	m.messagesSent.Inc(events.Name(event), outcome)
+	if outcome == metrics.OutcomeDialFailed {
+		m.dialFailures.Inc(events.Name(event))
+	}
+}
+
+// NewMetricsServer serves the metrics at metrics_address, nothing is served
+// when it isn't set
+func (c *consumer) NewMetricsServer() error {
+	if c.metricsAddress == "" {
+		return nil
+	}
+	return c.metrics.registry.Serve(c.metricsAddress)
+}
@@ -0,0 +1,217 @@
+package consumer
+
+import (
+	"math"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/throughput"
+)
+
+// Rating dimensions, the keys of the rating config
+const (
+	dimensionUplink     = "uplink"     // speed against the uplink requirement
+	dimensionDownlink   = "downlink"   // speed against the downlink requirement
+	dimensionRTT        = "rtt"        // mean RTT against max_rtt
+	dimensionJitter     = "jitter"     // worst UDP jitter against max_jitter
+	dimensionLoss       = "loss"       // worst UDP loss against max_loss
+	dimensionCompletion = "completion" // share of the flow that was moved
+)
+
+var dimensions = []string{dimensionUplink, dimensionDownlink, dimensionRTT, dimensionJitter, dimensionLoss,
+	dimensionCompletion}
+
+// Satisfaction curves, selected with curve
+const (
+	curveStep    = "step"    // 1 once the requirement is met, 0 before
+	curveLinear  = "linear"  // the share of the requirement met (default)
+	curveSigmoid = "sigmoid" // smooth step around midpoint
+)
+
+const (
+	defaultMidpoint  = 0.8
+	defaultSteepness = 20
+)
+
+// ratingModel is keyed by dimension, dimensions left out of the config are
+// rated linearly with weight 1
+type ratingModel map[string]dimensionModel
+
+type dimensionModel struct {
+	Weight    float64 `mapstructure:"weight" json:"weight"`       // 0 leaves the dimension out
+	Curve     string  `mapstructure:"curve" json:"curve"`         // step, linear or sigmoid
+	Midpoint  float64 `mapstructure:"midpoint" json:"midpoint"`   // sigmoid: share of the requirement rated half way, 0.8 if 0
+	Steepness float64 `mapstructure:"steepness" json:"steepness"` // sigmoid: 20 if 0
+}
+
+// ratingScore is one dimension of a rating, sent in TRANSACTION_END
+type ratingScore struct {
+	Actual   float64 `json:"actual"`
+	Required float64 `json:"required"`
+	Score    float64 `json:"score"` // 0 to 1
+	Weight   float64 `json:"weight"`
+}
+
+func (m ratingModel) validate() error {
+	problems := config.Problems{}
+	known := map[string]bool{}
+	for _, dimension := range dimensions {
+		known[dimension] = true
+	}
+	for dimension, model := range m {
+		if !known[dimension] {
+			problems.Add("unknown rating dimension %q, want one of %v", dimension, dimensions)
+			continue
+		}
+		if model.Weight < 0 {
+			problems.Add("rating.%s.weight must be >= 0, got %v", dimension, model.Weight)
+		}
+		switch model.Curve {
+		case "", curveStep, curveLinear, curveSigmoid:
+		default:
+			problems.Add("rating.%s.curve must be %s, %s or %s, got %q", dimension, curveStep, curveLinear,
+				curveSigmoid, model.Curve)
+		}
+		if model.Midpoint < 0 || model.Midpoint >= 1 {
+			problems.Add("rating.%s.midpoint must be in [0, 1), got %v", dimension, model.Midpoint)
+		}
+		if model.Steepness < 0 {
+			problems.Add("rating.%s.steepness must be >= 0, got %v", dimension, model.Steepness)
+		}
+	}
+
+	total := 0.0
+	for _, dimension := range dimensions {
+		total += m.dimension(dimension).Weight
+	}
+	if total == 0 {
+		problems.Add("rating needs a dimension with a weight > 0")
+	}
+	return problems.Err()
+}
+
+// dimension returns the model of dimension, the default if it isn't set
+func (m ratingModel) dimension(dimension string) dimensionModel {
+	model, exists := m[dimension]
+	if !exists {
+		return dimensionModel{Weight: 1, Curve: curveLinear}
+	}
+	return model
+}
+
+// satisfaction maps the share of the requirement met, above 1 when it is
+// exceeded, to a score from 0 to 1
+func (d dimensionModel) satisfaction(share float64) float64 {
+	switch d.Curve {
+	case curveStep:
+		if share >= 1 {
+			return 1
+		}
+		return 0
+	case curveSigmoid:
+		midpoint, steepness := d.Midpoint, d.Steepness
+		if midpoint == 0 {
+			midpoint = defaultMidpoint
+		}
+		if steepness == 0 {
+			steepness = defaultSteepness
+		}
+		sigmoid := func(x float64) float64 {
+			return 1 / (1 + math.Exp(-steepness*(x-midpoint)))
+		}
+		// Scaled so a met requirement scores 1
+		return math.Min(1, sigmoid(share)/sigmoid(1))
+	default:
+		return math.Min(1, share)
+	}
+}
+
+// calculateConsumerRating rates a flow against the requirements of its
+// transaction. Every dimension with a requirement and a measurement is scored
+// on its curve and the rating is the weighted mean of the scores
+func calculateConsumerRating(model ratingModel, qos qosRequirements, flow flowMetrics) (float64, map[string]ratingScore) {
+	scores := map[string]ratingScore{}
+	score := func(dimension string, actual float64, required float64, share float64) {
+		dimensionModel := model.dimension(dimension)
+		if dimensionModel.Weight == 0 {
+			return
+		}
+		scores[dimension] = ratingScore{
+			Actual:   actual,
+			Required: required,
+			Score:    dimensionModel.satisfaction(share),
+			Weight:   dimensionModel.Weight,
+		}
+	}
+
+	// Higher is better
+	if qos.UplinkSpeedConsumer > 0 {
+		score(dimensionUplink, flow.AverageUplinkSpeed, qos.UplinkSpeedConsumer,
+			flow.AverageUplinkSpeed/qos.UplinkSpeedConsumer)
+	}
+	if qos.DownlinkSpeedConsumer > 0 {
+		score(dimensionDownlink, flow.AverageDownlinkSpeed, qos.DownlinkSpeedConsumer,
+			flow.AverageDownlinkSpeed/qos.DownlinkSpeedConsumer)
+	}
+
+	// Lower is better, only the directions that measured it count
+	rtt, jitter, loss, udp := 0.0, 0.0, 0.0, false
+	for _, direction := range []throughput.Flow{flow.Uplink, flow.Downlink} {
+		rtt = math.Max(rtt, direction.MeanRTT)
+		if direction.Protocol == "UDP" {
+			jitter = math.Max(jitter, direction.JitterMS)
+			loss = math.Max(loss, direction.LostPercent)
+			udp = true
+		}
+	}
+	if qos.MaxRTT > 0 && rtt > 0 {
+		score(dimensionRTT, rtt, qos.MaxRTT, qos.MaxRTT/rtt)
+	}
+	if udp && qos.MaxJitter > 0 {
+		score(dimensionJitter, jitter, qos.MaxJitter, boundShare(jitter, qos.MaxJitter))
+	}
+	if udp && qos.MaxLoss > 0 {
+		score(dimensionLoss, loss, qos.MaxLoss, boundShare(loss, qos.MaxLoss))
+	}
+
+	completion := calculateCompletion(qos, flow)
+	score(dimensionCompletion, completion, 1, completion)
+
+	// Summed in the order of dimensions so equal flows get bit for bit equal
+	// ratings
+	rating, weights := 0.0, 0.0
+	for _, dimension := range dimensions {
+		dimensionScore, scored := scores[dimension]
+		if !scored {
+			continue
+		}
+		rating += dimensionScore.Score * dimensionScore.Weight
+		weights += dimensionScore.Weight
+	}
+	if weights == 0 {
+		return 0, scores
+	}
+	return rating / weights, scores
+}
+
+// boundShare is the share of a bound kept, above 1 within the bound
+func boundShare(actual float64, bound float64) float64 {
+	if actual == 0 {
+		return math.Inf(1)
+	}
+	return bound / actual
+}
+
+// calculateCompletion is the mean share of the flow size each direction
+// received, a session counts as complete in every direction that moved data
+func calculateCompletion(qos qosRequirements, flow flowMetrics) float64 {
+	completion := 0.0
+	for _, direction := range []throughput.Flow{flow.Uplink, flow.Downlink} {
+		switch {
+		case direction.Bytes == 0:
+		case qos.FlowSize > 0:
+			completion += math.Min(1, float64(direction.Bytes)/float64(qos.FlowSize))
+		default:
+			completion += 1
+		}
+	}
+	return completion / 2
+}
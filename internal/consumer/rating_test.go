@@ -0,0 +1,122 @@
+package consumer
+
+import (
+	"math"
+	"testing"
+	"wifi-trade-consensus/internal/pkg/throughput"
+)
+
+func TestSatisfactionCurves(t *testing.T) {
+	sigmoid := dimensionModel{Curve: curveSigmoid}
+	tests := []struct {
+		name  string
+		model dimensionModel
+		share float64
+		want  float64
+	}{
+		{"step below", dimensionModel{Curve: curveStep}, 0.99, 0},
+		{"step met", dimensionModel{Curve: curveStep}, 1, 1},
+		{"step exceeded", dimensionModel{Curve: curveStep}, 3, 1},
+		{"linear zero", dimensionModel{Curve: curveLinear}, 0, 0},
+		{"linear half", dimensionModel{Curve: curveLinear}, 0.5, 0.5},
+		{"linear exceeded", dimensionModel{Curve: curveLinear}, 2, 1},
+		{"default is linear", dimensionModel{}, 0.25, 0.25},
+		{"linear unbounded", dimensionModel{}, math.Inf(1), 1},
+		{"sigmoid met", sigmoid, 1, 1},
+		{"sigmoid exceeded", sigmoid, 1.5, 1},
+		// Half way at the midpoint before the scaling to 1 at share 1
+		{"sigmoid midpoint", sigmoid, 0.8, 0.50915782},
+		{"sigmoid near", sigmoid, 0.9, 0.89692944},
+		{"sigmoid far below", sigmoid, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.model.satisfaction(test.share); math.Abs(got-test.want) > 1e-6 {
+				t.Errorf("satisfaction(%v) = %v, want %v", test.share, got, test.want)
+			}
+		})
+	}
+}
+
+func TestSigmoidIsMonotonic(t *testing.T) {
+	for _, model := range []dimensionModel{
+		{Curve: curveSigmoid},
+		{Curve: curveSigmoid, Midpoint: 0.5, Steepness: 5},
+	} {
+		previous := -1.0
+		for share := 0.0; share <= 1.2; share += 0.05 {
+			score := model.satisfaction(share)
+			if score < previous || score < 0 || score > 1 {
+				t.Fatalf("%+v: satisfaction(%v) = %v after %v", model, share, score, previous)
+			}
+			previous = score
+		}
+	}
+}
+
+func TestConsumerRating(t *testing.T) {
+	qos := qosRequirements{UplinkSpeedConsumer: 10, DownlinkSpeedConsumer: 20, FlowSize: 1000, MaxRTT: 50}
+	flow := flowMetrics{
+		AverageUplinkSpeed:   5,
+		AverageDownlinkSpeed: 20,
+		Uplink:               throughput.Flow{Bytes: 1000, MeanRTT: 100},
+		Downlink:             throughput.Flow{Bytes: 500},
+	}
+
+	// uplink 0.5, downlink 1, rtt 0.5, completion 0.75
+	rating, scores := calculateConsumerRating(ratingModel{}, qos, flow)
+	if want := (0.5 + 1 + 0.5 + 0.75) / 4; math.Abs(rating-want) > 1e-9 {
+		t.Errorf("rating = %v, want %v", rating, want)
+	}
+	if len(scores) != 4 {
+		t.Errorf("scored %d dimensions, want 4: %v", len(scores), scores)
+	}
+	if _, scored := scores[dimensionJitter]; scored {
+		t.Errorf("jitter of a TCP flow was scored")
+	}
+
+	// A weight of 0 leaves a dimension out, the others are weighed
+	model := ratingModel{
+		dimensionUplink: {Weight: 3, Curve: curveStep},
+		dimensionRTT:    {Weight: 0},
+	}
+	rating, scores = calculateConsumerRating(model, qos, flow)
+	if want := (3*0 + 1 + 0.75) / 5; math.Abs(rating-want) > 1e-9 {
+		t.Errorf("weighted rating = %v, want %v", rating, want)
+	}
+	if _, scored := scores[dimensionRTT]; scored {
+		t.Errorf("rtt with weight 0 was scored")
+	}
+
+	// The sum runs in a fixed order, so equal flows rate bit for bit equal
+	for i := 0; i < 100; i++ {
+		again, _ := calculateConsumerRating(model, qos, flow)
+		if again != rating {
+			t.Fatalf("rating changed between runs: %v then %v", rating, again)
+		}
+	}
+}
+
+func TestConsumerRatingUDPBounds(t *testing.T) {
+	qos := qosRequirements{UplinkSpeedConsumer: 1, DownlinkSpeedConsumer: 1, Duration: 10, MaxJitter: 2,
+		MaxLoss: 1}
+	flow := flowMetrics{
+		AverageUplinkSpeed:   1,
+		AverageDownlinkSpeed: 1,
+		Uplink:               throughput.Flow{Protocol: "UDP", Bytes: 1, JitterMS: 4, LostPercent: 0},
+		Downlink:             throughput.Flow{Protocol: "UDP", Bytes: 1, JitterMS: 1, LostPercent: 0.5},
+	}
+
+	_, scores := calculateConsumerRating(ratingModel{}, qos, flow)
+	// The worst direction counts
+	if got := scores[dimensionJitter].Score; got != 0.5 {
+		t.Errorf("jitter score = %v, want 0.5", got)
+	}
+	if got := scores[dimensionLoss].Score; got != 1 {
+		t.Errorf("loss score = %v, want 1", got)
+	}
+	if got := scores[dimensionCompletion].Score; got != 1 {
+		t.Errorf("session completion score = %v, want 1", got)
+	}
+}
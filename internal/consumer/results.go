@@ -0,0 +1,278 @@
+package consumer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/oracle"
+	"wifi-trade-consensus/internal/pkg/throughput"
+	phases "wifi-trade-consensus/internal/pkg/timeline"
+)
+
+// Transaction states recorded in the results log
+const (
+	transactionSucceeded  = "succeeded"
+	transactionFailed     = "failed"
+	transactionIncomplete = "incomplete" // still in flight when the consumer shut down
+	transactionTimedOut   = "timed_out"  // still in flight after transaction_timeout
+)
+
+// resultsLog appends a record to the results file as soon as a transaction is
+// over, so a crash only loses the transactions still in flight
+type resultsLog struct {
+	mutex     sync.Mutex
+	jsonlFile *os.File
+	csvFile   *os.File
+	csvWriter *csv.Writer
+}
+
+// runHeader is the first line of a results file
+type runHeader struct {
+	RecordType string  `json:"record_type"` // always "header"
+	ConsumerID string  `json:"consumer_id"`
+	StartTime  int64   `json:"start_time"` // unix ms
+	Config     options `json:"config"`
+}
+
+// transactionRecord holds everything known about a transaction
+type transactionRecord struct {
+	RecordType      string                 `json:"record_type"` // always "transaction"
+	TransactionID   string                 `json:"transaction_id"`
+	ConsumerID      string                 `json:"consumer_id"`
+	Seed            int64                  `json:"seed"` // of the trigger, 0 if unknown
+	State           string                 `json:"state"`
+	FailureReasons  []string               `json:"failure_reasons"`
+	QOSRequirements qosRequirements        `json:"qos_requirements"`
+	ProviderList    providers              `json:"provider_list"`
+	AllFFS          allFFS                 `json:"all_FFS"`
+	FFSfinal        FFS                    `json:"FFS_final"`
+	Winner          providerInfo           `json:"winner"`
+	Price           float64                `json:"price"` // price of the winner
+	PriceConsumer   float64                `json:"price_consumer"`
+	UplinkSpeed     float64                `json:"uplink_speed"`
+	DownlinkSpeed   float64                `json:"downlink_speed"`
+	UplinkFlow      throughput.Flow        `json:"uplink_flow"`
+	DownlinkFlow    throughput.Flow        `json:"downlink_flow"`
+	Rating          float64                `json:"rating"`
+	RatingBreakdown map[string]ratingScore `json:"rating_breakdown"` // index: dimension
+	StartTime       int64                  `json:"start_time"`       // unix ms
+	FlowStartTime   int64                  `json:"flow_start_time"`
+	FlowEndTime     int64                  `json:"flow_end_time"`
+	EndTime         int64                  `json:"end_time"`
+	ScenarioEvents  []scenarioEvent        `json:"scenario_events"`
+	Timeline        []phases.Mark          `json:"timeline"`
+	Durations       durations              `json:"durations"`
+	Oracle          *oracle.Verdict        `json:"oracle,omitempty"`
+}
+
+// durations are the latencies of the phases of a transaction in ms, 0 if a
+// phase was never reached
+type durations struct {
+	Consensus  float64 `json:"consensus"`   // first BUY sent to winner decided
+	InformVote float64 `json:"inform_vote"` // first BUY sent to last INFORM_VOTE received
+	Flow       float64 `json:"flow"`        // flow start to flow end
+	EndToEnd   float64 `json:"end_to_end"`  // first BUY sent to last TRANSACTION_END sent
+}
+
+func calculateDurations(timeline *phases.Timeline) durations {
+	return durations{
+		Consensus:  timeline.Between(phases.BUY_SENT, phases.WINNER_DECIDED),
+		InformVote: timeline.Between(phases.BUY_SENT, phases.INFORM_VOTE_RECEIVED),
+		Flow:       timeline.Between(phases.FLOW_START, phases.FLOW_END),
+		EndToEnd:   timeline.Between(phases.BUY_SENT, phases.TRANSACTION_END_SENT),
+	}
+}
+
+var csvHeader = []string{
+	"transaction_id", "consumer_id", "seed", "state", "failure_reasons",
+	"price_consumer", "uplink_requirement", "downlink_requirement", "mu", "delta", "epsilon", "flow_size",
+	"winner_id", "price", "uplink_speed", "downlink_speed", "rating",
+	"start_time", "flow_start_time", "flow_end_time", "end_time",
+	"consensus_ms", "flow_ms", "end_to_end_ms",
+	"oracle_winner", "oracle_match", "oracle_regret", "oracle_rank",
+	"uplink_bytes", "uplink_std_dev_bps", "uplink_retransmits", "uplink_mean_rtt_ms",
+	"downlink_bytes", "downlink_std_dev_bps", "downlink_retransmits", "downlink_mean_rtt_ms",
+	"protocol", "uplink_jitter_ms", "uplink_lost_percent", "uplink_out_of_order",
+	"downlink_jitter_ms", "downlink_lost_percent", "downlink_out_of_order",
+	"session_duration", "rate_capped", "bitrate", "max_rtt", "rating_breakdown",
+	"FFS_final", "all_FFS",
+}
+
+func (r transactionRecord) csvRow() []string {
+	FFSfinal, _ := json.Marshal(r.FFSfinal)
+	ratingBreakdown, _ := json.Marshal(r.RatingBreakdown)
+	allFFS, _ := json.Marshal(r.AllFFS)
+	verdict := []string{"", "", "", ""}
+	if r.Oracle != nil {
+		verdict = []string{r.Oracle.Winner, strconv.FormatBool(r.Oracle.Matched),
+			formatFloat(r.Oracle.Regret), strconv.Itoa(r.Oracle.Rank)}
+	}
+	return []string{
+		r.TransactionID, r.ConsumerID, strconv.FormatInt(r.Seed, 10), r.State, strings.Join(r.FailureReasons, "; "),
+		formatFloat(r.QOSRequirements.PriceConsumer), formatFloat(r.QOSRequirements.UplinkSpeedConsumer),
+		formatFloat(r.QOSRequirements.DownlinkSpeedConsumer), formatFloat(r.QOSRequirements.Mu),
+		formatFloat(r.QOSRequirements.Delta), formatFloat(r.QOSRequirements.Epsilon), r.QOSRequirements.FlowSize.String(),
+		r.Winner.ProviderID, formatFloat(r.Price), formatFloat(r.UplinkSpeed), formatFloat(r.DownlinkSpeed),
+		formatFloat(r.Rating),
+		fmt.Sprint(r.StartTime), fmt.Sprint(r.FlowStartTime), fmt.Sprint(r.FlowEndTime), fmt.Sprint(r.EndTime),
+		formatFloat(r.Durations.Consensus), formatFloat(r.Durations.Flow), formatFloat(r.Durations.EndToEnd),
+		verdict[0], verdict[1], verdict[2], verdict[3],
+		fmt.Sprint(r.UplinkFlow.Bytes), formatFloat(r.UplinkFlow.StdDevBitsPerSecond),
+		strconv.Itoa(r.UplinkFlow.Retransmits), formatFloat(r.UplinkFlow.MeanRTT),
+		fmt.Sprint(r.DownlinkFlow.Bytes), formatFloat(r.DownlinkFlow.StdDevBitsPerSecond),
+		strconv.Itoa(r.DownlinkFlow.Retransmits), formatFloat(r.DownlinkFlow.MeanRTT),
+		r.QOSRequirements.Protocol, formatFloat(r.UplinkFlow.JitterMS), formatFloat(r.UplinkFlow.LostPercent),
+		fmt.Sprint(r.UplinkFlow.OutOfOrder), formatFloat(r.DownlinkFlow.JitterMS),
+		formatFloat(r.DownlinkFlow.LostPercent), fmt.Sprint(r.DownlinkFlow.OutOfOrder),
+		formatFloat(r.QOSRequirements.Duration), strconv.FormatBool(r.QOSRequirements.RateCapped),
+		r.QOSRequirements.Bitrate.String(), formatFloat(r.QOSRequirements.MaxRTT), string(ratingBreakdown),
+		string(FFSfinal), string(allFFS),
+	}
+}
+
+// NewResultsLog creates the results files of this run and writes the header
+func (c *consumer) NewResultsLog() error {
+	err := os.MkdirAll(c.outputDir, 0777)
+	if err != nil {
+		return fmt.Errorf("failed to make new dir: %w", err)
+	}
+
+	timeString := time.Now().Format("2006-01-02--15-04-05") // Golang weird time format constants
+	basename := filepath.Join(c.outputDir, "consumer_results--"+c.id+"--"+timeString)
+
+	log := &resultsLog{}
+	log.jsonlFile, err = os.OpenFile(basename+".jsonl", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create results file: %w", err)
+	}
+
+	header, err := json.Marshal(runHeader{
+		RecordType: "header",
+		ConsumerID: c.id,
+		StartTime:  time.Now().UnixMilli(),
+		Config:     c.options,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal results header: %w", err)
+	}
+	if err := log.writeLine(header); err != nil {
+		return fmt.Errorf("failed to write results header: %w", err)
+	}
+
+	if c.resultsCSV {
+		log.csvFile, err = os.OpenFile(basename+".csv", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to create csv results file: %w", err)
+		}
+		log.csvWriter = csv.NewWriter(log.csvFile)
+		if err := log.writeCSV(csvHeader); err != nil {
+			return fmt.Errorf("failed to write csv header: %w", err)
+		}
+	}
+
+	c.results = log
+	c.log.Info("recording results", "path", basename+".jsonl")
+	return nil
+}
+
+// recordTransaction appends the transaction to the results log
+func (c *consumer) recordTransaction(transaction transaction, state string) {
+	c.metrics.transactions.Inc(state)
+	if c.results == nil {
+		return
+	}
+
+	record := transactionRecord{
+		RecordType:      "transaction",
+		TransactionID:   transaction.transactionID.String(),
+		ConsumerID:      transaction.consumerID,
+		Seed:            transaction.seed,
+		State:           state,
+		FailureReasons:  transaction.failureReasons,
+		QOSRequirements: transaction.qosRequirements,
+		ProviderList:    transaction.providerList,
+		AllFFS:          transaction.allFFS,
+		FFSfinal:        transaction.FFSfinal,
+		Winner:          transaction.FlowMetrics.ProviderInfo,
+		Price:           transaction.FlowMetrics.Price,
+		PriceConsumer:   transaction.qosRequirements.PriceConsumer,
+		UplinkSpeed:     transaction.FlowMetrics.AverageUplinkSpeed,
+		DownlinkSpeed:   transaction.FlowMetrics.AverageDownlinkSpeed,
+		UplinkFlow:      transaction.FlowMetrics.Uplink,
+		DownlinkFlow:    transaction.FlowMetrics.Downlink,
+		Rating:          transaction.rating,
+		RatingBreakdown: transaction.ratingBreakdown,
+		StartTime:       transaction.transactionTime,
+		FlowStartTime:   transaction.flowStartTime,
+		FlowEndTime:     transaction.flowEndTime,
+		EndTime:         transaction.endTime,
+		ScenarioEvents:  transaction.ScenarioEvents,
+		Timeline:        transaction.timeline.Marks(),
+		Durations:       calculateDurations(transaction.timeline),
+		Oracle:          transaction.verdict,
+	}
+
+	if err := c.results.write(record); err != nil {
+		c.log.Error("failed to record transaction", logging.Transaction(transaction.transactionID), logging.Err(err))
+	}
+}
+
+func (l *resultsLog) write(record transactionRecord) error {
+	jsonRecord, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.writeLine(jsonRecord); err != nil {
+		return err
+	}
+	if l.csvWriter != nil {
+		return l.writeCSV(record.csvRow())
+	}
+	return nil
+}
+
+// writeLine appends a line and syncs it to disk
+func (l *resultsLog) writeLine(line []byte) error {
+	if _, err := l.jsonlFile.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return l.jsonlFile.Sync()
+}
+
+func (l *resultsLog) writeCSV(row []string) error {
+	if err := l.csvWriter.Write(row); err != nil {
+		return err
+	}
+	l.csvWriter.Flush()
+	if err := l.csvWriter.Error(); err != nil {
+		return err
+	}
+	return l.csvFile.Sync()
+}
+
+func (l *resultsLog) close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.csvFile != nil {
+		if err := l.csvFile.Close(); err != nil {
+			return err
+		}
+	}
+	return l.jsonlFile.Close()
+}
+
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'f', -1, 64)
+}
@@ -0,0 +1,79 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/retention"
+)
+
+var errTransactionTimedOut = errors.New("transaction timed out")
+
+// NewTransactionSweeper applies the retention policy every sweep interval
+// until ctx is cancelled, this is a blocking function so wrapping the function
+// call in a goroutine is required
+func (c *consumer) NewTransactionSweeper(ctx context.Context) {
+	if !c.retention.Enabled() {
+		return
+	}
+	c.log.Info("sweeping transactions", "max_age", c.retention.MaxAge, "max_count", c.retention.MaxCount,
+		"timeout", c.retention.Timeout)
+
+	ticker := time.NewTicker(retention.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.sweepTransactions(now)
+		}
+	}
+}
+
+// sweepTransactions expires the stuck transactions and evicts the finished
+// ones the policy no longer keeps. Finished transactions are already in the
+// results log, the expired ones are recorded as timed out
+func (c *consumer) sweepTransactions(now time.Time) {
+	c.mutex.Lock()
+	entries := make([]retention.Entry, 0, len(c.transactions))
+	for transactionID, transaction := range c.transactions {
+		entry := retention.Entry{ID: transactionID, Start: transaction.transactionTime}
+		if transaction.completed {
+			entry.End = transaction.endTime
+		}
+		entries = append(entries, entry)
+	}
+	expired, evicted := c.retention.Sweep(entries, now)
+
+	timedOut := make([]transaction, 0, len(expired))
+	for _, transactionID := range expired {
+		transaction := c.transactions[transactionID]
+		transaction.failureReasons = append(transaction.failureReasons,
+			fmt.Sprintf("timed out after %ds", c.retention.Timeout))
+		transaction.endTime = now.UnixMilli()
+		timedOut = append(timedOut, transaction)
+		delete(c.transactions, transactionID)
+	}
+	for _, transactionID := range evicted {
+		delete(c.transactions, transactionID)
+	}
+	c.mutex.Unlock()
+
+	for _, transaction := range timedOut {
+		c.log.Warn("transaction timed out", logging.Transaction(transaction.transactionID),
+			"age_ms", now.UnixMilli()-transaction.transactionTime)
+		c.recordTransaction(transaction, transactionTimedOut)
+		c.sendTriggerResult(transaction, triggerResultPayload{
+			FailureReason: strings.Join(transaction.failureReasons, "; "),
+		})
+		transaction.span.SetError(errTransactionTimedOut)
+		transaction.span.End()
+	}
+	if len(evicted) > 0 {
+		c.log.Debug("evicted transactions", "count", len(evicted))
+	}
+}
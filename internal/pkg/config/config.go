@@ -0,0 +1,224 @@
+// Package config decodes node config files strictly, unknown keys and values
+// of the wrong type are errors instead of being ignored or coerced
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// Source is where the settings of a node come from: a config file, then
+// <EnvPrefix><KEY> environment variables, then key=value overrides from the
+// command line, each overriding the previous ones
+type Source struct {
+	Path      string
+	EnvPrefix string   // e.g. WTC_PROVIDER_, no env overrides if empty
+	Overrides []string // key=value
+}
+
+// Load reads the config file and applies the overrides. An override takes the
+// JSON type of the value it replaces, a new key is parsed as JSON and kept as
+// a string if that fails, so the result decodes like the file would
+func (s Source) Load() (map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(s.Path)
+	v.SetConfigType("json")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", s.Path, err)
+	}
+	settings := v.AllSettings()
+
+	overrides := []string{}
+	if s.EnvPrefix != "" {
+		for _, env := range os.Environ() {
+			if rest, ok := strings.CutPrefix(env, s.EnvPrefix); ok {
+				overrides = append(overrides, strings.ToLower(rest))
+			}
+		}
+	}
+	overrides = append(overrides, s.Overrides...)
+
+	for _, override := range overrides {
+		key, raw, ok := strings.Cut(override, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid override %q, want key=value", override)
+		}
+		if err := setOverride(settings, key, raw); err != nil {
+			return nil, fmt.Errorf("invalid override of %s: %w", key, err)
+		}
+	}
+	return settings, nil
+}
+
+// setOverride sets a key of settings, a dotted key such as
+// rating.uplink.weight is set in the nested objects, which are made when
+// missing
+func setOverride(settings map[string]interface{}, key string, raw string) error {
+	path := strings.Split(key, ".")
+	for i, name := range path {
+		if name == "" {
+			return fmt.Errorf("empty key in %q", key)
+		}
+		if i == len(path)-1 {
+			break
+		}
+		switch nested := settings[name].(type) {
+		case map[string]interface{}:
+			settings = nested
+		case nil:
+			created := map[string]interface{}{}
+			settings[name] = created
+			settings = created
+		default:
+			return fmt.Errorf("%s is not an object", strings.Join(path[:i+1], "."))
+		}
+	}
+
+	name := path[len(path)-1]
+	value, err := parseOverride(settings[name], raw)
+	if err != nil {
+		return err
+	}
+	settings[name] = value
+	return nil
+}
+
+func parseOverride(current interface{}, raw string) (interface{}, error) {
+	switch current.(type) {
+	case string:
+		return raw, nil
+	case bool:
+		return strconv.ParseBool(raw)
+	case float64, int, int64:
+		return strconv.ParseFloat(raw, 64)
+	case nil:
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return raw, nil
+		}
+		return value, nil
+	default:
+		var value interface{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			return nil, fmt.Errorf("expected JSON: %w", err)
+		}
+		return value, nil
+	}
+}
+
+// Decode decodes raw settings into target. Every key must map to a field and
+// every value must already have the field's type, numbers with a fraction
+// are not truncated into integers. Strings are decoded into fields that
+// implement encoding.TextUnmarshaler, such as sizes and bitrates
+func Decode(raw interface{}, target interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		ErrorUnused: true,
+		DecodeHook:  mapstructure.ComposeDecodeHookFunc(mapstructure.TextUnmarshallerHookFunc(), rejectFractions),
+		Result:      target,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create decoder: %w", err)
+	}
+
+	err = decoder.Decode(raw)
+	var decodeErr *mapstructure.Error
+	if errors.As(err, &decodeErr) {
+		problems := Problems{}
+		for _, problem := range decodeErr.Errors {
+			problems.Add("%s", strings.Replace(problem, "'' has invalid keys", "unknown keys", 1))
+		}
+		return problems.Err()
+	}
+	return err
+}
+
+func rejectFractions(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.Float64 {
+		return data, nil
+	}
+	switch to.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value := data.(float64); value != math.Trunc(value) {
+			return nil, fmt.Errorf("expected an integer, got %v", value)
+		}
+	}
+	return data, nil
+}
+
+// Problems collects every range violation of a config so they are all
+// reported at once
+type Problems []string
+
+func (p *Problems) Add(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// Merge adds the problems of a nested validation
+func (p *Problems) Merge(err error) {
+	if err != nil {
+		*p = append(*p, err.Error())
+	}
+}
+
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(p, "; "))
+}
+
+// Address checks a required host:port
+func (p *Problems) Address(key string, address string) {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		p.Add("%s must be host:port, got %q", key, address)
+	}
+}
+
+// OptionalAddress checks a host:port that may be left empty
+func (p *Problems) OptionalAddress(key string, address string) {
+	if address != "" {
+		p.Address(key, address)
+	}
+}
+
+// PortRange checks that count consecutive ports starting at base are valid
+func (p *Problems) PortRange(key string, base string, count int) {
+	if count <= 0 {
+		return
+	}
+	port, err := strconv.Atoi(base)
+	if err != nil || port < 1 || port+count-1 > math.MaxUint16 {
+		p.Add("%s must be a port leaving room for %d servers, got %q", key, count, base)
+	}
+}
+
+// Positive checks value > 0
+func (p *Problems) Positive(key string, value float64) {
+	if value <= 0 {
+		p.Add("%s must be > 0, got %v", key, value)
+	}
+}
+
+// Open checks lowest < value < highest
+func (p *Problems) Open(key string, value float64, lowest float64, highest float64) {
+	if value <= lowest || value >= highest {
+		p.Add("%s must be in (%v, %v), got %v", key, lowest, highest, value)
+	}
+}
+
+// Closed checks lowest <= value <= highest
+func (p *Problems) Closed(key string, value float64, lowest float64, highest float64) {
+	if value < lowest || value > highest {
+		p.Add("%s must be in [%v, %v], got %v", key, lowest, highest, value)
+	}
+}
@@ -12,4 +12,36 @@ const (
 	TRIGGER_BUY
 	// Get stats
 	GET_PROVIDER_STATS
+	// Runtime update of provider price, params and behaviour
+	UPDATE_PROVIDER
+	// Scenario timeline events, recorded by consumers next to their transactions
+	SCENARIO_EVENT
+	// Outcome of a TRIGGER_BUY, sent by the consumer back to the trigger
+	TRIGGER_RESULT
+	// Sent by a node that is shutting down, providers forget its peer score
+	LEAVE
 )
+
+var names = []string{
+	"BEACON",
+	"BUY",
+	"REQUEST_VOTE",
+	"REPLY_VOTE",
+	"INFORM_VOTE",
+	"START_FLOW",
+	"TRANSACTION_END",
+	"TRIGGER_BUY",
+	"GET_PROVIDER_STATS",
+	"UPDATE_PROVIDER",
+	"SCENARIO_EVENT",
+	"TRIGGER_RESULT",
+	"LEAVE",
+}
+
+// Name returns the name of an event type, e.g. for labels and logs
+func Name(event int) string {
+	if event < 0 || event >= len(names) {
+		return "UNKNOWN"
+	}
+	return names[event]
+}
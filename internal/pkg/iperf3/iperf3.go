@@ -3,8 +3,12 @@ package iperf3
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math"
 	"os/exec"
 	"strconv"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
 )
 
 const app = "iperf3"
@@ -14,88 +18,215 @@ type streamDirection int
 const (
 	FORWARD streamDirection = iota
 	REVERSE
+	BIDIRECTIONAL
 )
 
+// Results are the parts of the iperf3 JSON output the nodes read
 type Results struct {
-	End struct {
-		SumSent struct {
-			BitsPerSecond float64 `json:"bits_per_second"`
-		} `json:"sum_sent"`
-		SumReceived struct {
-			BitsPerSecond float64 `json:"bits_per_second"`
-		} `json:"sum_received"`
+	Start struct {
+		TestStart struct {
+			Protocol string `json:"protocol"` // TCP or UDP
+			Reverse  int    `json:"reverse"`  // 1 if the server sent
+			Bidir    int    `json:"bidir"`    // 1 if both sent
+		} `json:"test_start"`
+	} `json:"start"`
+	Intervals []Interval `json:"intervals"`
+	End       struct {
+		Streams     []Stream `json:"streams"`
+		SumSent     Sum      `json:"sum_sent"`
+		SumReceived Sum      `json:"sum_received"`
+		Sum         Sum      `json:"sum"` // UDP totals of iperf3 before 3.9, which has no sum_sent
+		// Reverse half of a bidirectional test
+		SumSentBidirReverse     Sum `json:"sum_sent_bidir_reverse"`
+		SumReceivedBidirReverse Sum `json:"sum_received_bidir_reverse"`
+		CPUUtilizationPercent   CPU `json:"cpu_utilization_percent"`
 	} `json:"end"`
+	Error string `json:"error"` // set when the test failed
 }
 
-func StartServers(basePort string, serverCount int) ([]*exec.Cmd, error) {
-	cmds := []*exec.Cmd{}
-	for i := 0; i < serverCount; i++ {
+// Sum totals the streams of one direction
+type Sum struct {
+	Start         float64 `json:"start"` // s since the test started
+	End           float64 `json:"end"`
+	Seconds       float64 `json:"seconds"`
+	Bytes         int64   `json:"bytes"`
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Retransmits   int     `json:"retransmits"` // TCP, sender side only
+	Omitted       bool    `json:"omitted"`     // interval in the omitted warm up
+	Sender        bool    `json:"sender"`      // the local host sent
+	// UDP, measured by the receiver
+	JitterMS    float64 `json:"jitter_ms"`
+	LostPackets int64   `json:"lost_packets"`
+	Packets     int64   `json:"packets"`
+	LostPercent float64 `json:"lost_percent"`
+}
 
-		port, err := strconv.Atoi(basePort)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert basePort to int: %w", err)
-		}
-		port += i
+// Interval is one reporting interval of a test, one second by default
+type Interval struct {
+	Sum             Sum `json:"sum"`
+	SumBidirReverse Sum `json:"sum_bidir_reverse"`
+}
 
-		cmd, err := startServer(fmt.Sprint(port))
-		if err != nil {
-			return nil, fmt.Errorf("failed to start server: %w", err)
-		}
-		cmds = append(cmds, cmd)
-	}
-	return cmds, nil
+// Stream holds the totals of one stream, RTTs are only known to the sender
+type Stream struct {
+	Sender StreamSum `json:"sender"`
+	UDP    UDPSum    `json:"udp"`
 }
 
-func startServer(port string) (*exec.Cmd, error) {
-	args := []string{
-		"-s", // type: server
-		"-p", port,
-		"-J", // JSON output
-	}
+// StreamSum adds the TCP details of the sender to Sum
+type StreamSum struct {
+	Sum
+	MinRTT  int64 `json:"min_rtt"` // µs
+	MaxRTT  int64 `json:"max_rtt"`
+	MeanRTT int64 `json:"mean_rtt"`
+}
 
-	cmd := exec.Command(app, args...)
+// UDPSum adds the datagrams received out of order to Sum
+type UDPSum struct {
+	Sum
+	OutOfOrder int64 `json:"out_of_order"`
+}
 
-	// buf := &bytes.Buffer{}
-	// cmd.Stdout = buf
+// CPU is the CPU use of both hosts during a test in percent
+type CPU struct {
+	HostTotal   float64 `json:"host_total"` // the client
+	RemoteTotal float64 `json:"remote_total"`
+}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to run iperf3 cmd: %w", err)
+// Flow summarizes one direction of a test
+type Flow struct {
+	Protocol            string    `json:"protocol"`        // TCP or UDP
+	Bytes               int64     `json:"bytes"`           // received
+	BitsPerSecond       float64   `json:"bits_per_second"` // received
+	Intervals           []float64 `json:"intervals"`       // bits per second of each interval
+	StdDevBitsPerSecond float64   `json:"std_dev_bits_per_second"`
+	Retransmits         int       `json:"retransmits"`
+	MinRTT              float64   `json:"min_rtt"` // ms, 0 if unknown
+	MeanRTT             float64   `json:"mean_rtt"`
+	MaxRTT              float64   `json:"max_rtt"`
+	SenderCPU           float64   `json:"sender_cpu"` // percent
+	ReceiverCPU         float64   `json:"receiver_cpu"`
+	// UDP only
+	JitterMS    float64 `json:"jitter_ms"`
+	LostPackets int64   `json:"lost_packets"`
+	Packets     int64   `json:"packets"`
+	LostPercent float64 `json:"lost_percent"`
+	OutOfOrder  int64   `json:"out_of_order"`
+}
+
+// Flow summarizes the direction the test was started with, the forward half
+// of a bidirectional test
+func (r *Results) Flow() Flow {
+	intervals := []Sum{}
+	for _, interval := range r.Intervals {
+		intervals = append(intervals, interval.Sum)
 	}
-	fmt.Println("iperf3 server started at localhost port:", port)
-	return cmd, nil
+	sent := r.Start.TestStart.Reverse == 0
+	return r.flow(r.End.SumSent, r.End.SumReceived, intervals, sent)
 }
 
-func StopServer(cmd *exec.Cmd) error {
-	err := cmd.Process.Kill()
-	if err != nil {
-		return err
+// BidirReverseFlow summarizes the reverse half of a bidirectional test
+func (r *Results) BidirReverseFlow() Flow {
+	intervals := []Sum{}
+	for _, interval := range r.Intervals {
+		intervals = append(intervals, interval.SumBidirReverse)
 	}
-	return nil
+	return r.flow(r.End.SumSentBidirReverse, r.End.SumReceivedBidirReverse, intervals, false)
 }
 
-// Mock Upload
-func StartStream(ip string, basePort string, serverCount int, size string, title string) (*Results, error) {
+// flow reads each figure from the side that measured it, clientSent tells if
+// the client was the sender
+func (r *Results) flow(sent Sum, received Sum, intervals []Sum, clientSent bool) Flow {
+	// iperf3 before 3.9 only totals a UDP test in sum
+	udp := r.Start.TestStart.Protocol == "UDP"
+	if udp && r.Start.TestStart.Bidir == 0 && received.Packets == 0 && sent.Packets == 0 {
+		sent, received = r.End.Sum, r.End.Sum
+	}
+	flow := Flow{
+		Protocol:      r.Start.TestStart.Protocol,
+		Bytes:         received.Bytes,
+		BitsPerSecond: received.BitsPerSecond,
+		Intervals:     []float64{},
+		Retransmits:   sent.Retransmits,
+	}
 
-	for i := 0; i < serverCount; i += 1 {
-		port, err := strconv.Atoi(basePort)
-		if err != nil {
-			return nil, fmt.Errorf("failed to convert basePort to int: %w", err)
+	for _, interval := range intervals {
+		if !interval.Omitted {
+			flow.Intervals = append(flow.Intervals, interval.BitsPerSecond)
 		}
-		port += i
+	}
+	flow.StdDevBitsPerSecond = stdDev(flow.Intervals)
 
-		res, err := tryStartStream(ip, fmt.Sprint(port), size, title, FORWARD)
-		if err != nil {
-			fmt.Printf("failed to start stream with port %s: %v\n", fmt.Sprint(port), err)
+	// Streams of a bidirectional test are told apart by their sender flag
+	count := 0
+	for _, stream := range r.End.Streams {
+		if r.Start.TestStart.Bidir == 1 && stream.Sender.Sender != clientSent {
 			continue
 		}
-		return res, nil
+		if stream.Sender.MaxRTT == 0 {
+			continue
+		}
+		minRTT := float64(stream.Sender.MinRTT) / 1000
+		if count == 0 || minRTT < flow.MinRTT {
+			flow.MinRTT = minRTT
+		}
+		flow.MaxRTT = max(flow.MaxRTT, float64(stream.Sender.MaxRTT)/1000)
+		flow.MeanRTT += float64(stream.Sender.MeanRTT) / 1000
+		count++
+	}
+	if count > 0 {
+		flow.MeanRTT /= float64(count)
+	}
 
+	if udp {
+		flow.JitterMS = received.JitterMS
+		flow.LostPackets = received.LostPackets
+		flow.Packets = received.Packets
+		flow.LostPercent = received.LostPercent
+		for _, stream := range r.End.Streams {
+			if r.Start.TestStart.Bidir == 1 && stream.UDP.Sender != clientSent {
+				continue
+			}
+			flow.OutOfOrder += stream.UDP.OutOfOrder
+		}
 	}
-	return nil, fmt.Errorf("failed to send stream, no port available")
+
+	flow.SenderCPU = r.End.CPUUtilizationPercent.RemoteTotal
+	flow.ReceiverCPU = r.End.CPUUtilizationPercent.HostTotal
+	if clientSent {
+		flow.SenderCPU, flow.ReceiverCPU = flow.ReceiverCPU, flow.SenderCPU
+	}
+	return flow
+}
+
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, value := range values {
+		mean += value
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (value - mean) * (value - mean)
+	}
+	return math.Sqrt(variance / float64(len(values)))
 }
 
-// Mock Download
-func StartReverseStream(ip string, basePort string, serverCount int, size string, title string) (*Results, error) {
+// StreamOptions select what a client transfers
+type StreamOptions struct {
+	Size      string        // bytes to transfer, e.g. 10M
+	Duration  time.Duration // transfer for this long instead, when set
+	Direction streamDirection
+	UDP       bool
+	Bitrate   string // target bitrate, e.g. 10M, caps TCP, iperf3 defaults UDP to 1M
+}
+
+// StartTransfer runs a client against the first of the serverCount servers
+// that accepts it
+func StartTransfer(ip string, basePort string, serverCount int, title string, options StreamOptions) (*Results, error) {
 	for i := 0; i < serverCount; i += 1 {
 		port, err := strconv.Atoi(basePort)
 		if err != nil {
@@ -103,45 +234,61 @@ func StartReverseStream(ip string, basePort string, serverCount int, size string
 		}
 		port += i
 
-		res, err := tryStartStream(ip, fmt.Sprint(port), size, title, REVERSE)
+		res, err := tryStartStream(ip, fmt.Sprint(port), title, options)
 		if err != nil {
-			fmt.Printf("failed to start reverse stream with port %s: %v\n", fmt.Sprint(port), err)
+			slog.Warn("failed to start stream", logging.KeyTransaction, title, "port", port, logging.Err(err))
 			continue
 		}
 		return res, nil
 
 	}
 	return nil, fmt.Errorf("failed to send stream, no port available")
-
 }
 
-func tryStartStream(ip string, port string, size string, title string, direction streamDirection) (*Results, error) {
+func tryStartStream(ip string, port string, title string, options StreamOptions) (*Results, error) {
 	args := []string{
 		"-c", ip,
 		"-p", port,
-		"-n", size,
 		"-T", title,
 		"-J", // JSON output
 	}
+	if options.Duration > 0 {
+		args = append(args, "-t", strconv.FormatFloat(options.Duration.Seconds(), 'f', -1, 64))
+	} else {
+		args = append(args, "-n", options.Size)
+	}
 
-	if direction == REVERSE {
+	if options.UDP {
+		args = append(args, "-u")
+	}
+	if options.Bitrate != "" {
+		args = append(args, "-b", options.Bitrate)
+	}
+
+	switch options.Direction {
+	case REVERSE:
 		args = append(args, "-R")
+	case BIDIRECTIONAL:
+		args = append(args, "--bidir")
 	}
 
 	cmd := exec.Command(app, args...)
 
+	// A failed test still prints JSON, its error says more than the exit code
 	out, err := cmd.Output()
+	results := Results{}
+	jsonErr := json.Unmarshal(out, &results)
+	if jsonErr == nil && results.Error != "" {
+		return nil, fmt.Errorf("iperf3 test failed: %s", results.Error)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to run iperf3 cmd: %w", err)
 	}
-
-	results := Results{}
-	if err = json.Unmarshal(out, &results); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal json results: %w", err)
+	if jsonErr != nil {
+		return nil, fmt.Errorf("failed to unmarshal json results: %w", jsonErr)
 	}
 
-	jsonResults, _ := json.MarshalIndent(results, "\t", "\t")
-	fmt.Printf("iperf3 stream output: %s\n", string(jsonResults))
+	slog.Debug("iperf3 stream output", logging.KeyTransaction, title, "port", port, "output", string(out))
 
 	// if _, err := cmd.Process.Wait(); err != nil {
 	// 	fmt.Println("failed to wait for iperf3 cmd process to exit:", err)
@@ -0,0 +1,159 @@
+package iperf3
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// Trimmed iperf3 -J outputs, run from the client
+const (
+	tcpForward = `{
+		"start": {"test_start": {"protocol": "TCP", "reverse": 0, "bidir": 0}},
+		"intervals": [
+			{"sum": {"bits_per_second": 100, "omitted": true}},
+			{"sum": {"bits_per_second": 200}},
+			{"sum": {"bits_per_second": 400}}
+		],
+		"end": {
+			"streams": [
+				{"sender": {"sender": true, "min_rtt": 1000, "max_rtt": 5000, "mean_rtt": 2000}},
+				{"sender": {"sender": true, "min_rtt": 500, "max_rtt": 3000, "mean_rtt": 4000}}
+			],
+			"sum_sent": {"bytes": 1100, "bits_per_second": 310, "retransmits": 3},
+			"sum_received": {"bytes": 1000, "bits_per_second": 300},
+			"cpu_utilization_percent": {"host_total": 12.5, "remote_total": 4}
+		}
+	}`
+	tcpReverse = `{
+		"start": {"test_start": {"protocol": "TCP", "reverse": 1, "bidir": 0}},
+		"intervals": [{"sum": {"bits_per_second": 50}}],
+		"end": {
+			"streams": [{"sender": {"sender": false}}],
+			"sum_sent": {"bytes": 500, "bits_per_second": 50, "retransmits": 1},
+			"sum_received": {"bytes": 480, "bits_per_second": 48},
+			"cpu_utilization_percent": {"host_total": 12.5, "remote_total": 4}
+		}
+	}`
+	// iperf3 before 3.9 only totals a UDP test in sum
+	udpLegacy = `{
+		"start": {"test_start": {"protocol": "UDP", "reverse": 0, "bidir": 0}},
+		"intervals": [{"sum": {"bits_per_second": 1000000}}],
+		"end": {
+			"streams": [{"udp": {"sender": true, "out_of_order": 2}}],
+			"sum": {"bytes": 125000, "bits_per_second": 1000000, "jitter_ms": 0.25, "lost_packets": 5,
+				"packets": 100, "lost_percent": 5}
+		}
+	}`
+	tcpBidir = `{
+		"start": {"test_start": {"protocol": "TCP", "reverse": 0, "bidir": 1}},
+		"intervals": [
+			{"sum": {"bits_per_second": 10}, "sum_bidir_reverse": {"bits_per_second": 20}}
+		],
+		"end": {
+			"streams": [
+				{"sender": {"sender": true, "min_rtt": 1000, "max_rtt": 1000, "mean_rtt": 1000}},
+				{"sender": {"sender": false, "min_rtt": 9000, "max_rtt": 9000, "mean_rtt": 9000}}
+			],
+			"sum_sent": {"bytes": 100, "bits_per_second": 10},
+			"sum_received": {"bytes": 90, "bits_per_second": 9},
+			"sum_sent_bidir_reverse": {"bytes": 200, "bits_per_second": 20},
+			"sum_received_bidir_reverse": {"bytes": 190, "bits_per_second": 19}
+		}
+	}`
+)
+
+func parseResults(t *testing.T, output string) *Results {
+	t.Helper()
+	results := &Results{}
+	if err := json.Unmarshal([]byte(output), results); err != nil {
+		t.Fatalf("failed to unmarshal results: %v", err)
+	}
+	return results
+}
+
+func TestFlowTCPForward(t *testing.T) {
+	flow := parseResults(t, tcpForward).Flow()
+
+	if flow.Protocol != "TCP" || flow.Bytes != 1000 || flow.BitsPerSecond != 300 {
+		t.Errorf("flow = %s %d bytes %v bps, want TCP 1000 bytes 300 bps", flow.Protocol, flow.Bytes,
+			flow.BitsPerSecond)
+	}
+	if flow.Retransmits != 3 {
+		t.Errorf("Retransmits = %d, want 3", flow.Retransmits)
+	}
+	// The omitted warm up interval is left out
+	if len(flow.Intervals) != 2 || flow.Intervals[0] != 200 || flow.Intervals[1] != 400 {
+		t.Errorf("Intervals = %v, want [200 400]", flow.Intervals)
+	}
+	if flow.StdDevBitsPerSecond != 100 {
+		t.Errorf("StdDevBitsPerSecond = %v, want 100", flow.StdDevBitsPerSecond)
+	}
+	if flow.MinRTT != 0.5 || flow.MaxRTT != 5 || flow.MeanRTT != 3 {
+		t.Errorf("RTT min/mean/max = %v/%v/%v ms, want 0.5/3/5", flow.MinRTT, flow.MeanRTT, flow.MaxRTT)
+	}
+	// The client sent
+	if flow.SenderCPU != 12.5 || flow.ReceiverCPU != 4 {
+		t.Errorf("CPU sender/receiver = %v/%v, want 12.5/4", flow.SenderCPU, flow.ReceiverCPU)
+	}
+}
+
+func TestFlowTCPReverse(t *testing.T) {
+	flow := parseResults(t, tcpReverse).Flow()
+
+	if flow.Bytes != 480 || flow.BitsPerSecond != 48 || flow.Retransmits != 1 {
+		t.Errorf("flow = %d bytes %v bps %d retransmits, want 480 bytes 48 bps 1 retransmit", flow.Bytes,
+			flow.BitsPerSecond, flow.Retransmits)
+	}
+	// RTTs are only known to the sender, the server
+	if flow.MinRTT != 0 || flow.MeanRTT != 0 || flow.MaxRTT != 0 {
+		t.Errorf("RTT min/mean/max = %v/%v/%v ms, want none", flow.MinRTT, flow.MeanRTT, flow.MaxRTT)
+	}
+	if flow.SenderCPU != 4 || flow.ReceiverCPU != 12.5 {
+		t.Errorf("CPU sender/receiver = %v/%v, want 4/12.5", flow.SenderCPU, flow.ReceiverCPU)
+	}
+	if flow.StdDevBitsPerSecond != 0 {
+		t.Errorf("StdDevBitsPerSecond of a single interval = %v, want 0", flow.StdDevBitsPerSecond)
+	}
+}
+
+func TestFlowUDPLegacySum(t *testing.T) {
+	flow := parseResults(t, udpLegacy).Flow()
+
+	if flow.Protocol != "UDP" || flow.Bytes != 125000 || flow.BitsPerSecond != 1000000 {
+		t.Errorf("flow = %s %d bytes %v bps, want UDP 125000 bytes 1000000 bps", flow.Protocol, flow.Bytes,
+			flow.BitsPerSecond)
+	}
+	if flow.JitterMS != 0.25 || flow.LostPackets != 5 || flow.Packets != 100 || flow.LostPercent != 5 {
+		t.Errorf("udp = %v ms jitter %d/%d lost (%v%%), want 0.25 ms 5/100 (5%%)", flow.JitterMS,
+			flow.LostPackets, flow.Packets, flow.LostPercent)
+	}
+	if flow.OutOfOrder != 2 {
+		t.Errorf("OutOfOrder = %d, want 2", flow.OutOfOrder)
+	}
+}
+
+func TestFlowBidirectional(t *testing.T) {
+	results := parseResults(t, tcpBidir)
+
+	forward := results.Flow()
+	if forward.Bytes != 90 || forward.MeanRTT != 1 {
+		t.Errorf("forward = %d bytes %v ms mean RTT, want 90 bytes 1 ms", forward.Bytes, forward.MeanRTT)
+	}
+	reverse := results.BidirReverseFlow()
+	if reverse.Bytes != 190 || reverse.MeanRTT != 9 {
+		t.Errorf("reverse = %d bytes %v ms mean RTT, want 190 bytes 9 ms", reverse.Bytes, reverse.MeanRTT)
+	}
+	if len(reverse.Intervals) != 1 || reverse.Intervals[0] != 20 {
+		t.Errorf("reverse Intervals = %v, want [20]", reverse.Intervals)
+	}
+}
+
+func TestStdDev(t *testing.T) {
+	if got := stdDev([]float64{2, 4, 4, 4, 5, 5, 7, 9}); math.Abs(got-2) > 1e-9 {
+		t.Errorf("stdDev = %v, want 2", got)
+	}
+	if got := stdDev(nil); got != 0 {
+		t.Errorf("stdDev(nil) = %v, want 0", got)
+	}
+}
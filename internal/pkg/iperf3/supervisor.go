@@ -0,0 +1,262 @@
+package iperf3
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+)
+
+const (
+	minBackoff   = time.Second     // first wait before a crashed server is restarted
+	probeTimeout = 2 * time.Second // longest wait for a probe to connect
+)
+
+// SupervisorOptions say how the server processes are watched
+type SupervisorOptions struct {
+	LogDir        string        // per port log files of the server output, discarded if empty
+	ProbeInterval time.Duration // time between health probes
+	ProbeFailures int           // failed probes in a row before a wedged server is restarted
+	MaxBackoff    time.Duration // longest wait before a crashed server is restarted
+	// InUse reports whether a client may be running a test on the port, a
+	// server runs one test at a time so a probe would make it refuse the client
+	InUse func(port int) bool
+}
+
+// Server is a supervised iperf3 server process. The process is reaped when it
+// exits and restarted with exponential backoff, and its port is probed to tell
+// whether it still accepts connections
+type Server struct {
+	port    int
+	options SupervisorOptions
+	log     *slog.Logger
+
+	mutex    sync.Mutex
+	healthy  bool
+	restarts int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StartServers starts and supervises serverCount servers on consecutive ports
+// from basePort
+func StartServers(basePort string, serverCount int, options SupervisorOptions) ([]*Server, error) {
+	port, err := strconv.Atoi(basePort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert basePort to int: %w", err)
+	}
+	if options.LogDir != "" {
+		if err := os.MkdirAll(options.LogDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create server log dir: %w", err)
+		}
+	}
+
+	servers := []*Server{}
+	for i := 0; i < serverCount; i++ {
+		server, err := supervise(port+i, options)
+		if err != nil {
+			for _, server := range servers {
+				server.Stop()
+			}
+			return nil, fmt.Errorf("failed to start server: %w", err)
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// supervise starts the first process of the server on port, a failure to do so
+// is returned rather than retried
+func supervise(port int, options SupervisorOptions) (*Server, error) {
+	s := &Server{
+		port:    port,
+		options: options,
+		log:     slog.With("port", port),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	cmd, err := s.start()
+	if err != nil {
+		return nil, err
+	}
+	go s.run(cmd)
+	return s, nil
+}
+
+// Port is the port the server listens on
+func (s *Server) Port() int {
+	return s.port
+}
+
+// Healthy reports whether the process runs and passed its last probe
+func (s *Server) Healthy() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.healthy
+}
+
+// Restarts counts the times the process was restarted
+func (s *Server) Restarts() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.restarts
+}
+
+// Stop kills the process and waits for it to be reaped, the server is not
+// restarted afterwards
+func (s *Server) Stop() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	return nil
+}
+
+// start runs a new server process writing to the log file of the port
+func (s *Server) start() (*exec.Cmd, error) {
+	args := []string{
+		"-s", // type: server
+		"-p", fmt.Sprint(s.port),
+		"-J", // JSON output
+	}
+	cmd := exec.Command(app, args...)
+
+	if s.options.LogDir != "" {
+		path := filepath.Join(s.options.LogDir, fmt.Sprintf("iperf3-%d.log", s.port))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open server log file: %w", err)
+		}
+		// The child holds its own copy of the descriptor
+		defer file.Close()
+		cmd.Stdout = file
+		cmd.Stderr = file
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to run iperf3 cmd: %w", err)
+	}
+
+	s.mutex.Lock()
+	s.healthy = true
+	s.mutex.Unlock()
+	s.log.Info("iperf3 server started", "pid", cmd.Process.Pid)
+	return cmd, nil
+}
+
+// run watches cmd until the server is stopped, restarting the process
+// whenever it exits
+func (s *Server) run(cmd *exec.Cmd) {
+	defer close(s.done)
+
+	backoff := minBackoff
+	for {
+		started := time.Now()
+		if stopped := s.watch(cmd); stopped {
+			return
+		}
+
+		s.mutex.Lock()
+		s.healthy = false
+		s.mutex.Unlock()
+
+		// A server that ran a while crashed for a new reason, start over
+		if time.Since(started) > s.options.MaxBackoff {
+			backoff = minBackoff
+		}
+		for {
+			s.log.Info("restarting iperf3 server", "backoff", backoff)
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, max(s.options.MaxBackoff, minBackoff))
+
+			var err error
+			cmd, err = s.start()
+			if err == nil {
+				break
+			}
+			s.log.Error("failed to restart iperf3 server", logging.Err(err))
+		}
+
+		s.mutex.Lock()
+		s.restarts++
+		s.mutex.Unlock()
+	}
+}
+
+// watch reaps cmd and probes its port, it returns once the process exited or,
+// with stopped set, once the server was stopped and the process reaped. A
+// server failing ProbeFailures probes in a row is killed to be restarted
+func (s *Server) watch(cmd *exec.Cmd) (stopped bool) {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	var probes <-chan time.Time
+	if s.options.ProbeInterval > 0 {
+		ticker := time.NewTicker(s.options.ProbeInterval)
+		defer ticker.Stop()
+		probes = ticker.C
+	}
+
+	failures := 0
+	for {
+		select {
+		case <-s.stop:
+			cmd.Process.Kill()
+			<-exited
+			s.mutex.Lock()
+			s.healthy = false
+			s.mutex.Unlock()
+			s.log.Info("iperf3 server stopped")
+			return true
+
+		case <-exited:
+			s.log.Warn("iperf3 server exited", "state", cmd.ProcessState.String())
+			return false
+
+		case <-probes:
+			if s.options.InUse != nil && s.options.InUse(s.port) {
+				continue
+			}
+			err := probe(s.port)
+			if err == nil {
+				failures = 0
+			} else {
+				failures++
+				s.log.Warn("iperf3 server probe failed", "failures", failures, logging.Err(err))
+			}
+			s.mutex.Lock()
+			s.healthy = err == nil
+			s.mutex.Unlock()
+
+			if s.options.ProbeFailures > 0 && failures == s.options.ProbeFailures {
+				s.log.Warn("killing wedged iperf3 server", "failures", failures)
+				cmd.Process.Kill()
+			}
+		}
+	}
+}
+
+// probe connects to the control port of a server and hangs up. The server
+// logs the aborted test and keeps listening
+func probe(port int) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)), probeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,100 @@
+// Package logging builds the structured loggers of the nodes, every line
+// carries the node id and, where known, the event type and transaction id
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"wifi-trade-consensus/internal/pkg/events"
+)
+
+// Field keys shared by every node, so one transaction can be followed across
+// containers
+const (
+	KeyNode        = "node"
+	KeyEvent       = "event"
+	KeyTransaction = "transaction_id"
+	KeyPeer        = "peer"
+	KeyError       = "error"
+)
+
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options are embedded in the config of every node
+type Options struct {
+	Level  string `mapstructure:"log_level" json:"log_level"`   // debug, info (default), warn or error
+	Format string `mapstructure:"log_format" json:"log_format"` // text (default) or json
+}
+
+// New returns a logger writing to stdout tagged with the node id
+func New(node string, opt Options) (*slog.Logger, error) {
+	return newLogger(os.Stdout, node, opt)
+}
+
+// Validate checks the level and format without building a logger
+func (opt Options) Validate() error {
+	if _, err := opt.level(); err != nil {
+		return err
+	}
+	return opt.validateFormat()
+}
+
+func (opt Options) level() (slog.Level, error) {
+	level := slog.LevelInfo
+	if opt.Level != "" {
+		if err := level.UnmarshalText([]byte(opt.Level)); err != nil {
+			return level, fmt.Errorf("invalid log_level %q: %w", opt.Level, err)
+		}
+	}
+	return level, nil
+}
+
+func (opt Options) validateFormat() error {
+	switch strings.ToLower(opt.Format) {
+	case "", FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid log_format %q, want text or json", opt.Format)
+}
+
+func newLogger(w io.Writer, node string, opt Options) (*slog.Logger, error) {
+	level, err := opt.level()
+	if err != nil {
+		return nil, err
+	}
+	if err := opt.validateFormat(); err != nil {
+		return nil, err
+	}
+
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.ToLower(opt.Format) == FormatJSON {
+		handler = slog.NewJSONHandler(w, handlerOptions)
+	} else {
+		handler = slog.NewTextHandler(w, handlerOptions)
+	}
+
+	return slog.New(handler).With(KeyNode, node), nil
+}
+
+// Event is the event type attribute
+func Event(event int) slog.Attr {
+	return slog.String(KeyEvent, events.Name(event))
+}
+
+// Transaction is the transaction id attribute
+func Transaction(transactionID fmt.Stringer) slog.Attr {
+	return slog.String(KeyTransaction, transactionID.String())
+}
+
+// Err is the error attribute
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
@@ -0,0 +1,300 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus
+// text format
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"wifi-trade-consensus/internal/pkg/logging"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// Outcomes of an outbound message, for the outcome label
+const (
+	OutcomeSent       = "sent"
+	OutcomeDialFailed = "dial_failed"
+	OutcomeSendFailed = "send_failed"
+)
+
+// DurationBuckets are histogram buckets in seconds for message round trips
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics of a node, every series is labelled with the
+// node id
+type Registry struct {
+	mutex      sync.Mutex
+	node       string
+	families   []*family
+	collectors []func()
+	server     *http.Server // nil until Serve
+}
+
+type family struct {
+	mutex      sync.Mutex
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*series // index: joined label values
+}
+
+type series struct {
+	labelValues  []string
+	value        float64 // counter and gauge value
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+type Counter struct{ family *family }
+
+type Gauge struct{ family *family }
+
+type Histogram struct{ family *family }
+
+func NewRegistry(node string) *Registry {
+	return &Registry{node: node}
+}
+
+func (r *Registry) Counter(name string, help string, labelNames ...string) *Counter {
+	return &Counter{r.register(name, help, kindCounter, nil, labelNames)}
+}
+
+func (r *Registry) Gauge(name string, help string, labelNames ...string) *Gauge {
+	return &Gauge{r.register(name, help, kindGauge, nil, labelNames)}
+}
+
+func (r *Registry) Histogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{r.register(name, help, kindHistogram, sorted, labelNames)}
+}
+
+// OnScrape registers a function that updates gauges right before every
+// scrape, for values that are cheaper to read than to track
+func (r *Registry) OnScrape(collect func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+func (r *Registry) register(name string, help string, kind string, buckets []float64, labelNames []string) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+// get returns the series of labelValues, f.mutex must be held
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.kind == kindHistogram {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, negative values are ignored
+func (c *Counter) Add(val float64, labelValues ...string) {
+	if val < 0 {
+		return
+	}
+	c.family.mutex.Lock()
+	defer c.family.mutex.Unlock()
+	c.family.get(labelValues).value += val
+}
+
+func (g *Gauge) Set(val float64, labelValues ...string) {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.get(labelValues).value = val
+}
+
+func (g *Gauge) Add(val float64, labelValues ...string) {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.get(labelValues).value += val
+}
+
+// Reset drops every series, e.g. before re-populating per peer gauges
+func (g *Gauge) Reset() {
+	g.family.mutex.Lock()
+	defer g.family.mutex.Unlock()
+	g.family.series = make(map[string]*series)
+}
+
+func (h *Histogram) Observe(val float64, labelValues ...string) {
+	h.family.mutex.Lock()
+	defer h.family.mutex.Unlock()
+
+	s := h.family.get(labelValues)
+	for idx, bound := range h.family.buckets {
+		if val <= bound {
+			s.bucketCounts[idx]++
+		}
+	}
+	s.sum += val
+	s.count++
+}
+
+// Write writes every metric in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mutex.Lock()
+	collectors := append([]func(){}, r.collectors...)
+	families := append([]*family{}, r.families...)
+	r.mutex.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	builder := &strings.Builder{}
+	for _, f := range families {
+		r.writeFamily(builder, f)
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+func (r *Registry) writeFamily(b *strings.Builder, f *family) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(b, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		labels := r.labels(f.labelNames, s.labelValues)
+		if f.kind != kindHistogram {
+			fmt.Fprintf(b, "%s%s %s\n", f.name, formatLabels(labels), formatValue(s.value))
+			continue
+		}
+
+		for idx, bound := range f.buckets {
+			bucketLabels := append(append([][2]string{}, labels...), [2]string{"le", formatValue(bound)})
+			fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, formatLabels(bucketLabels), s.bucketCounts[idx])
+		}
+		infLabels := append(append([][2]string{}, labels...), [2]string{"le", "+Inf"})
+		fmt.Fprintf(b, "%s_bucket%s %d\n", f.name, formatLabels(infLabels), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", f.name, formatLabels(labels), formatValue(s.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", f.name, formatLabels(labels), s.count)
+	}
+}
+
+func (r *Registry) labels(names []string, values []string) [][2]string {
+	labels := [][2]string{{"node", r.node}}
+	for idx, name := range names {
+		labels = append(labels, [2]string{name, values[idx]})
+	}
+	return labels
+}
+
+func formatLabels(labels [][2]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, label[0]+`="`+escapeLabelValue(label[1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(val float64) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "+Inf"
+	case math.IsInf(val, -1):
+		return "-Inf"
+	case math.IsNaN(val):
+		return "NaN"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(val string) string {
+	return labelValueEscaper.Replace(val)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// Serve exposes the metrics at http://address/metrics in the background
+func (r *Registry) Serve(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen metrics address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := r.Write(w); err != nil {
+			slog.Error("failed to write metrics", logging.Err(err))
+		}
+	})
+
+	r.server = &http.Server{Handler: mux}
+	go func() {
+		if err := r.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", logging.Err(err))
+		}
+	}()
+	slog.Info("serving metrics", "address", address)
+	return nil
+}
+
+// Close stops serving the metrics, if they are served
+func (r *Registry) Close() error {
+	if r.server == nil {
+		return nil
+	}
+	return r.server.Close()
+}
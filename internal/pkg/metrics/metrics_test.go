@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	t.Helper()
+	builder := &strings.Builder{}
+	if err := registry.Write(builder); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return builder.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	registry := NewRegistry("p0")
+	messages := registry.Counter("wtc_messages_total", "Messages sent", "event", "outcome")
+	peers := registry.Gauge("wtc_peers", "Known peers")
+
+	messages.Inc("BUY", OutcomeSent)
+	messages.Add(2, "BUY", OutcomeSent)
+	messages.Add(-5, "BUY", OutcomeSent) // ignored, counters only go up
+	messages.Inc("LEAVE", OutcomeDialFailed)
+	peers.Set(3)
+	peers.Add(-1)
+
+	want := `# HELP wtc_messages_total Messages sent
+# TYPE wtc_messages_total counter
+wtc_messages_total{node="p0",event="BUY",outcome="sent"} 3
+wtc_messages_total{node="p0",event="LEAVE",outcome="dial_failed"} 1
+# HELP wtc_peers Known peers
+# TYPE wtc_peers gauge
+wtc_peers{node="p0"} 2
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry("c0")
+	durations := registry.Histogram("wtc_duration_seconds", "Round trips", []float64{1, 0.1}, "event")
+
+	durations.Observe(0.05, "BUY")
+	durations.Observe(0.5, "BUY")
+	durations.Observe(2, "BUY")
+
+	// Buckets are sorted and cumulative
+	want := `# HELP wtc_duration_seconds Round trips
+# TYPE wtc_duration_seconds histogram
+wtc_duration_seconds_bucket{node="c0",event="BUY",le="0.1"} 1
+wtc_duration_seconds_bucket{node="c0",event="BUY",le="1"} 2
+wtc_duration_seconds_bucket{node="c0",event="BUY",le="+Inf"} 3
+wtc_duration_seconds_sum{node="c0",event="BUY"} 2.55
+wtc_duration_seconds_count{node="c0",event="BUY"} 3
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	registry := NewRegistry(`node "a"`)
+	registry.Gauge("wtc_value", "Line one\nback\\slash", "path").Set(math.Inf(1), "C:\\dir\n")
+
+	want := `# HELP wtc_value Line one\nback\\slash
+# TYPE wtc_value gauge
+wtc_value{node="node \"a\"",path="C:\\dir\n"} +Inf
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("Write =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOnScrapeRunsBeforeWrite(t *testing.T) {
+	registry := NewRegistry("p0")
+	flows := registry.Gauge("wtc_active_flows", "Active flows")
+	scrapes := 0
+	registry.OnScrape(func() {
+		scrapes++
+		flows.Set(float64(scrapes))
+	})
+
+	scrape(t, registry)
+	if got := scrape(t, registry); !strings.Contains(got, `wtc_active_flows{node="p0"} 2`) {
+		t.Errorf("second scrape =\n%s\nwant the gauge set by the collector", got)
+	}
+}
+
+func TestResetDropsSeries(t *testing.T) {
+	registry := NewRegistry("p0")
+	scores := registry.Gauge("wtc_peer_score", "Peer scores", "peer")
+	scores.Set(1, "p1")
+	scores.Reset()
+	scores.Set(2, "p2")
+
+	got := scrape(t, registry)
+	if strings.Contains(got, `peer="p1"`) || !strings.Contains(got, `wtc_peer_score{node="p0",peer="p2"} 2`) {
+		t.Errorf("Write after Reset =\n%s", got)
+	}
+}
@@ -0,0 +1,172 @@
+package oracle
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Provider is the ground truth of a provider, speeds are in megabytes per
+// second like the speeds measured by the consumer
+type Provider struct {
+	ProviderID    string  `json:"provider_id"`
+	Price         float64 `json:"price"`
+	UplinkSpeed   float64 `json:"uplink_speed"`
+	DownlinkSpeed float64 `json:"downlink_speed"`
+	// Filled in from the rate table of network_limiter.sh when the speeds
+	// aren't set
+	NodeNum  *int `json:"node_num"`
+	IsFaulty bool `json:"is_faulty"`
+	// Provider config file the price is read from when it isn't set
+	ConfigPath string `json:"config_path"`
+}
+
+// Oracle knows the true price and capacity of every provider
+type Oracle struct {
+	NetworkLimiterPath string     `json:"network_limiter_path"`
+	Providers          []Provider `json:"providers"`
+
+	providers map[string]Provider
+}
+
+// QOS is the part of a transaction's QoS requirements used by the fittingness
+// formula
+type QOS struct {
+	PriceConsumer         float64
+	UplinkSpeedConsumer   float64
+	DownlinkSpeedConsumer float64
+	Mu                    float64
+	Epsilon               float64
+}
+
+// Verdict compares the provider chosen by consensus with the ideal one
+type Verdict struct {
+	Winner  string             `json:"winner"` // ideal provider
+	Matched bool               `json:"matched"`
+	Regret  float64            `json:"regret"` // FF of the ideal provider minus FF of the chosen one
+	Rank    int                `json:"rank"`   // 1-based rank of the chosen provider, 0 if unknown to the oracle
+	FFS     map[string]float64 `json:"FFS"`    // true FF of every candidate
+}
+
+// Load reads the oracle file and resolves every provider's speeds and price
+func Load(path string) (*Oracle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oracle file: %w", err)
+	}
+
+	o := &Oracle{}
+	if err := json.Unmarshal(data, o); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal oracle file: %w", err)
+	}
+
+	var rates rateTable
+	if o.NetworkLimiterPath != "" {
+		rates, err = readRateTable(o.NetworkLimiterPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o.providers = make(map[string]Provider, len(o.Providers))
+	for idx, provider := range o.Providers {
+		if provider.ProviderID == "" {
+			return nil, fmt.Errorf("provider %d has no provider_id", idx)
+		}
+
+		if provider.UplinkSpeed == 0 || provider.DownlinkSpeed == 0 {
+			if provider.NodeNum == nil || rates == nil {
+				return nil, fmt.Errorf("provider %s needs speeds or node_num and network_limiter_path", provider.ProviderID)
+			}
+			rate := rates.lookup(*provider.NodeNum, provider.IsFaulty)
+			if provider.UplinkSpeed == 0 {
+				provider.UplinkSpeed = rate.up
+			}
+			if provider.DownlinkSpeed == 0 {
+				provider.DownlinkSpeed = rate.down
+			}
+		}
+
+		if provider.Price == 0 && provider.ConfigPath != "" {
+			provider.Price, err = readConfigPrice(provider.ConfigPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		o.Providers[idx] = provider
+		o.providers[provider.ProviderID] = provider
+	}
+
+	return o, nil
+}
+
+// Judge ranks the candidates by their true FF, quotedPrices (index: provider
+// id) is used for providers without a configured price
+func (o *Oracle) Judge(qos QOS, candidates []string, quotedPrices map[string]float64, chosen string) Verdict {
+	verdict := Verdict{FFS: map[string]float64{}}
+
+	ranked := []string{}
+	for _, id := range candidates {
+		provider, exists := o.providers[id]
+		if !exists {
+			continue
+		}
+		if provider.Price == 0 {
+			provider.Price = quotedPrices[id]
+		}
+		verdict.FFS[id] = fittingness(qos, provider)
+		ranked = append(ranked, id)
+	}
+	if len(ranked) == 0 {
+		return verdict
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return verdict.FFS[ranked[i]] > verdict.FFS[ranked[j]]
+	})
+	verdict.Winner = ranked[0]
+
+	for idx, id := range ranked {
+		if id == chosen {
+			verdict.Rank = idx + 1
+			verdict.Regret = verdict.FFS[verdict.Winner] - verdict.FFS[id]
+			// Ties with the ideal provider count as a match
+			verdict.Matched = verdict.Regret == 0
+		}
+	}
+
+	return verdict
+}
+
+// fittingness mirrors calculateFittingnessFactor of the provider with true
+// price and speeds. Uptime, load, signal strength and consumer feedback are
+// taken as 1, they describe how a provider is perceived rather than what it
+// offers
+func fittingness(qos QOS, provider Provider) float64 {
+	dividend := (1 - (provider.Price / qos.PriceConsumer)) + (qos.Epsilon - 1)
+	PF := dividend / qos.Epsilon
+
+	// Same as the provider, both directions are weighted with mu
+	up := math.Pow(provider.UplinkSpeed/qos.UplinkSpeedConsumer, qos.Mu)
+	down := math.Pow(provider.DownlinkSpeed/qos.DownlinkSpeedConsumer, qos.Mu)
+	SF := (up / (1 + up)) * (down / (1 + down))
+
+	return PF * SF
+}
+
+func readConfigPrice(path string) (float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read provider config: %w", err)
+	}
+	config := struct {
+		Price float64 `json:"price"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal provider config %s: %w", path, err)
+	}
+	return config.Price, nil
+}
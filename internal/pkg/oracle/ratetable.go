@@ -0,0 +1,130 @@
+package oracle
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Keys of the rate table besides node numbers
+const (
+	defaultRate = "*"
+	faultyRate  = "faulty"
+)
+
+type rate struct {
+	up   float64 // megabytes per second
+	down float64
+}
+
+// rateTable holds the tc rates of network_limiter.sh, index: node number,
+// defaultRate or faultyRate
+type rateTable map[string]rate
+
+var (
+	caseLine   = regexp.MustCompile(`^\s*(\d+|\*)\)\s*$`)
+	faultyLine = regexp.MustCompile(`is_faulty"?\s*=\s*"?true`)
+	assignLine = regexp.MustCompile(`^\s*(up|down)=(\S+)`)
+)
+
+// readRateTable extracts the rates of the node_num case statement and the
+// is_faulty override from the script
+func readRateTable(path string) (rateTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network limiter script: %w", err)
+	}
+	defer file.Close()
+
+	rates := rateTable{}
+	key := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+
+		switch {
+		case caseLine.MatchString(line):
+			key = caseLine.FindStringSubmatch(line)[1]
+		case faultyLine.MatchString(line):
+			key = faultyRate
+		case strings.Contains(line, ";;"), strings.TrimSpace(line) == "fi", strings.TrimSpace(line) == "esac":
+			key = ""
+		case key != "" && assignLine.MatchString(line):
+			match := assignLine.FindStringSubmatch(line)
+			speed, err := parseTCRate(match[2])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse rate of %s in %s: %w", key, path, err)
+			}
+			entry := rates[key]
+			if match[1] == "up" {
+				entry.up = speed
+			} else {
+				entry.down = speed
+			}
+			rates[key] = entry
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read network limiter script: %w", err)
+	}
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no rates found in %s", path)
+	}
+
+	return rates, nil
+}
+
+func (r rateTable) lookup(nodeNum int, isFaulty bool) rate {
+	if isFaulty {
+		if entry, exists := r[faultyRate]; exists {
+			return entry
+		}
+	}
+	if entry, exists := r[strconv.Itoa(nodeNum)]; exists {
+		return entry
+	}
+	return r[defaultRate]
+}
+
+// tc rate units, in megabytes per second
+var tcUnits = []struct {
+	suffix string
+	factor float64
+}{
+	// Longest suffixes first, "mbps" also ends with "bps"
+	{"kbit", 1.0 / 8 / 1000},
+	{"mbit", 1.0 / 8},
+	{"gbit", 1000.0 / 8},
+	{"kbps", 1.0 / 1000},
+	{"mbps", 1},
+	{"gbps", 1000},
+	{"bit", 1.0 / 8 / 1000000},
+	{"bps", 1.0 / 1000000},
+}
+
+// parseTCRate converts a tc rate such as 10mbps (megabytes per second) or
+// 80mbit to megabytes per second
+func parseTCRate(val string) (float64, error) {
+	lower := strings.ToLower(val)
+	for _, unit := range tcUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			number, err := strconv.ParseFloat(strings.TrimSuffix(lower, unit.suffix), 64)
+			if err != nil {
+				return 0, err
+			}
+			return number * unit.factor, nil
+		}
+	}
+	// tc treats a bare number as bits per second
+	number, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, err
+	}
+	return number / 8 / 1000000, nil
+}
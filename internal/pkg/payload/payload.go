@@ -7,6 +7,7 @@ type Meta struct {
 	TransactionID uuid.UUID `json:"transaction_id"`
 	OriginID      string    `json:"origin_id"`
 	OriginAddress string    `json:"origin_address"`
+	Traceparent   string    `json:"traceparent,omitempty"` // W3C trace context of the sending span
 	// Size                  int       `json:"size"`
 	// Utilization           int       `json:"utilization"`
 }
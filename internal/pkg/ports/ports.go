@@ -0,0 +1,167 @@
+// Package ports leases the data-plane ports of a node's throughput servers to
+// transactions, so concurrent flows never race for the same server
+package ports
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrExhausted is returned when fewer ports are free than a lease asks for
+var ErrExhausted = errors.New("port pool exhausted")
+
+// Pool holds the ports basePort..basePort+count-1, each leased to at most one
+// owner
+type Pool struct {
+	mutex   sync.Mutex
+	ports   []int
+	leases  map[string]lease // index: owner
+	timeout time.Duration    // 0 never expires a lease
+	healthy func(port int) bool
+}
+
+type lease struct {
+	ports []int
+	start time.Time
+}
+
+// NewPool returns a pool of count ports from basePort, leases held longer than
+// timeout are reported by Expired
+func NewPool(basePort string, count int, timeout time.Duration) (*Pool, error) {
+	port, err := strconv.Atoi(basePort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert basePort to int: %w", err)
+	}
+
+	pool := &Pool{leases: map[string]lease{}, timeout: timeout}
+	for i := 0; i < count; i++ {
+		pool.ports = append(pool.ports, port+i)
+	}
+	return pool, nil
+}
+
+// Check makes the pool lease only the ports healthy accepts, leases already
+// held are kept
+func (p *Pool) Check(healthy func(port int) bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.healthy = healthy
+}
+
+// Healthy returns the ports that pass the check, leased or not
+func (p *Pool) Healthy() []int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	healthy := []int{}
+	for _, port := range p.ports {
+		if p.healthy == nil || p.healthy(port) {
+			healthy = append(healthy, port)
+		}
+	}
+	return healthy
+}
+
+// Lease gives n free ports to owner, the lowest first. An owner that already
+// holds a lease gets the same ports back
+func (p *Pool) Lease(owner string, n int, now time.Time) ([]int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if held, exists := p.leases[owner]; exists {
+		return held.ports, nil
+	}
+
+	free := p.free()
+	if len(free) < n {
+		return nil, fmt.Errorf("%w, %d of %d ports free and healthy, want %d", ErrExhausted, len(free), len(p.ports), n)
+	}
+	p.leases[owner] = lease{ports: free[:n], start: now}
+	return free[:n], nil
+}
+
+// Release returns the ports of owner to the pool, it reports whether owner
+// held any
+func (p *Pool) Release(owner string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	_, exists := p.leases[owner]
+	delete(p.leases, owner)
+	return exists
+}
+
+// Free counts the healthy ports that can be leased
+func (p *Pool) Free() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.free())
+}
+
+// Expired returns the owners that have held their lease for timeout at now,
+// the leases are kept until their owner releases them
+func (p *Pool) Expired(now time.Time) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.timeout == 0 {
+		return nil
+	}
+	expired := []string{}
+	for owner, lease := range p.leases {
+		if now.Sub(lease.start) >= p.timeout {
+			expired = append(expired, owner)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// Leases returns the ports held by each owner
+func (p *Pool) Leases() map[string][]int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	leases := make(map[string][]int, len(p.leases))
+	for owner, lease := range p.leases {
+		leases[owner] = lease.ports
+	}
+	return leases
+}
+
+// Leased reports whether port is held by an owner
+func (p *Pool) Leased(port int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, lease := range p.leases {
+		if slices.Contains(lease.ports, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// free must be called with p.mutex held
+func (p *Pool) free() []int {
+	leased := map[int]bool{}
+	for _, lease := range p.leases {
+		for _, port := range lease.ports {
+			leased[port] = true
+		}
+	}
+
+	free := []int{}
+	for _, port := range p.ports {
+		if !leased[port] && (p.healthy == nil || p.healthy(port)) {
+			free = append(free, port)
+		}
+	}
+	return free
+}
@@ -0,0 +1,152 @@
+package ports
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func newTestPool(t *testing.T, count int, timeout time.Duration) *Pool {
+	t.Helper()
+	pool, err := NewPool("5000", count, timeout)
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	return pool
+}
+
+func TestLeaseLowestFreePorts(t *testing.T) {
+	pool := newTestPool(t, 4, 0)
+	now := time.Now()
+
+	first, err := pool.Lease("a", 2, now)
+	if err != nil {
+		t.Fatalf("Lease a: %v", err)
+	}
+	if !slices.Equal(first, []int{5000, 5001}) {
+		t.Errorf("Lease a = %v, want [5000 5001]", first)
+	}
+	second, err := pool.Lease("b", 2, now)
+	if err != nil {
+		t.Fatalf("Lease b: %v", err)
+	}
+	if !slices.Equal(second, []int{5002, 5003}) {
+		t.Errorf("Lease b = %v, want [5002 5003]", second)
+	}
+	if free := pool.Free(); free != 0 {
+		t.Errorf("Free = %d, want 0", free)
+	}
+}
+
+func TestLeaseSameOwnerGetsSamePorts(t *testing.T) {
+	pool := newTestPool(t, 4, 0)
+	now := time.Now()
+
+	first, _ := pool.Lease("a", 2, now)
+	again, err := pool.Lease("a", 2, now)
+	if err != nil {
+		t.Fatalf("Lease again: %v", err)
+	}
+	if !slices.Equal(first, again) {
+		t.Errorf("Lease again = %v, want %v", again, first)
+	}
+	if free := pool.Free(); free != 2 {
+		t.Errorf("Free = %d, want 2", free)
+	}
+}
+
+func TestLeaseExhausted(t *testing.T) {
+	pool := newTestPool(t, 3, 0)
+	now := time.Now()
+
+	if _, err := pool.Lease("a", 2, now); err != nil {
+		t.Fatalf("Lease a: %v", err)
+	}
+	if _, err := pool.Lease("b", 2, now); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Lease b error = %v, want ErrExhausted", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	pool := newTestPool(t, 2, 0)
+	now := time.Now()
+
+	pool.Lease("a", 2, now)
+	if !pool.Leased(5001) {
+		t.Errorf("Leased(5001) = false, want true")
+	}
+	if !pool.Release("a") {
+		t.Errorf("Release a = false, want true")
+	}
+	if pool.Release("a") {
+		t.Errorf("second Release a = true, want false")
+	}
+	if pool.Leased(5001) {
+		t.Errorf("Leased(5001) after release = true, want false")
+	}
+	if _, err := pool.Lease("b", 2, now); err != nil {
+		t.Errorf("Lease b after release: %v", err)
+	}
+}
+
+func TestCheckSkipsUnhealthyPorts(t *testing.T) {
+	pool := newTestPool(t, 4, 0)
+	pool.Check(func(port int) bool {
+		return port != 5000
+	})
+
+	if healthy := pool.Healthy(); !slices.Equal(healthy, []int{5001, 5002, 5003}) {
+		t.Errorf("Healthy = %v, want [5001 5002 5003]", healthy)
+	}
+	leased, err := pool.Lease("a", 2, time.Now())
+	if err != nil {
+		t.Fatalf("Lease: %v", err)
+	}
+	if !slices.Equal(leased, []int{5001, 5002}) {
+		t.Errorf("Lease = %v, want [5001 5002]", leased)
+	}
+	if free := pool.Free(); free != 1 {
+		t.Errorf("Free = %d, want 1", free)
+	}
+}
+
+func TestExpiredKeepsLeasesUntilReleased(t *testing.T) {
+	pool := newTestPool(t, 4, time.Minute)
+	start := time.Now()
+
+	pool.Lease("old", 2, start)
+	pool.Lease("new", 2, start.Add(30*time.Second))
+
+	if expired := pool.Expired(start.Add(59 * time.Second)); len(expired) != 0 {
+		t.Errorf("Expired before the timeout = %v, want none", expired)
+	}
+	expired := pool.Expired(start.Add(time.Minute))
+	if !slices.Equal(expired, []string{"old"}) {
+		t.Fatalf("Expired = %v, want [old]", expired)
+	}
+	// The owner is told, the ports stay leased until it releases them
+	if free := pool.Free(); free != 0 {
+		t.Errorf("Free before release = %d, want 0", free)
+	}
+	pool.Release("old")
+	if free := pool.Free(); free != 2 {
+		t.Errorf("Free after release = %d, want 2", free)
+	}
+}
+
+func TestExpiredWithoutTimeout(t *testing.T) {
+	pool := newTestPool(t, 2, 0)
+	start := time.Now()
+
+	pool.Lease("a", 2, start)
+	if expired := pool.Expired(start.Add(24 * time.Hour)); expired != nil {
+		t.Errorf("Expired = %v, want nil", expired)
+	}
+}
+
+func TestNewPoolInvalidBasePort(t *testing.T) {
+	if _, err := NewPool("port", 2, 0); err == nil {
+		t.Errorf("NewPool with a non numeric base port succeeded")
+	}
+}
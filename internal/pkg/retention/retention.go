@@ -0,0 +1,80 @@
+// Package retention bounds the transactions a node keeps in memory, finished
+// transactions are evicted by age and count and unfinished ones expire after a
+// deadline
+package retention
+
+import (
+	"sort"
+	"time"
+	"wifi-trade-consensus/internal/pkg/config"
+)
+
+// SweepInterval is how often a node applies its retention policy
+const SweepInterval = time.Second
+
+// Options are embedded in the config of the provider and the consumer, every
+// limit is off when left at 0
+type Options struct {
+	MaxAge   int `mapstructure:"retention_max_age" json:"retention_max_age"`     // s a finished transaction is kept
+	MaxCount int `mapstructure:"retention_max_count" json:"retention_max_count"` // finished transactions kept
+	Timeout  int `mapstructure:"transaction_timeout" json:"transaction_timeout"` // s before an unfinished transaction expires
+}
+
+// Validate checks that no limit is negative
+func (opt Options) Validate() error {
+	problems := config.Problems{}
+	for _, limit := range []struct {
+		key string
+		val int
+	}{
+		{"retention_max_age", opt.MaxAge},
+		{"retention_max_count", opt.MaxCount},
+		{"transaction_timeout", opt.Timeout},
+	} {
+		if limit.val < 0 {
+			problems.Add("%s must be >= 0, got %d", limit.key, limit.val)
+		}
+	}
+	return problems.Err()
+}
+
+// Enabled reports whether any limit is set, there is nothing to sweep otherwise
+func (opt Options) Enabled() bool {
+	return opt.MaxAge > 0 || opt.MaxCount > 0 || opt.Timeout > 0
+}
+
+// Entry is what the policy needs to know about a transaction
+type Entry struct {
+	ID    string
+	Start int64 // unix ms
+	End   int64 // unix ms, 0 while unfinished
+}
+
+// Sweep returns the unfinished entries past the timeout and the finished
+// entries past the max age or over the max count, the oldest are evicted first
+func (opt Options) Sweep(entries []Entry, now time.Time) (expired []string, evicted []string) {
+	nowMS := now.UnixMilli()
+	finished := []Entry{}
+	for _, entry := range entries {
+		switch {
+		case entry.End == 0:
+			if opt.Timeout > 0 && nowMS-entry.Start > int64(opt.Timeout)*1000 {
+				expired = append(expired, entry.ID)
+			}
+		case opt.MaxAge > 0 && nowMS-entry.End > int64(opt.MaxAge)*1000:
+			evicted = append(evicted, entry.ID)
+		default:
+			finished = append(finished, entry)
+		}
+	}
+
+	if opt.MaxCount > 0 && len(finished) > opt.MaxCount {
+		sort.Slice(finished, func(i, j int) bool {
+			return finished[i].End < finished[j].End
+		})
+		for _, entry := range finished[:len(finished)-opt.MaxCount] {
+			evicted = append(evicted, entry.ID)
+		}
+	}
+	return expired, evicted
+}
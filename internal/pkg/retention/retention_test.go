@@ -0,0 +1,102 @@
+package retention
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSweep(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+	ago := func(seconds int64) int64 {
+		return now.UnixMilli() - seconds*1000
+	}
+
+	tests := []struct {
+		name        string
+		options     Options
+		entries     []Entry
+		wantExpired []string
+		wantEvicted []string
+	}{
+		{
+			name:    "disabled",
+			options: Options{},
+			entries: []Entry{
+				{ID: "running", Start: ago(900)},
+				{ID: "done", Start: ago(900), End: ago(800)},
+			},
+		},
+		{
+			name:    "timeout expires unfinished only",
+			options: Options{Timeout: 60},
+			entries: []Entry{
+				{ID: "stuck", Start: ago(61)},
+				{ID: "running", Start: ago(59)},
+				{ID: "done", Start: ago(600), End: ago(500)},
+			},
+			wantExpired: []string{"stuck"},
+		},
+		{
+			name:    "max age evicts finished only",
+			options: Options{MaxAge: 300},
+			entries: []Entry{
+				{ID: "old", Start: ago(400), End: ago(301)},
+				{ID: "recent", Start: ago(400), End: ago(299)},
+				{ID: "running", Start: ago(900)},
+			},
+			wantEvicted: []string{"old"},
+		},
+		{
+			name:    "max count evicts the oldest finished",
+			options: Options{MaxCount: 2},
+			entries: []Entry{
+				{ID: "second", Start: ago(50), End: ago(30)},
+				{ID: "first", Start: ago(50), End: ago(40)},
+				{ID: "running", Start: ago(50)},
+				{ID: "fourth", Start: ago(50), End: ago(10)},
+				{ID: "third", Start: ago(50), End: ago(20)},
+			},
+			wantEvicted: []string{"first", "second"},
+		},
+		{
+			name:    "max age and max count",
+			options: Options{MaxAge: 100, MaxCount: 1},
+			entries: []Entry{
+				{ID: "aged", Start: ago(300), End: ago(200)},
+				{ID: "older", Start: ago(90), End: ago(80)},
+				{ID: "newer", Start: ago(90), End: ago(70)},
+			},
+			wantEvicted: []string{"aged", "older"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expired, evicted := test.options.Sweep(test.entries, now)
+			slices.Sort(expired)
+			slices.Sort(evicted)
+			if !slices.Equal(expired, test.wantExpired) {
+				t.Errorf("expired = %v, want %v", expired, test.wantExpired)
+			}
+			if !slices.Equal(evicted, test.wantEvicted) {
+				t.Errorf("evicted = %v, want %v", evicted, test.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if (Options{}).Enabled() {
+		t.Errorf("Enabled without limits = true, want false")
+	}
+	if !(Options{MaxCount: 1}).Enabled() {
+		t.Errorf("Enabled with max count = false, want true")
+	}
+	if err := (Options{MaxAge: -1}).Validate(); err == nil {
+		t.Errorf("Validate accepted a negative max age")
+	}
+	if err := (Options{MaxAge: 1, MaxCount: 1, Timeout: 1}).Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+// Package shutdown holds the draining helpers shared by the nodes, a node
+// stops taking new transactions and waits for the ones in flight before it
+// cleans up
+package shutdown
+
+import (
+	"context"
+	"time"
+)
+
+// pollInterval is how often Drain checks the transactions in flight
+const pollInterval = 100 * time.Millisecond
+
+// LeaveTimeout bounds the dial of a LEAVE, a peer that is gone must not hold
+// up the shutdown
+const LeaveTimeout = time.Second
+
+// Drain waits until inFlight returns 0 or ctx is done, it returns what is
+// still in flight and ctx.Err() if the deadline was hit
+func Drain(ctx context.Context, inFlight func() int) (int, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		count := inFlight()
+		if count == 0 {
+			return 0, nil
+		}
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
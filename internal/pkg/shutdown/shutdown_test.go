@@ -0,0 +1,36 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainUntilNothingInFlight(t *testing.T) {
+	inFlight := atomic.Int32{}
+	inFlight.Store(3)
+	go func() {
+		for inFlight.Load() > 0 {
+			time.Sleep(50 * time.Millisecond)
+			inFlight.Add(-1)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	count, err := Drain(ctx, func() int { return int(inFlight.Load()) })
+	if count != 0 || err != nil {
+		t.Errorf("Drain = %d, %v, want 0, nil", count, err)
+	}
+}
+
+func TestDrainDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	count, err := Drain(ctx, func() int { return 2 })
+	if count != 2 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain = %d, %v, want 2, context.DeadlineExceeded", count, err)
+	}
+}
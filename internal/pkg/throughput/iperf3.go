@@ -0,0 +1,54 @@
+package throughput
+
+import (
+	"fmt"
+	"wifi-trade-consensus/internal/pkg/iperf3"
+)
+
+// iperf3Engine shells out to the iperf3 binary, one supervised server process
+// per port and one client process per transfer
+type iperf3Engine struct{}
+
+func (iperf3Engine) Name() string {
+	return EngineIperf3
+}
+
+func (iperf3Engine) StartServers(basePort string, serverCount int, options ServerOptions) ([]Server, error) {
+	supervised, err := iperf3.StartServers(basePort, serverCount, options.supervisor())
+	if err != nil {
+		return nil, err
+	}
+	servers := make([]Server, 0, len(supervised))
+	for _, server := range supervised {
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+func (iperf3Engine) StartStream(ip string, basePort string, serverCount int, transfer Transfer) (*Results, error) {
+	options := iperf3.StreamOptions{
+		Size:     transfer.Size.String(),
+		Duration: transfer.Duration,
+	}
+	if transfer.Bitrate > 0 {
+		options.Bitrate = transfer.Bitrate.String()
+	}
+	switch transfer.Protocol {
+	case "", TCP:
+	case UDP:
+		options.UDP = true
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", transfer.Protocol)
+	}
+	switch transfer.Direction {
+	case Forward:
+		options.Direction = iperf3.FORWARD
+	case Reverse:
+		options.Direction = iperf3.REVERSE
+	case Bidirectional:
+		options.Direction = iperf3.BIDIRECTIONAL
+	default:
+		return nil, fmt.Errorf("unknown direction %q", transfer.Direction)
+	}
+	return iperf3.StartTransfer(ip, basePort, serverCount, transfer.Title, options)
+}
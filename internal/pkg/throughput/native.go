@@ -0,0 +1,420 @@
+package throughput
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/iperf3"
+	"wifi-trade-consensus/internal/pkg/logging"
+)
+
+// The native engine speaks a small protocol over one TCP connection per
+// transfer. The client sends a JSON header line, then each side that sends
+// writes length prefixed frames and an empty frame once done. The server ends
+// with a JSON summary line of what it sent and received
+const (
+	frameSize   = 128 * 1024
+	dialTimeout = 5 * time.Second
+	idleTimeout = 30 * time.Second      // longest wait for the next frame
+	interval    = time.Second           // reporting interval, as iperf3's default
+	pace        = 10 * time.Millisecond // data a capped sender writes at once
+)
+
+var errUnsupported = errors.New("not supported by the native engine")
+
+// nativeEngine measures throughput in process, no binary is needed
+type nativeEngine struct{}
+
+type nativeServer struct {
+	port     int
+	listener net.Listener
+	mutex    sync.Mutex
+	conns    map[net.Conn]bool
+	stopped  bool
+}
+
+// nativeHeader opens a transfer
+type nativeHeader struct {
+	Title      string    `json:"title"`
+	Direction  Direction `json:"direction"`
+	Bytes      int64     `json:"bytes"`       // 0 when transferring for a duration
+	DurationMS int64     `json:"duration_ms"` // 0 when transferring a size
+	Bitrate    int64     `json:"bitrate"`     // bits per second each sender is paced at, 0 uncapped
+}
+
+// nativeSummary closes a transfer, seen from the server
+type nativeSummary struct {
+	Sent     iperf3.Sum `json:"sent"`
+	Received iperf3.Sum `json:"received"`
+	Error    string     `json:"error,omitempty"`
+}
+
+func (nativeEngine) Name() string {
+	return EngineNative
+}
+
+func (nativeEngine) StartServers(basePort string, serverCount int, _ ServerOptions) ([]Server, error) {
+	port, err := strconv.Atoi(basePort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert basePort to int: %w", err)
+	}
+
+	servers := []Server{}
+	for i := 0; i < serverCount; i++ {
+		l, err := net.Listen("tcp", ":"+fmt.Sprint(port+i))
+		if err != nil {
+			for _, server := range servers {
+				server.Stop()
+			}
+			return nil, fmt.Errorf("failed to start server: %w", err)
+		}
+		server := &nativeServer{port: port + i, listener: l, conns: map[net.Conn]bool{}}
+		go server.serve()
+		servers = append(servers, server)
+		slog.Info("native throughput server started", "port", port+i)
+	}
+	return servers, nil
+}
+
+func (nativeEngine) StartStream(ip string, basePort string, serverCount int, transfer Transfer) (*Results, error) {
+	header := nativeHeader{Title: transfer.Title, Direction: transfer.Direction, Bitrate: int64(transfer.Bitrate)}
+	switch transfer.Direction {
+	case Forward, Reverse, Bidirectional:
+	default:
+		return nil, fmt.Errorf("unknown direction %q", transfer.Direction)
+	}
+	if transfer.Protocol != "" && transfer.Protocol != TCP {
+		return nil, fmt.Errorf("%w: %s flows need the iperf3 engine", errUnsupported, transfer.Protocol)
+	}
+	if transfer.Duration > 0 {
+		header.DurationMS = transfer.Duration.Milliseconds()
+	} else {
+		if transfer.Size <= 0 {
+			return nil, fmt.Errorf("invalid size %s, want a size or a duration", transfer.Size)
+		}
+		header.Bytes = int64(transfer.Size)
+	}
+
+	port, err := strconv.Atoi(basePort)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert basePort to int: %w", err)
+	}
+	for i := 0; i < serverCount; i++ {
+		address := net.JoinHostPort(ip, fmt.Sprint(port+i))
+		results, err := runNativeClient(address, header)
+		if err != nil {
+			slog.Warn("failed to start stream", logging.KeyTransaction, transfer.Title, "port", port+i,
+				logging.Err(err))
+			continue
+		}
+		return results, nil
+	}
+	return nil, fmt.Errorf("failed to send stream, no port available")
+}
+
+func runNativeClient(address string, header nativeHeader) (*Results, error) {
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial server: %w", err)
+	}
+	defer conn.Close()
+
+	jsonHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+	if _, err := conn.Write(append(jsonHeader, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send header: %w", err)
+	}
+
+	// Send and receive at the same time, as a bidirectional transfer needs
+	reader := bufio.NewReader(conn)
+	sent, received := newMeter(), newMeter()
+	var sendErr, receiveErr error
+	wg := sync.WaitGroup{}
+	if header.Direction != Reverse {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendErr = sendFrames(conn, header, sent)
+		}()
+	}
+	if header.Direction != Forward {
+		receiveErr = receiveFrames(conn, reader, received)
+	}
+	wg.Wait()
+	if err := errors.Join(sendErr, receiveErr); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read summary: %w", err)
+	}
+	summary := nativeSummary{}
+	if err := json.Unmarshal(line, &summary); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal summary: %w", err)
+	}
+	if summary.Error != "" {
+		return nil, fmt.Errorf("server failed: %s", summary.Error)
+	}
+
+	// The intervals are the client's, as iperf3 reports them
+	results := &Results{}
+	results.Start.TestStart.Protocol = "TCP"
+	switch header.Direction {
+	case Forward:
+		results.End.SumSent = sent.sum(true)
+		results.End.SumReceived = summary.Received
+		results.Intervals = makeIntervals(sent.intervals, nil)
+	case Reverse:
+		results.Start.TestStart.Reverse = 1
+		results.End.SumSent = summary.Sent
+		results.End.SumReceived = received.sum(false)
+		results.Intervals = makeIntervals(received.intervals, nil)
+	case Bidirectional:
+		results.Start.TestStart.Bidir = 1
+		results.End.SumSent = sent.sum(true)
+		results.End.SumReceived = summary.Received
+		results.End.SumSentBidirReverse = summary.Sent
+		results.End.SumReceivedBidirReverse = received.sum(false)
+		results.Intervals = makeIntervals(sent.intervals, received.intervals)
+	}
+	slog.Debug("native stream results", logging.KeyTransaction, header.Title, "address", address,
+		"results", *results)
+	return results, nil
+}
+
+func (s *nativeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Error("failed to accept new connection", logging.Err(err))
+			continue
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+		go func(conn net.Conn) {
+			defer func() {
+				s.mutex.Lock()
+				delete(s.conns, conn)
+				s.mutex.Unlock()
+				conn.Close()
+			}()
+			if err := s.handle(conn); err != nil {
+				slog.Warn("native transfer failed", "remote", conn.RemoteAddr().String(), logging.Err(err))
+			}
+		}(conn)
+	}
+}
+
+func (s *nativeServer) handle(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	header := nativeHeader{}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return fmt.Errorf("failed to unmarshal header: %w", err)
+	}
+
+	sent, received := newMeter(), newMeter()
+	var sendErr, receiveErr error
+	wg := sync.WaitGroup{}
+	if header.Direction != Forward {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendErr = sendFrames(conn, header, sent)
+		}()
+	}
+	if header.Direction != Reverse {
+		receiveErr = receiveFrames(conn, reader, received)
+	}
+	wg.Wait()
+	summary := nativeSummary{Sent: sent.sum(false), Received: received.sum(false)}
+	err = errors.Join(sendErr, receiveErr)
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	jsonSummary, marshalErr := json.Marshal(summary)
+	if marshalErr != nil {
+		return fmt.Errorf("failed to marshal summary: %w", marshalErr)
+	}
+	conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+	if _, writeErr := conn.Write(append(jsonSummary, '\n')); writeErr != nil {
+		return fmt.Errorf("failed to send summary: %w", writeErr)
+	}
+	slog.Debug("native transfer done", logging.KeyTransaction, header.Title, "summary", summary)
+	return err
+}
+
+// Port is the port the server listens on
+func (s *nativeServer) Port() int {
+	return s.port
+}
+
+// Healthy reports whether the listener is open, it only closes on Stop
+func (s *nativeServer) Healthy() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return !s.stopped
+}
+
+// Stop closes the listener and the transfers in progress
+func (s *nativeServer) Stop() error {
+	err := s.listener.Close()
+	s.mutex.Lock()
+	s.stopped = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+	return err
+}
+
+// sendFrames writes frames until the size or duration of header is reached,
+// then the empty frame. A capped sender writes a frame per pace and waits
+// until the bytes sent are due at the bitrate
+func sendFrames(conn net.Conn, header nativeHeader, meter *meter) error {
+	frame := make([]byte, 4+frameSize)
+	duration := time.Duration(header.DurationMS) * time.Millisecond
+	maxSize := int64(frameSize)
+	if header.Bitrate > 0 {
+		maxSize = max(1, min(maxSize, header.Bitrate/8*int64(pace)/int64(time.Second)))
+	}
+	for {
+		size := maxSize
+		if header.DurationMS > 0 {
+			if time.Since(meter.start) >= duration {
+				break
+			}
+		} else {
+			if meter.total >= header.Bytes {
+				break
+			}
+			size = min(size, header.Bytes-meter.total)
+		}
+
+		binary.BigEndian.PutUint32(frame, uint32(size))
+		conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+		if _, err := conn.Write(frame[:4+size]); err != nil {
+			return fmt.Errorf("failed to send frame: %w", err)
+		}
+		meter.add(size)
+
+		if header.Bitrate > 0 {
+			due := time.Duration(float64(meter.total*8) / float64(header.Bitrate) * float64(time.Second))
+			time.Sleep(time.Until(meter.start.Add(due)))
+		}
+	}
+
+	binary.BigEndian.PutUint32(frame, 0)
+	conn.SetWriteDeadline(time.Now().Add(idleTimeout))
+	if _, err := conn.Write(frame[:4]); err != nil {
+		return fmt.Errorf("failed to send end frame: %w", err)
+	}
+	return nil
+}
+
+// receiveFrames reads frames until the empty frame
+func receiveFrames(conn net.Conn, reader *bufio.Reader, meter *meter) error {
+	prefix := make([]byte, 4)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if _, err := io.ReadFull(reader, prefix); err != nil {
+			return fmt.Errorf("failed to receive frame: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(prefix))
+		if size == 0 {
+			return nil
+		}
+		if size > frameSize {
+			return fmt.Errorf("frame of %d bytes is over %d", size, frameSize)
+		}
+		if _, err := io.CopyN(io.Discard, reader, size); err != nil {
+			return fmt.Errorf("failed to receive frame: %w", err)
+		}
+		meter.add(size)
+	}
+}
+
+// meter counts the bytes of one direction and cuts them into intervals
+type meter struct {
+	start         time.Time
+	total         int64
+	intervalStart time.Time
+	intervalBytes int64
+	intervals     []iperf3.Sum
+}
+
+func newMeter() *meter {
+	now := time.Now()
+	return &meter{start: now, intervalStart: now}
+}
+
+func (m *meter) add(bytes int64) {
+	m.total += bytes
+	m.intervalBytes += bytes
+	if time.Since(m.intervalStart) >= interval {
+		m.cut()
+	}
+}
+
+// cut closes the current interval
+func (m *meter) cut() {
+	now := time.Now()
+	m.intervals = append(m.intervals, newSum(m.intervalStart.Sub(m.start), now.Sub(m.start), m.intervalBytes))
+	m.intervalStart = now
+	m.intervalBytes = 0
+}
+
+// sum closes the last interval and totals the meter, sender tells if the
+// local host sent
+func (m *meter) sum(sender bool) iperf3.Sum {
+	if m.intervalBytes > 0 {
+		m.cut()
+	}
+	sum := newSum(0, time.Since(m.start), m.total)
+	sum.Sender = sender
+	return sum
+}
+
+func newSum(start time.Duration, end time.Duration, bytes int64) iperf3.Sum {
+	sum := iperf3.Sum{Start: start.Seconds(), End: end.Seconds(), Seconds: (end - start).Seconds(), Bytes: bytes}
+	if sum.Seconds > 0 {
+		sum.BitsPerSecond = float64(bytes*8) / sum.Seconds
+	}
+	return sum
+}
+
+// makeIntervals pairs the intervals of both halves of a transfer
+func makeIntervals(sums []iperf3.Sum, reverseSums []iperf3.Sum) []iperf3.Interval {
+	intervals := make([]iperf3.Interval, max(len(sums), len(reverseSums)))
+	for i := range intervals {
+		if i < len(sums) {
+			intervals[i].Sum = sums[i]
+		}
+		if i < len(reverseSums) {
+			intervals[i].SumBidirReverse = reverseSums[i]
+		}
+	}
+	return intervals
+}
@@ -0,0 +1,139 @@
+// Package throughput measures the flow between a consumer and the winning
+// provider, either with iperf3 or with the native Go engine. Both engines
+// report iperf3.Results so the nodes read them the same way
+package throughput
+
+import (
+	"fmt"
+	"time"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/iperf3"
+	"wifi-trade-consensus/internal/pkg/units"
+)
+
+// Engine names, selected with throughput_engine in the node configs
+const (
+	EngineIperf3 = "iperf3" // default
+	EngineNative = "native"
+)
+
+// Direction of a transfer, seen from the client
+type Direction string
+
+const (
+	Forward       Direction = "forward" // client to server, the consumer uploads
+	Reverse       Direction = "reverse" // server to client, the consumer downloads
+	Bidirectional Direction = "bidirectional"
+)
+
+// Protocol of a transfer
+type Protocol string
+
+const (
+	TCP Protocol = "tcp" // default
+	UDP Protocol = "udp" // sent at Bitrate, reports jitter and loss
+)
+
+// Results mirror the iperf3 JSON output, the native engine fills the same
+// fields
+type Results = iperf3.Results
+
+// Flow summarizes one direction of Results
+type Flow = iperf3.Flow
+
+// Transfer describes what a client sends or receives
+type Transfer struct {
+	Title     string        // labels the transfer, the transaction id
+	Size      units.Bytes   // bytes to transfer
+	Duration  time.Duration // transfer for this long instead of Size, when set
+	Direction Direction
+	Protocol  Protocol      // TCP if empty
+	Bitrate   units.Bitrate // caps a TCP transfer, the rate of a UDP one, 0 uncapped
+}
+
+// Defaults of the ServerOptions left at 0
+const (
+	defaultProbeInterval = 5 // s
+	defaultProbeFailures = 3
+	defaultMaxBackoff    = 30 // s
+)
+
+// ServerOptions are embedded in the config of the provider and the consumer,
+// they say how the iperf3 server processes are supervised. Native servers run
+// in process and need none of them
+type ServerOptions struct {
+	LogDir        string `mapstructure:"server_log_dir" json:"server_log_dir"`               // per port iperf3 server logs, discarded if empty
+	ProbeInterval int    `mapstructure:"server_probe_interval" json:"server_probe_interval"` // s between health probes, 5 if 0
+	ProbeFailures int    `mapstructure:"server_probe_failures" json:"server_probe_failures"` // failed probes before a server is restarted, 3 if 0
+	MaxBackoff    int    `mapstructure:"server_max_backoff" json:"server_max_backoff"`       // s, longest wait before a crashed server is restarted, 30 if 0
+	// InUse reports the ports a flow holds, they aren't probed meanwhile
+	InUse func(port int) bool `mapstructure:"-" json:"-"`
+}
+
+// Validate checks that no option is negative
+func (opt ServerOptions) Validate() error {
+	problems := config.Problems{}
+	for _, option := range []struct {
+		key string
+		val int
+	}{
+		{"server_probe_interval", opt.ProbeInterval},
+		{"server_probe_failures", opt.ProbeFailures},
+		{"server_max_backoff", opt.MaxBackoff},
+	} {
+		if option.val < 0 {
+			problems.Add("%s must be >= 0, got %d", option.key, option.val)
+		}
+	}
+	return problems.Err()
+}
+
+func (opt ServerOptions) supervisor() iperf3.SupervisorOptions {
+	options := iperf3.SupervisorOptions{
+		LogDir:        opt.LogDir,
+		ProbeInterval: time.Duration(opt.ProbeInterval) * time.Second,
+		ProbeFailures: opt.ProbeFailures,
+		MaxBackoff:    time.Duration(opt.MaxBackoff) * time.Second,
+		InUse:         opt.InUse,
+	}
+	if options.ProbeInterval == 0 {
+		options.ProbeInterval = defaultProbeInterval * time.Second
+	}
+	if options.ProbeFailures == 0 {
+		options.ProbeFailures = defaultProbeFailures
+	}
+	if options.MaxBackoff == 0 {
+		options.MaxBackoff = defaultMaxBackoff * time.Second
+	}
+	return options
+}
+
+// Server is a measurement server started by an engine
+type Server interface {
+	Port() int
+	// Healthy reports whether the server accepts transfers
+	Healthy() bool
+	Stop() error
+}
+
+// Engine starts the measurement servers of a node and runs the transfers of
+// a flow against the servers of a peer
+type Engine interface {
+	Name() string
+	// StartServers listens on serverCount consecutive ports from basePort
+	StartServers(basePort string, serverCount int, options ServerOptions) ([]Server, error)
+	// StartStream runs the transfer against the first of the serverCount
+	// servers of ip that accepts it
+	StartStream(ip string, basePort string, serverCount int, transfer Transfer) (*Results, error)
+}
+
+// New returns the engine called name, iperf3 if name is empty
+func New(name string) (Engine, error) {
+	switch name {
+	case "", EngineIperf3:
+		return iperf3Engine{}, nil
+	case EngineNative:
+		return nativeEngine{}, nil
+	}
+	return nil, fmt.Errorf("unknown throughput_engine %q, want %s or %s", name, EngineIperf3, EngineNative)
+}
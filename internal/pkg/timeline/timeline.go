@@ -0,0 +1,105 @@
+package timeline
+
+import (
+	"sync"
+	"time"
+)
+
+// Phases of a transaction, marked by the consumer and providers
+const (
+	BUY_SENT                 = "buy_sent"
+	BUY_RECEIVED             = "buy_received"
+	REQUEST_VOTE_SENT        = "request_vote_sent"
+	REQUEST_VOTE_RECEIVED    = "request_vote_received"
+	REPLY_VOTE_SENT          = "reply_vote_sent"
+	REPLY_VOTE_RECEIVED      = "reply_vote_received"
+	INFORM_VOTE_SENT         = "inform_vote_sent"
+	INFORM_VOTE_RECEIVED     = "inform_vote_received"
+	WINNER_DECIDED           = "winner_decided"
+	START_FLOW_SENT          = "start_flow_sent"
+	START_FLOW_RECEIVED      = "start_flow_received"
+	FLOW_START               = "flow_start"
+	FLOW_END                 = "flow_end"
+	TRANSACTION_END_SENT     = "transaction_end_sent"
+	TRANSACTION_END_RECEIVED = "transaction_end_received"
+)
+
+// Mark is the time a phase was reached, peer is the other node involved, if
+// any
+type Mark struct {
+	Phase string `json:"phase"`
+	Peer  string `json:"peer,omitempty"`
+	Time  int64  `json:"time_us"` // unix microseconds
+}
+
+// Timeline records the phases of a single transaction, it is safe for
+// concurrent use
+type Timeline struct {
+	mutex sync.Mutex
+	marks []Mark
+}
+
+func New() *Timeline {
+	return &Timeline{}
+}
+
+// Mark records that phase was reached now
+func (t *Timeline) Mark(phase string, peer string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.marks = append(t.marks, Mark{
+		Phase: phase,
+		Peer:  peer,
+		Time:  time.Now().UnixMicro(),
+	})
+}
+
+// Marks returns a copy of every mark in the order they were recorded
+func (t *Timeline) Marks() []Mark {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]Mark{}, t.marks...)
+}
+
+// First returns the earliest time phase was reached, ok is false if it never
+// was
+func (t *Timeline) First(phase string) (int64, bool) {
+	first, found := int64(0), false
+	for _, mark := range t.Marks() {
+		if mark.Phase == phase && (!found || mark.Time < first) {
+			first, found = mark.Time, true
+		}
+	}
+	return first, found
+}
+
+// Last returns the latest time phase was reached, ok is false if it never was
+func (t *Timeline) Last(phase string) (int64, bool) {
+	last, found := int64(0), false
+	for _, mark := range t.Marks() {
+		if mark.Phase == phase && (!found || mark.Time > last) {
+			last, found = mark.Time, true
+		}
+	}
+	return last, found
+}
+
+// Between returns the milliseconds from the first from mark to the last to
+// mark, or 0 if either phase is missing
+func (t *Timeline) Between(from string, to string) float64 {
+	start, ok := t.First(from)
+	if !ok {
+		return 0
+	}
+	end, ok := t.Last(to)
+	if !ok || end < start {
+		return 0
+	}
+	return float64(end-start) / 1000
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import "fmt"
+
+// The subset of the OTLP/JSON trace export request written to trace files,
+// see opentelemetry-proto/collector/trace/v1/trace_service.proto
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"` // 64 bit integers are strings in OTLP/JSON
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            status      `json:"status"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value value  `json:"value"`
+}
+
+type value struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newValue(val any) value {
+	switch val := val.(type) {
+	case string:
+		return value{StringValue: &val}
+	case bool:
+		return value{BoolValue: &val}
+	case int:
+		intValue := fmt.Sprint(val)
+		return value{IntValue: &intValue}
+	case int64:
+		intValue := fmt.Sprint(val)
+		return value{IntValue: &intValue}
+	case float64:
+		return value{DoubleValue: &val}
+	default:
+		stringValue := fmt.Sprint(val)
+		return value{StringValue: &stringValue}
+	}
+}
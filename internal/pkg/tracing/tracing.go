@@ -0,0 +1,219 @@
+// Package tracing records the spans of a transaction across nodes and exports
+// them to a local file in the OTLP JSON format
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+
+	"github.com/google/uuid"
+)
+
+// Span kinds, as numbered by OTLP
+const (
+	KindInternal = 1
+	KindServer   = 2 // handling a received payload
+	KindClient   = 3 // sending a payload
+)
+
+// OTLP status codes
+const (
+	statusOK    = 1
+	statusError = 2
+)
+
+const scopeName = "wifi-trade-consensus"
+
+// Tracer records the spans of a node, it is safe for concurrent use. A nil
+// Tracer records nothing
+type Tracer struct {
+	service string
+	mutex   sync.Mutex
+	file    *os.File
+}
+
+// New returns a tracer appending the spans of service to the file at path,
+// one OTLP export request per line
+func New(service string, path string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &Tracer{
+		service: service,
+		file:    file,
+	}, nil
+}
+
+// Close closes the trace file, spans ended afterwards are dropped
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// Span is a timed operation of a transaction, a nil Span records nothing
+type Span struct {
+	tracer     *Tracer
+	name       string
+	kind       int
+	traceID    string
+	spanID     string
+	parentID   string
+	start      time.Time
+	mutex      sync.Mutex
+	attributes []attribute
+	err        error
+	ended      bool
+}
+
+// Start begins a span of the transaction. parent is the traceparent of the
+// span that caused this one, received in a payload or taken from a local
+// span, and is empty for a root span. The trace id falls back to the
+// transaction id, so every span of a transaction lands in the same trace even
+// when a payload carried no context
+func (t *Tracer) Start(name string, kind int, transactionID uuid.UUID, parent string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	traceID, parentID, ok := parseTraceparent(parent)
+	if !ok {
+		traceID = hex.EncodeToString(transactionID[:])
+		parentID = ""
+	}
+	return &Span{
+		tracer:   t,
+		name:     name,
+		kind:     kind,
+		traceID:  traceID,
+		spanID:   newSpanID(),
+		parentID: parentID,
+		start:    time.Now(),
+	}
+}
+
+// Traceparent is the W3C trace context of the span, sent in payload.Meta so
+// the receiver's spans become its children
+func (s *Span) Traceparent() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("00-%s-%s-01", s.traceID, s.spanID)
+}
+
+// SetAttribute records a string, bool, integer or float attribute, other
+// values are formatted as strings
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.attributes = append(s.attributes, attribute{Key: key, Value: newValue(value)})
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+// End exports the span, only the first call has an effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	exported := span{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parentID,
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: fmt.Sprint(s.start.UnixNano()),
+		EndTimeUnixNano:   fmt.Sprint(end.UnixNano()),
+		Attributes:        s.attributes,
+		Status:            status{Code: statusOK},
+	}
+	if s.err != nil {
+		exported.Status = status{Code: statusError, Message: s.err.Error()}
+	}
+	s.mutex.Unlock()
+
+	s.tracer.export(exported)
+}
+
+func (t *Tracer) export(exported span) {
+	request := exportRequest{
+		ResourceSpans: []resourceSpans{{
+			Resource: resource{
+				Attributes: []attribute{{Key: "service.name", Value: newValue(t.service)}},
+			},
+			ScopeSpans: []scopeSpans{{
+				Scope: scope{Name: scopeName},
+				Spans: []span{exported},
+			}},
+		}},
+	}
+	line, err := json.Marshal(request)
+	if err != nil {
+		slog.Error("failed to marshal span", "span", exported.Name, logging.Err(err))
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.file == nil {
+		return
+	}
+	if _, err := t.file.Write(append(line, '\n')); err != nil {
+		slog.Error("failed to write span", "span", exported.Name, logging.Err(err))
+	}
+}
+
+// parseTraceparent returns the trace and span ids of a version 00 W3C
+// traceparent
+func parseTraceparent(traceparent string) (string, string, bool) {
+	fields := strings.Split(traceparent, "-")
+	if len(fields) != 4 || fields[0] != "00" || len(fields[1]) != 32 || len(fields[2]) != 16 {
+		return "", "", false
+	}
+	if _, err := hex.DecodeString(fields[1] + fields[2]); err != nil {
+		return "", "", false
+	}
+	return fields[1], fields[2], true
+}
+
+func newSpanID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
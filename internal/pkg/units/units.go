@@ -0,0 +1,143 @@
+// Package units reads and writes the byte quantities and bitrates of flows the
+// way iperf3 takes them, so a flow is checked once where it is defined rather
+// than wherever it is run
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Bytes is a byte quantity. K, M, G and T are powers of 1024, as for iperf3 -n
+type Bytes int64
+
+// Bitrate is in bits per second. K, M, G and T are powers of 1000, as for
+// iperf3 -b
+type Bitrate int64
+
+const (
+	megabyte       = 1 << 20 // the trigger's flow sizes
+	speedByteScale = 1e6     // the uplink and downlink speeds are in 10^6 bytes per second
+)
+
+// ParseBytes reads a size such as 512M, 2G, 1.5K or a plain number of bytes
+func ParseBytes(size string) (Bytes, error) {
+	value, err := parse(size, 1024)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return Bytes(value), nil
+}
+
+// Megabytes converts a size in megabytes, powers of 1024 as the suffix M
+func Megabytes(megabytes float64) Bytes {
+	return Bytes(megabytes * megabyte)
+}
+
+// Megabytes is b in megabytes
+func (b Bytes) Megabytes() float64 {
+	return float64(b) / megabyte
+}
+
+// String writes b exactly, with the largest suffix that divides it
+func (b Bytes) String() string {
+	return format(int64(b), 1024)
+}
+
+func (b Bytes) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText reads what ParseBytes does, empty text is 0
+func (b *Bytes) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*b = 0
+		return nil
+	}
+	size, err := ParseBytes(string(text))
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// ParseBitrate reads a bitrate such as 10M, 1.5G or a plain number of bits
+// per second
+func ParseBitrate(bitrate string) (Bitrate, error) {
+	value, err := parse(bitrate, 1000)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bitrate %q", bitrate)
+	}
+	return Bitrate(value), nil
+}
+
+// Speed converts an uplink or downlink speed, in 10^6 bytes per second as the
+// QoS requirements give them
+func Speed(megabytesPerSecond float64) Bitrate {
+	return Bitrate(megabytesPerSecond * speedByteScale * 8)
+}
+
+// String writes r exactly, with the largest suffix that divides it
+func (r Bitrate) String() string {
+	return format(int64(r), 1000)
+}
+
+func (r Bitrate) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalText reads what ParseBitrate does, empty text is 0
+func (r *Bitrate) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*r = 0
+		return nil
+	}
+	bitrate, err := ParseBitrate(string(text))
+	if err != nil {
+		return err
+	}
+	*r = bitrate
+	return nil
+}
+
+var suffixes = []string{"", "K", "M", "G", "T"}
+
+func parse(text string, base float64) (int64, error) {
+	number := strings.TrimSpace(text)
+	multiplier := 1.0
+	if number != "" {
+		suffix := strings.ToUpper(number[len(number)-1:])
+		for idx := 1; idx < len(suffixes); idx++ {
+			if suffix == suffixes[idx] {
+				number = number[:len(number)-1]
+				multiplier = math.Pow(base, float64(idx))
+				break
+			}
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return 0, fmt.Errorf("not a non-negative quantity")
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which int64 can't hold
+	if value*multiplier >= math.MaxInt64 {
+		return 0, fmt.Errorf("more than %d", int64(math.MaxInt64))
+	}
+	return int64(value * multiplier), nil
+}
+
+func format(value int64, base int64) string {
+	if value == 0 {
+		return "0"
+	}
+	idx, unit := 0, int64(1)
+	for idx < len(suffixes)-1 && value%(unit*base) == 0 {
+		idx++
+		unit *= base
+	}
+	return strconv.FormatInt(value/unit, 10) + suffixes[idx]
+}
@@ -0,0 +1,111 @@
+package units
+
+import (
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    Bytes
+		wantErr bool
+	}{
+		{size: "0", want: 0},
+		{size: "1000", want: 1000},
+		{size: "1K", want: 1024},
+		{size: "1.5k", want: 1536},
+		{size: "512M", want: 512 << 20},
+		{size: "2G", want: 2 << 30},
+		{size: "1T", want: 1 << 40},
+		{size: " 8M ", want: 8 << 20},
+		{size: "", wantErr: true},
+		{size: "M", wantErr: true},
+		{size: "-1", wantErr: true},
+		{size: "10X", wantErr: true},
+		{size: "inf", wantErr: true},
+		{size: "+Inf", wantErr: true},
+		{size: "NaN", wantErr: true},
+		{size: "9e18", want: 9e18},
+		{size: "9223372036854775807", wantErr: true}, // rounds up past the int64 range
+		{size: "1e30K", wantErr: true},
+		{size: "8388608T", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseBytes(test.size)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseBytes(%q) = %d, want an error", test.size, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBytes(%q): %v", test.size, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseBytes(%q) = %d, want %d", test.size, got, test.want)
+		}
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	tests := []struct {
+		bitrate string
+		want    Bitrate
+		wantErr bool
+	}{
+		{bitrate: "0", want: 0},
+		{bitrate: "1K", want: 1000},
+		{bitrate: "10M", want: 10_000_000},
+		{bitrate: "1.5G", want: 1_500_000_000},
+		{bitrate: "", wantErr: true},
+		{bitrate: "-10M", wantErr: true},
+		{bitrate: "inf", wantErr: true},
+		{bitrate: "NaNM", wantErr: true},
+		{bitrate: "1e19", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseBitrate(test.bitrate)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseBitrate(%q) = %d, want an error", test.bitrate, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseBitrate(%q): %v", test.bitrate, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseBitrate(%q) = %d, want %d", test.bitrate, got, test.want)
+		}
+	}
+}
+
+func TestStringRoundTrips(t *testing.T) {
+	for _, size := range []Bytes{0, 1, 1023, 1024, 1536, 512 << 20, 3 << 40} {
+		parsed, err := ParseBytes(size.String())
+		if err != nil || parsed != size {
+			t.Errorf("ParseBytes(%q) = %d, %v, want %d", size.String(), parsed, err, size)
+		}
+	}
+	for _, bitrate := range []Bitrate{0, 999, 1000, 40_000_000, 2_500_000_000} {
+		parsed, err := ParseBitrate(bitrate.String())
+		if err != nil || parsed != bitrate {
+			t.Errorf("ParseBitrate(%q) = %d, %v, want %d", bitrate.String(), parsed, err, bitrate)
+		}
+	}
+}
+
+func TestUnmarshalTextEmptyIsZero(t *testing.T) {
+	size := Bytes(5)
+	if err := size.UnmarshalText(nil); err != nil || size != 0 {
+		t.Errorf("UnmarshalText(empty) = %d, %v, want 0", size, err)
+	}
+	bitrate := Bitrate(5)
+	if err := bitrate.UnmarshalText([]byte("")); err != nil || bitrate != 0 {
+		t.Errorf("UnmarshalText(empty) = %d, %v, want 0", bitrate, err)
+	}
+}
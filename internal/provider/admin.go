@@ -0,0 +1,422 @@
+package provider
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"wifi-trade-consensus/internal/pkg/logging"
+)
+
+const (
+	adminDefaultLimit = 50
+	adminMaxLimit     = 500
+)
+
+var (
+	errAdminWritesDisabled = errors.New("write endpoints are disabled, admin_token is not set")
+	errAdminTokenInvalid   = errors.New("missing or invalid admin token")
+)
+
+type providerView struct {
+	ID                          string  `json:"id"`
+	Address                     string  `json:"address"`
+	Behaviour                   string  `json:"behaviour"`
+	Price                       float64 `json:"price"`
+	UplinkSpeed                 float64 `json:"uplink_speed"`
+	DownlinkSpeed               float64 `json:"downlink_speed"`
+	Params                      params  `json:"params"`
+	Iperf3BaseServerPort        string  `json:"iperf3_base_server_port"`
+	Iperf3ServerCount           int     `json:"iperf3_server_count"`
+	DefaultPeerUplinkSpeed      float64 `json:"default_peer_uplink_speed"`
+	DefaultPeerDownlinkSpeed    float64 `json:"default_peer_downlink_speed"`
+	DefaultPeerLastPrice        float64 `json:"default_peer_last_price"`
+	DefaultPeerConsumerFeedback float64 `json:"default_peer_consumer_feedback"`
+	MetricsAddress              string  `json:"metrics_address"`
+	AdminAddress                string  `json:"admin_address"`
+	Peers                       int     `json:"peers"`
+	Transactions                int     `json:"transactions"`
+	ActiveFlows                 int     `json:"active_flows"`
+	FreePorts                   int     `json:"free_ports"`
+	HealthyPorts                []int   `json:"healthy_ports"`
+}
+
+type flowView struct {
+	TransactionID string  `json:"transaction_id"`
+	ConsumerID    string  `json:"consumer_id"`
+	Winner        string  `json:"winner"`
+	Serving       bool    `json:"serving"` // this provider is the winner
+	FlowStartTime int64   `json:"flow_start_time"`
+	Duration      float64 `json:"duration"` // ms so far
+}
+
+// NewAdminServer serves the admin API at admin_address in the background,
+// nothing is served when it isn't set. Write endpoints require the
+// admin_token as a bearer token
+func (p *provider) NewAdminServer() error {
+	if p.adminAddress == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", p.adminAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen admin address: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/provider", p.handleAdminProvider)
+	mux.HandleFunc("/api/v1/peers", p.handleAdminPeers)
+	mux.HandleFunc("/api/v1/transactions", p.handleAdminTransactions)
+	mux.HandleFunc("/api/v1/transactions/", p.handleAdminTransaction)
+	mux.HandleFunc("/api/v1/flows", p.handleAdminFlows)
+	mux.HandleFunc("/api/v1/price", p.handleAdminPrice)
+	mux.HandleFunc("/api/v1/params", p.handleAdminParams)
+	mux.HandleFunc("/api/v1/behaviour", p.handleAdminBehaviour)
+
+	p.adminServer = &http.Server{Handler: mux}
+	go func() {
+		if err := p.adminServer.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.log.Error("admin server stopped", logging.Err(err))
+		}
+	}()
+	p.log.Info("serving admin api", "address", p.adminAddress)
+	if p.adminToken == "" {
+		p.log.Warn(errAdminWritesDisabled.Error())
+	}
+	return nil
+}
+
+// GET /api/v1/provider
+func (p *provider) handleAdminProvider(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	p.mutex.Lock()
+	view := providerView{
+		ID:                          p.id,
+		Address:                     p.address,
+		Behaviour:                   p.behaviour(),
+		Price:                       p.price,
+		UplinkSpeed:                 p.uplinkSpeed,
+		DownlinkSpeed:               p.downlinkSpeed,
+		Params:                      p.params,
+		Iperf3BaseServerPort:        p.iperf3BaseServerPort,
+		Iperf3ServerCount:           p.iperf3ServerCount,
+		DefaultPeerUplinkSpeed:      p.defaultPeerUplinkSpeed,
+		DefaultPeerDownlinkSpeed:    p.defaultPeerDownlinkSpeed,
+		DefaultPeerLastPrice:        p.defaultPeerLastPrice,
+		DefaultPeerConsumerFeedback: p.defaultPeerConsumerFeedback,
+		MetricsAddress:              p.metricsAddress,
+		AdminAddress:                p.adminAddress,
+		Peers:                       len(p.peerScoreMatrix),
+		Transactions:                len(p.transactions),
+		ActiveFlows:                 p.activeFlowCount,
+		FreePorts:                   p.ports.Free(),
+		HealthyPorts:                p.ports.Healthy(),
+	}
+	p.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, view)
+}
+
+// GET /api/v1/peers
+func (p *provider) handleAdminPeers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	p.mutex.Lock()
+	views := p.peerScoreViews()
+	p.mutex.Unlock()
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+// GET /api/v1/transactions?state=won,lost&offset=0&limit=50, newest first
+func (p *provider) handleAdminTransactions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	offset, err := queryInt(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid offset: %s", query.Get("offset")))
+		return
+	}
+	limit, err := queryInt(query.Get("limit"), adminDefaultLimit)
+	if err != nil || limit < 1 || limit > adminMaxLimit {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit, want 1 to %d: %s", adminMaxLimit,
+			query.Get("limit")))
+		return
+	}
+	states := map[string]bool{}
+	if query.Get("state") != "" {
+		for _, state := range strings.Split(query.Get("state"), ",") {
+			switch state {
+			case transactionVoting, transactionVoted, transactionFlowing, transactionWon, transactionLost,
+				transactionRefused:
+				states[state] = true
+			default:
+				writeError(w, http.StatusBadRequest, fmt.Errorf("unknown state: %s", state))
+				return
+			}
+		}
+	}
+
+	p.mutex.Lock()
+	views := []transactionView{}
+	for _, transaction := range p.transactions {
+		if len(states) > 0 && !states[p.transactionState(transaction)] {
+			continue
+		}
+		views = append(views, p.transactionView(transaction))
+	}
+	p.mutex.Unlock()
+
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].TransactionTime != views[j].TransactionTime {
+			return views[i].TransactionTime > views[j].TransactionTime
+		}
+		return views[i].TransactionID < views[j].TransactionID
+	})
+	total := len(views)
+	// offset+limit may overflow, limit is only compared to what is left
+	start := min(offset, total)
+	views = views[start : start+min(limit, total-start)]
+
+	writeJSON(w, http.StatusOK, struct {
+		Total        int               `json:"total"`
+		Offset       int               `json:"offset"`
+		Limit        int               `json:"limit"`
+		Transactions []transactionView `json:"transactions"`
+	}{
+		Total:        total,
+		Offset:       offset,
+		Limit:        limit,
+		Transactions: views,
+	})
+}
+
+// GET /api/v1/transactions/{transaction id}
+func (p *provider) handleAdminTransaction(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	transactionID := strings.TrimPrefix(r.URL.Path, "/api/v1/transactions/")
+	p.mutex.Lock()
+	transaction, exists := p.transactions[transactionID]
+	var view transactionView
+	if exists {
+		view = p.transactionView(transaction)
+	}
+	p.mutex.Unlock()
+
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Errorf("transaction doesn't exist: %s", transactionID))
+		return
+	}
+	writeJSON(w, http.StatusOK, view)
+}
+
+// GET /api/v1/flows, the flows started and not ended yet
+func (p *provider) handleAdminFlows(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	p.mutex.Lock()
+	activeFlowCount := p.activeFlowCount
+	flows := []flowView{}
+	for _, transaction := range p.transactions {
+		if p.transactionState(transaction) != transactionFlowing {
+			continue
+		}
+		flows = append(flows, flowView{
+			TransactionID: transaction.transactionID.String(),
+			ConsumerID:    transaction.consumerID,
+			Winner:        transaction.winner.ProviderID,
+			Serving:       transaction.winner.ProviderID == p.id,
+			FlowStartTime: transaction.flowStartTime,
+			Duration:      float64(now - transaction.flowStartTime),
+		})
+	}
+	p.mutex.Unlock()
+
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].FlowStartTime < flows[j].FlowStartTime
+	})
+	writeJSON(w, http.StatusOK, struct {
+		ActiveFlowCount int        `json:"active_flow_count"` // flows served by this provider
+		Flows           []flowView `json:"flows"`
+	}{
+		ActiveFlowCount: activeFlowCount,
+		Flows:           flows,
+	})
+}
+
+// GET /api/v1/price, PUT {"price": 0.5} or {"price_multiplier": 1.2}
+func (p *provider) handleAdminPrice(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		update := struct {
+			Price           *float64 `json:"price"`
+			PriceMultiplier *float64 `json:"price_multiplier"`
+		}{}
+		if !p.authorized(w, r) || !decodeBody(w, r, &update) {
+			return
+		}
+		if update.Price == nil && update.PriceMultiplier == nil {
+			writeError(w, http.StatusBadRequest, errors.New("want price or price_multiplier"))
+			return
+		}
+		if !p.adminUpdate(w, updateProviderPayload{Price: update.Price, PriceMultiplier: update.PriceMultiplier}) {
+			return
+		}
+	}
+
+	p.mutex.Lock()
+	price := p.price
+	p.mutex.Unlock()
+	writeJSON(w, http.StatusOK, map[string]float64{"price": price})
+}
+
+// GET /api/v1/params, PUT with some or all params, keyed like the config file
+func (p *provider) handleAdminParams(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		update := json.RawMessage{}
+		if !p.authorized(w, r) || !decodeBody(w, r, &update) {
+			return
+		}
+		if !p.adminUpdate(w, updateProviderPayload{Params: update}) {
+			return
+		}
+	}
+
+	p.mutex.Lock()
+	params := p.params
+	p.mutex.Unlock()
+	writeJSON(w, http.StatusOK, params)
+}
+
+// GET /api/v1/behaviour, PUT {"behaviour": "honest"} or {"behaviour": "faulty"}
+func (p *provider) handleAdminBehaviour(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		update := struct {
+			Behaviour string `json:"behaviour"`
+		}{}
+		if !p.authorized(w, r) || !decodeBody(w, r, &update) {
+			return
+		}
+		if update.Behaviour == "" {
+			writeError(w, http.StatusBadRequest, errors.New("want behaviour"))
+			return
+		}
+		if !p.adminUpdate(w, updateProviderPayload{Behaviour: update.Behaviour}) {
+			return
+		}
+	}
+
+	p.mutex.Lock()
+	behaviour := p.behaviour()
+	p.mutex.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"behaviour": behaviour})
+}
+
+// adminUpdate applies the update the same way UPDATE_PROVIDER does, it
+// reports whether it succeeded and writes the error response otherwise
+func (p *provider) adminUpdate(w http.ResponseWriter, update updateProviderPayload) bool {
+	if err := p.handleUpdateProvider(update); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+// authorized checks the bearer token of a write request and writes the error
+// response if it doesn't match
+func (p *provider) authorized(w http.ResponseWriter, r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		token = ""
+	}
+	switch err := p.checkAdminToken(token); err {
+	case nil:
+		return true
+	case errAdminWritesDisabled:
+		writeError(w, http.StatusForbidden, err)
+	default:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, err)
+	}
+	return false
+}
+
+// checkAdminToken tells whether token allows runtime updates, through the
+// admin API or UPDATE_PROVIDER. None are allowed without admin_token
+func (p *provider) checkAdminToken(token string) error {
+	if p.adminToken == "" {
+		return errAdminWritesDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) != 1 {
+		return errAdminTokenInvalid
+	}
+	return nil
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed: %s", r.Method))
+	return false
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, val any) bool {
+	if err := json.NewDecoder(r.Body).Decode(val); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to decode request body: %w", err))
+		return false
+	}
+	return true
+}
+
+func queryInt(val string, fallback int) (int, error) {
+	if val == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(val)
+}
+
+func writeJSON(w http.ResponseWriter, status int, val any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(val)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,215 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+
+	"github.com/google/uuid"
+)
+
+const testAdminToken = "secret"
+
+func newAdminTestProvider(t *testing.T, adminToken string) *provider {
+	t.Helper()
+	return New(options{
+		ID:                   "p0",
+		Address:              "127.0.0.1:0",
+		Iperf3BaseServerPort: "5300",
+		Iperf3ServerCount:    2,
+		Price:                0.5,
+		Params:               NewParams(30000, 0.5, 0.5, 0.5, 3, 0),
+		AdminToken:           adminToken,
+		Logging:              logging.Options{Level: "error"},
+	})
+}
+
+// serveAdmin calls handler with a request, token is sent as bearer token
+// unless it is empty
+func serveAdmin(handler http.HandlerFunc, method string, target string, body string,
+	token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder
+}
+
+// addTransactions adds count transactions, one millisecond apart, in the
+// state that set leaves them in
+func addTransactions(p *provider, count int, set func(transaction *transaction)) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ids := []string{}
+	now := time.Now().UnixMilli()
+	for i := 0; i < count; i++ {
+		transaction := transaction{
+			transactionID:   uuid.New(),
+			transactionTime: now + int64(len(p.transactions)),
+		}
+		set(&transaction)
+		p.transactions[transaction.transactionID.String()] = transaction
+		ids = append(ids, transaction.transactionID.String())
+	}
+	return ids
+}
+
+type transactionsPage struct {
+	Total        int               `json:"total"`
+	Offset       int               `json:"offset"`
+	Limit        int               `json:"limit"`
+	Transactions []transactionView `json:"transactions"`
+}
+
+func getTransactions(t *testing.T, p *provider, query string) (int, transactionsPage) {
+	t.Helper()
+	recorder := serveAdmin(p.handleAdminTransactions, http.MethodGet, "/api/v1/transactions?"+query, "", "")
+	page := transactionsPage{}
+	if recorder.Code == http.StatusOK {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to unmarshal page: %v", err)
+		}
+	}
+	return recorder.Code, page
+}
+
+func TestAdminWritesNeedTheToken(t *testing.T) {
+	p := newAdminTestProvider(t, testAdminToken)
+	body := `{"price": 0.8}`
+
+	for _, token := range []string{"", "wrong"} {
+		recorder := serveAdmin(p.handleAdminPrice, http.MethodPut, "/api/v1/price", body, token)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("PUT with token %q = %d, want %d", token, recorder.Code, http.StatusUnauthorized)
+		}
+	}
+	if p.price != 0.5 {
+		t.Fatalf("price = %v after unauthorized writes, want 0.5", p.price)
+	}
+
+	recorder := serveAdmin(p.handleAdminPrice, http.MethodPut, "/api/v1/price", body, testAdminToken)
+	if recorder.Code != http.StatusOK || p.price != 0.8 {
+		t.Errorf("PUT with the token = %d, price %v, want %d, 0.8", recorder.Code, p.price, http.StatusOK)
+	}
+
+	// Reads are open
+	recorder = serveAdmin(p.handleAdminPrice, http.MethodGet, "/api/v1/price", "", "")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("GET = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
+
+func TestAdminWritesDisabledWithoutToken(t *testing.T) {
+	p := newAdminTestProvider(t, "")
+
+	recorder := serveAdmin(p.handleAdminBehaviour, http.MethodPut, "/api/v1/behaviour", `{"behaviour": "faulty"}`,
+		"anything")
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("PUT = %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+	if p.isFaulty {
+		t.Errorf("behaviour changed although writes are disabled")
+	}
+}
+
+func TestAdminParamsRejectsUnknownKeys(t *testing.T) {
+	p := newAdminTestProvider(t, testAdminToken)
+
+	recorder := serveAdmin(p.handleAdminParams, http.MethodPut, "/api/v1/params", `{"gama": 0.5}`, testAdminToken)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("PUT unknown key = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+	recorder = serveAdmin(p.handleAdminParams, http.MethodPut, "/api/v1/params", `{"gamma": 0.5}`, testAdminToken)
+	if recorder.Code != http.StatusOK || p.params.Gamma != 0.5 {
+		t.Errorf("PUT gamma = %d, gamma %v, want %d, 0.5", recorder.Code, p.params.Gamma, http.StatusOK)
+	}
+}
+
+func TestAdminTransactionsStateFilter(t *testing.T) {
+	p := newAdminTestProvider(t, testAdminToken)
+	now := time.Now().UnixMilli()
+	addTransactions(p, 2, func(transaction *transaction) {
+		transaction.winner = peerInfo{ProviderID: p.id}
+		transaction.flowStartTime = now
+		transaction.flowEndTime = now
+		transaction.ports = []int{5300}
+	})
+	addTransactions(p, 3, func(transaction *transaction) {
+		transaction.winner = peerInfo{ProviderID: "p1"}
+		transaction.flowStartTime = now
+		transaction.flowEndTime = now
+	})
+	addTransactions(p, 1, func(transaction *transaction) {
+		transaction.winner = peerInfo{ProviderID: "p1"}
+		transaction.flowStartTime = now
+	})
+
+	tests := []struct {
+		state string
+		want  int
+	}{
+		{"", 6},
+		{transactionWon, 2},
+		{transactionLost, 3},
+		{transactionWon + "," + transactionFlowing, 3},
+		{transactionRefused, 0},
+	}
+	for _, test := range tests {
+		code, page := getTransactions(t, p, "state="+test.state)
+		if code != http.StatusOK || page.Total != test.want || len(page.Transactions) != test.want {
+			t.Errorf("state=%s = %d, %d of %d transactions, want %d", test.state, code, len(page.Transactions),
+				page.Total, test.want)
+		}
+		for _, view := range page.Transactions {
+			if test.state != "" && !strings.Contains(test.state, view.State) {
+				t.Errorf("state=%s listed a %s transaction", test.state, view.State)
+			}
+		}
+	}
+
+	if code, _ := getTransactions(t, p, "state=won,bogus"); code != http.StatusBadRequest {
+		t.Errorf("unknown state = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestAdminTransactionsPagination(t *testing.T) {
+	p := newAdminTestProvider(t, testAdminToken)
+	ids := addTransactions(p, 5, func(transaction *transaction) {})
+
+	// Newest first
+	code, page := getTransactions(t, p, "offset=1&limit=2")
+	if code != http.StatusOK || page.Total != 5 || len(page.Transactions) != 2 {
+		t.Fatalf("offset=1&limit=2 = %d, %d of %d transactions, want 2 of 5", code, len(page.Transactions),
+			page.Total)
+	}
+	if page.Transactions[0].TransactionID != ids[3] || page.Transactions[1].TransactionID != ids[2] {
+		t.Errorf("offset=1&limit=2 listed %s, %s, want %s, %s", page.Transactions[0].TransactionID,
+			page.Transactions[1].TransactionID, ids[3], ids[2])
+	}
+
+	_, page = getTransactions(t, p, "offset=4&limit=10")
+	if len(page.Transactions) != 1 || page.Transactions[0].TransactionID != ids[0] {
+		t.Errorf("offset=4&limit=10 listed %d transactions, want the oldest", len(page.Transactions))
+	}
+
+	// offset+limit overflows
+	code, page = getTransactions(t, p, fmt.Sprintf("offset=%d&limit=%d", math.MaxInt, adminMaxLimit))
+	if code != http.StatusOK || page.Total != 5 || len(page.Transactions) != 0 {
+		t.Errorf("offset past the end = %d, %d of %d transactions, want none of 5", code,
+			len(page.Transactions), page.Total)
+	}
+
+	for _, query := range []string{"offset=-1", "offset=x", "limit=0", fmt.Sprintf("limit=%d", adminMaxLimit+1)} {
+		if code, _ := getTransactions(t, p, query); code != http.StatusBadRequest {
+			t.Errorf("%s = %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
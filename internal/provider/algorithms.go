@@ -1,30 +1,30 @@
 package provider
 
 import (
-	"fmt"
 	"math"
 	"math/rand"
+	"wifi-trade-consensus/internal/pkg/logging"
 
 	"github.com/google/uuid"
 )
 
 const oneDayInMS = 86400000 // 1 day = 86400000 milliseconds
 
-func (p *provider) calculateFFSnew(peerList peers, allFFS allFFS) FFS {
+func (p *provider) calculateFFSnew(peerList peers, allFFS allFFS, tau float64) FFS {
 	FFSnew := FFS{}
 
 	for _, peer := range peerList {
 		if peer.ProviderID == p.id {
 			continue
 		}
-		FF := p.calculateFFnew(peer, peerList, allFFS)
+		FF := p.calculateFFnew(peer, peerList, allFFS, tau)
 		FFSnew[peer.ProviderID] = FF
 	}
 
 	return FFSnew
 }
 
-func (p *provider) calculateFFnew(targetPeer peerInfo, peerList peers, allFFS allFFS) float64 {
+func (p *provider) calculateFFnew(targetPeer peerInfo, peerList peers, allFFS allFFS, tau float64) float64 {
 	// Calculate FFsum
 	selfTargetPeerFF := allFFS[p.id][targetPeer.ProviderID]
 	FFsum := selfTargetPeerFF // Init
@@ -51,13 +51,13 @@ func (p *provider) calculateFFnew(targetPeer peerInfo, peerList peers, allFFS al
 	// Calculate FFnew
 	sampleN := 0
 	FFnew := 0.0
-	if math.Abs(calculateZScore(selfTargetPeerFF, FFmu, FFsigma)) <= p.params.Tau {
+	if math.Abs(calculateZScore(selfTargetPeerFF, FFmu, FFsigma)) <= tau {
 		FFnew = selfTargetPeerFF
 		sampleN += 1
 	}
 	for _, peer := range peerList {
 		zScore := calculateZScore(allFFS[peer.ProviderID][targetPeer.ProviderID], FFmu, FFsigma)
-		if math.Abs(zScore) <= p.params.Tau {
+		if math.Abs(zScore) <= tau {
 			FFnew += allFFS[peer.ProviderID][targetPeer.ProviderID]
 			sampleN += 1
 		}
@@ -67,21 +67,21 @@ func (p *provider) calculateFFnew(targetPeer peerInfo, peerList peers, allFFS al
 	return FFnew
 }
 
-func (p *provider) calculateFaultyFFSnew(peerList peers, allFFS allFFS) FFS {
+func (p *provider) calculateFaultyFFSnew(peerList peers, allFFS allFFS, tau float64) FFS {
 	FFSnew := FFS{}
 
 	for _, peer := range peerList {
 		if peer.ProviderID == p.id {
 			continue
 		}
-		FF := p.calculateFaultyFFnew(peer, peerList, allFFS)
+		FF := p.calculateFaultyFFnew(peer, peerList, allFFS, tau)
 		FFSnew[peer.ProviderID] = FF
 	}
 
 	return FFSnew
 }
 
-func (p *provider) calculateFaultyFFnew(targetPeer peerInfo, peerList peers, allFFS allFFS) float64 {
+func (p *provider) calculateFaultyFFnew(targetPeer peerInfo, peerList peers, allFFS allFFS, tau float64) float64 {
 	// Calculate FFsum
 	selfTargetPeerFF := allFFS[p.id][targetPeer.ProviderID]
 	FFsum := selfTargetPeerFF // Init
@@ -108,13 +108,13 @@ func (p *provider) calculateFaultyFFnew(targetPeer peerInfo, peerList peers, all
 	// Calculate FFnew
 	sampleN := 0
 	FFnew := 0.0
-	if math.Abs(calculateZScore(selfTargetPeerFF, FFmu, FFsigma)) <= p.params.Tau {
+	if math.Abs(calculateZScore(selfTargetPeerFF, FFmu, FFsigma)) <= tau {
 		FFnew = selfTargetPeerFF
 		sampleN += 1
 	}
 	for _, peer := range peerList {
 		zScore := calculateZScore(allFFS[peer.ProviderID][targetPeer.ProviderID], FFmu, FFsigma)
-		if math.Abs(zScore) <= p.params.Tau {
+		if math.Abs(zScore) <= tau {
 			FFnew += allFFS[peer.ProviderID][targetPeer.ProviderID]
 			sampleN += 1
 		}
@@ -137,13 +137,19 @@ func (p *provider) calculateFFS(transaction transaction) map[string]float64 {
 	for _, peer := range transaction.peerList {
 		peerScore, exists := p.peerScoreMatrix[peer.ProviderID]
 		if !exists {
-			fmt.Printf("failed to get peer score for provider %s\n", peer.ProviderID)
-			FFS[peer.ProviderID] = p.params.DefaultPeerFF
+			p.log.Debug("no peer score for provider, using default FF", logging.Transaction(transaction.transactionID),
+				"provider", peer.ProviderID)
+			FFS[peer.ProviderID] = transaction.params.DefaultPeerFF
 			continue
 		}
 
-		fmt.Println("customerQOS:", customerQOS)
-		fmt.Println("peerScore:", peerScore)
+		p.log.Debug("scoring provider", logging.Transaction(transaction.transactionID), "provider", peer.ProviderID,
+			"customer_qos", customerQOS, "peer_score", peerScore)
+
+		// Prefer the price quoted for this transaction over the last seen one
+		if price, exists := transaction.peerPrices[peer.ProviderID]; exists {
+			peerScore.lastPrice = price
+		}
 
 		PF := calculatePriceFittingness(customerQOS.PriceConsumer, peerScore.lastPrice, customerQOS.Epsilon)
 		SF := calculateSpeedFittingness(customerQOS.UplinkSpeedConsumer, peerScore.uplinkSpeed, customerQOS.Mu,
@@ -163,13 +169,19 @@ func (p *provider) calculateFaultyFFS(transaction transaction) map[string]float6
 	for _, peer := range transaction.peerList {
 		peerScore, exists := p.peerScoreMatrix[peer.ProviderID]
 		if !exists {
-			fmt.Printf("failed to get peer score for provider %s\n", peer.ProviderID)
-			FFS[peer.ProviderID] = p.params.DefaultPeerFF
+			p.log.Debug("no peer score for provider, using default FF", logging.Transaction(transaction.transactionID),
+				"provider", peer.ProviderID)
+			FFS[peer.ProviderID] = transaction.params.DefaultPeerFF
 			continue
 		}
 
-		fmt.Println("customerQOS:", customerQOS)
-		fmt.Println("peerScore:", peerScore)
+		p.log.Debug("scoring provider", logging.Transaction(transaction.transactionID), "provider", peer.ProviderID,
+			"customer_qos", customerQOS, "peer_score", peerScore)
+
+		// Prefer the price quoted for this transaction over the last seen one
+		if price, exists := transaction.peerPrices[peer.ProviderID]; exists {
+			peerScore.lastPrice = price
+		}
 
 		PF := calculatePriceFittingness(customerQOS.PriceConsumer, peerScore.lastPrice, customerQOS.Epsilon)
 		SF := calculateSpeedFittingness(customerQOS.UplinkSpeedConsumer, peerScore.uplinkSpeed, customerQOS.Mu,
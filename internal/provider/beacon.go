@@ -1,14 +1,14 @@
 package provider
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
-	"os"
 	"time"
 	"wifi-trade-consensus/internal/pkg/events"
-
-	"github.com/spf13/viper"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/metrics"
 )
 
 type beaconSettings struct {
@@ -18,22 +18,40 @@ type beaconSettings struct {
 	mockRSSI                   int
 }
 
-func (p *provider) NewBeaconEmitter(beaconSettings beaconSettings) {
+// NewBeaconEmitter sends a beacon to every peer each interval until ctx is
+// cancelled, the peers are also told with LEAVE when the provider shuts down
+func (p *provider) NewBeaconEmitter(ctx context.Context, beaconSettings beaconSettings) {
+	p.mutex.Lock()
+	p.beaconPeers = beaconSettings.peers
+	p.mutex.Unlock()
+
+	ticker := time.NewTicker(time.Millisecond * time.Duration(beaconSettings.interval))
+	defer ticker.Stop()
 	for {
 		// Wait for beacon interval
-		time.Sleep(time.Millisecond * time.Duration(beaconSettings.interval))
+		select {
+		case <-ctx.Done():
+			p.log.Info("stopped beacon emitter")
+			return
+		case <-ticker.C:
+		}
 		for _, peer := range beaconSettings.peers {
 			// fmt.Println("sending beacon to:", peer.address)
 			conn, err := net.Dial("tcp", peer.Address)
 			if err != nil {
-				fmt.Printf("failed to send beacon to %s: %v\n", peer.Address, err)
+				p.metrics.sent(events.BEACON, metrics.OutcomeDialFailed)
+				p.log.Debug("failed to send beacon", logging.Event(events.BEACON), "address", peer.Address, logging.Err(err))
 				continue
 			}
 
-			p.channelUtilizationRate = calculateChannelUtilizationRate(p.activeFlowCount)
+			// The behaviour may change at runtime, both are read under the lock
+			p.mutex.Lock()
+			channelUtilizationRate := calculateChannelUtilizationRate(p.activeFlowCount)
 			if p.isFaulty {
-				p.channelUtilizationRate = int(getRandomizedVal(float64(p.channelUtilizationRate), 100, 1, 255))
+				channelUtilizationRate = int(getRandomizedVal(float64(channelUtilizationRate), 100, 1, 255))
 			}
+			p.channelUtilizationRate = channelUtilizationRate
+			p.mutex.Unlock()
 
 			payload := beaconPayload{
 				PayloadMeta: PayloadMeta{
@@ -41,20 +59,23 @@ func (p *provider) NewBeaconEmitter(beaconSettings beaconSettings) {
 					OriginID:      p.id,
 					OriginAddress: p.address,
 				},
-				ChannelUtilizationRate: p.channelUtilizationRate,
+				ChannelUtilizationRate: channelUtilizationRate,
 				RSSI:                   beaconSettings.mockRSSI,
 			}
 
 			jsonPayload, err := json.Marshal(payload)
 			if err != nil {
-				fmt.Println("failed to marshal beacon payload:", err)
+				p.log.Error("failed to marshal payload", logging.Event(events.BEACON), logging.Err(err))
 				continue
 			}
 
 			// Send beacon to each peer concurrently
 			go func(conn net.Conn) {
 				if _, err := conn.Write(jsonPayload); err != nil {
-					fmt.Println("failed to send beacon:", err)
+					p.metrics.sent(events.BEACON, metrics.OutcomeSendFailed)
+					p.log.Debug("failed to send beacon", logging.Event(events.BEACON), logging.Err(err))
+				} else {
+					p.metrics.sent(events.BEACON, metrics.OutcomeSent)
 				}
 				conn.Close()
 			}(conn)
@@ -81,39 +102,12 @@ func NewBeaconSettings(addresses []string, interval int, mockChannelUtil int, mo
 	return beaconSettings
 }
 
-func NewBeaconSettingsFromConfigFile() (*beaconSettings, error) {
-
-	// Name of config file (without extension)
-	// Get docker container's env variable
-	if nodeNum := os.Getenv("node_num"); nodeNum != "" {
-		viper.SetConfigName("beacon_config" + nodeNum)
-	} else {
-		viper.SetConfigName("beacon_config")
-	}
-
-	viper.SetConfigType("json") // REQUIRED if the config file does not have the extension in the name
-	viper.AddConfigPath(".")    // Path to look for the config file in
-	viper.AddConfigPath("cmd/provider")
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// config not found, ignore err
-			return nil, fmt.Errorf("config file not found")
-		} else {
-			// other errors, ignore err
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
-	}
-
-	config := struct {
-		Addresses                  []string `mapstructure:"addresses"`
-		Interval                   int      `mapstructure:"interval"`
-		MockChannelUtilizationRate int      `mapstructure:"mock_channel_utilization_rate"`
-		MockRSSI                   int      `mapstructure:"mock_rssi"`
-	}{}
-	err := viper.Unmarshal(&config)
+// NewBeaconSettingsFromConfig decodes and validates the settings of a beacon
+// config file
+func NewBeaconSettingsFromConfig(settings map[string]interface{}) (*beaconSettings, error) {
+	config, err := newBeaconConfig(settings)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal params config file: %w", err)
+		return nil, err
 	}
 
 	peers := peers{}
@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"fmt"
+
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/throughput"
+)
+
+type beaconConfig struct {
+	Addresses                  []string `mapstructure:"addresses"`
+	Interval                   int      `mapstructure:"interval"` // ms
+	MockChannelUtilizationRate int      `mapstructure:"mock_channel_utilization_rate"`
+	MockRSSI                   int      `mapstructure:"mock_rssi"`
+}
+
+// NewOptionsFromConfig decodes and validates the settings of a provider config
+// file
+func NewOptionsFromConfig(settings map[string]interface{}) (*options, error) {
+	options := options{}
+	if err := config.Decode(settings, &options); err != nil {
+		return nil, err
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+func (options options) validate() error {
+	problems := config.Problems{}
+	if options.ID == "" {
+		problems.Add("id must be set")
+	}
+	problems.Address("address", options.Address)
+	if options.Iperf3ServerCount < portsPerFlow {
+		problems.Add("iperf3_server_count must be >= %d, a flow leases %d ports, got %d", portsPerFlow, portsPerFlow,
+			options.Iperf3ServerCount)
+	}
+	problems.PortRange("iperf3_base_server_port", options.Iperf3BaseServerPort, options.Iperf3ServerCount)
+	if _, err := throughput.New(options.ThroughputEngine); err != nil {
+		problems.Merge(err)
+	}
+	problems.Positive("price", options.Price)
+	problems.Positive("uplink_speed", options.UplinkSpeed)
+	problems.Positive("downlink_speed", options.DownlinkSpeed)
+	problems.Merge(options.Params.validate())
+	problems.Positive("default_peer_uplink_speed", options.DefaultPeerUplinkSpeed)
+	problems.Positive("default_peer_downlink_speed", options.DefaultPeerDownlinkSpeed)
+	if options.DefaultPeerLastPrice < 0 {
+		problems.Add("default_peer_last_price must be >= 0, got %v", options.DefaultPeerLastPrice)
+	}
+	problems.Closed("default_peer_consumer_feedback", options.DefaultPeerConsumerFeedback, 0, 1)
+	problems.OptionalAddress("metrics_address", options.MetricsAddress)
+	problems.OptionalAddress("admin_address", options.AdminAddress)
+	if options.DrainTimeout < 0 {
+		problems.Add("drain_timeout must be >= 0, got %d", options.DrainTimeout)
+	}
+	if options.PortLeaseTimeout < 0 {
+		problems.Add("port_lease_timeout must be >= 0, got %d", options.PortLeaseTimeout)
+	}
+	problems.Merge(options.Servers.Validate())
+	problems.Merge(options.Retention.Validate())
+	problems.Merge(options.Logging.Validate())
+	return problems.Err()
+}
+
+// newBeaconConfig decodes and validates the settings of a beacon config file
+func newBeaconConfig(settings map[string]interface{}) (*beaconConfig, error) {
+	beaconConfig := beaconConfig{}
+	if err := config.Decode(settings, &beaconConfig); err != nil {
+		return nil, err
+	}
+	if err := beaconConfig.validate(); err != nil {
+		return nil, err
+	}
+	return &beaconConfig, nil
+}
+
+func (beaconConfig beaconConfig) validate() error {
+	problems := config.Problems{}
+	for idx, address := range beaconConfig.Addresses {
+		problems.Address(fmt.Sprintf("addresses[%d]", idx), address)
+	}
+	problems.Positive("interval", float64(beaconConfig.Interval))
+	problems.Closed("mock_channel_utilization_rate", float64(beaconConfig.MockChannelUtilizationRate), 0, 255)
+	problems.Closed("mock_rssi", float64(beaconConfig.MockRSSI), 0, 255)
+	return problems.Err()
+}
+
+// validate checks the QoS requirements of a BUY, the price and speeds divide
+// the fittingness of every peer and epsilon its price fittingness
+func (qos customerQOS) validate() error {
+	problems := config.Problems{}
+	problems.Positive("price", qos.PriceConsumer)
+	problems.Positive("uplink", qos.UplinkSpeedConsumer)
+	problems.Positive("downlink", qos.DownlinkSpeedConsumer)
+	problems.Closed("mu", qos.Mu, 0, 1)
+	problems.Closed("delta", qos.Delta, 0, 1)
+	if qos.Epsilon < 1 {
+		problems.Add("epsilon must be >= 1, got %v", qos.Epsilon)
+	}
+	return problems.Err()
+}
+
+// ValidateConfigFile checks a provider config file without starting a provider
+func ValidateConfigFile(path string) error {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return err
+	}
+	_, err = NewOptionsFromConfig(settings)
+	return err
+}
+
+// ThroughputEngineOfConfigFile returns the id and throughput_engine of the
+// provider config file, iperf3 when unset
+func ThroughputEngineOfConfigFile(path string) (string, string, error) {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return "", "", err
+	}
+	options, err := NewOptionsFromConfig(settings)
+	if err != nil {
+		return "", "", err
+	}
+	if options.ThroughputEngine == "" {
+		return options.ID, throughput.EngineIperf3, nil
+	}
+	return options.ID, options.ThroughputEngine, nil
+}
+
+// ValidateBeaconConfigFile checks a beacon config file without starting a
+// provider
+func ValidateBeaconConfigFile(path string) error {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return err
+	}
+	_, err = newBeaconConfig(settings)
+	return err
+}
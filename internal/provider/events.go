@@ -1,26 +1,42 @@
 package provider
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"time"
 
+	"wifi-trade-consensus/internal/pkg/config"
 	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/metrics"
+	phases "wifi-trade-consensus/internal/pkg/timeline"
+	"wifi-trade-consensus/internal/pkg/tracing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errTransactionNotFound = errors.New("transaction doesn't exist")
+	errVoteRoundUnfinished = errors.New("vote round didn't finish before shutdown")
 )
 
 func (p *provider) handleBeaconPayload(payload beaconPayload) {
 	currentTimestampMS := time.Now().UnixMilli()
 
 	p.mutex.Lock()
+	params := p.params
 	entry, exists := p.peerScoreMatrix[payload.OriginID]
 	p.mutex.Unlock()
 	if !exists {
 		p.mutex.Lock()
 		p.peerScoreMatrix[payload.OriginID] = peerScore{
-			uptime:           calculateUptime(currentTimestampMS, currentTimestampMS, p.params.KUptime),
-			signalStrength:   calculateSignalStrength(payload.RSSI, p.params.KStrength),
-			load:             calculateLoad(payload.ChannelUtilizationRate, p.params.KLoad),
+			uptime:           calculateUptime(currentTimestampMS, currentTimestampMS, params.KUptime),
+			signalStrength:   calculateSignalStrength(payload.RSSI, params.KStrength),
+			load:             calculateLoad(payload.ChannelUtilizationRate, params.KLoad),
 			uplinkSpeed:      p.defaultPeerUplinkSpeed,
 			downlinkSpeed:    p.defaultPeerDownlinkSpeed,
 			lastPrice:        p.defaultPeerLastPrice,
@@ -38,7 +54,7 @@ func (p *provider) handleBeaconPayload(payload beaconPayload) {
 	T_0 := &entry.beaconTimestamps.initial
 	T_n := &entry.beaconTimestamps.last
 	T_n1 := currentTimestampMS
-	T_limit := p.params.BeaconTLimit
+	T_limit := params.BeaconTLimit
 
 	// fmt.Println("initial:", *T_0)
 	// fmt.Println("last:", *T_n)
@@ -52,9 +68,9 @@ func (p *provider) handleBeaconPayload(payload beaconPayload) {
 		*T_n = T_n1
 	}
 
-	entry.uptime = calculateUptime(*T_0, T_n1, p.params.KUptime)
-	entry.signalStrength = calculateSignalStrength(payload.RSSI, p.params.KStrength)
-	entry.load = calculateLoad(payload.ChannelUtilizationRate, p.params.KLoad)
+	entry.uptime = calculateUptime(*T_0, T_n1, params.KUptime)
+	entry.signalStrength = calculateSignalStrength(payload.RSSI, params.KStrength)
+	entry.load = calculateLoad(payload.ChannelUtilizationRate, params.KLoad)
 	p.mutex.Lock()
 	p.peerScoreMatrix[payload.OriginID] = entry
 	p.mutex.Unlock()
@@ -62,8 +78,21 @@ func (p *provider) handleBeaconPayload(payload beaconPayload) {
 
 // Handle BUY event and respond by sending REQUEST_VOTE event
 func (p *provider) handleBuyEvent(payload buyPayload) {
-	// Init new transaction record
-	p.transactions[payload.TransactionID.String()] = transaction{
+	log := p.log.With(logging.Event(events.BUY), logging.Transaction(payload.TransactionID))
+	span := p.tracer.Start("handle BUY", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute(logging.KeyPeer, payload.OriginID)
+	defer span.End()
+	voteSpan := p.tracer.Start("vote round", tracing.KindInternal, payload.TransactionID, span.Traceparent())
+	transactionID := payload.TransactionID.String()
+	timeline := phases.New()
+	timeline.Mark(phases.BUY_RECEIVED, payload.OriginID)
+
+	// Init new transaction record, several consumers may be buying at the same
+	// time so every access to the transactions map is guarded. The params are
+	// fixed for the whole transaction, updates apply from the next one
+	p.mutex.Lock()
+	p.transactions[transactionID] = transaction{
+		params:          p.params,
 		transactionID:   payload.TransactionID,
 		transactionTime: time.Now().UnixMilli(),
 		consumerID:      payload.OriginID,
@@ -72,16 +101,19 @@ func (p *provider) handleBuyEvent(payload buyPayload) {
 		peerCount:       len(payload.PeerList),
 		allFFS:          make(allFFS),
 		customerQOS:     payload.customerQOS,
+		peerPrices:      make(map[string]float64),
+		timeline:        timeline,
+		voteSpan:        voteSpan,
 	}
 
-	p.mutex.Lock()
-	FFS := p.calculateFFS(p.transactions[payload.TransactionID.String()])
+	FFS := p.calculateFFS(p.transactions[transactionID])
 
 	// Save FFS calculation to current transaction's allFFS, indexed with self id
-	p.transactions[payload.TransactionID.String()].allFFS[p.id] = FFS
+	p.transactions[transactionID].allFFS[p.id] = FFS
+	price, isFaulty := p.price, p.isFaulty
 	p.mutex.Unlock()
 
-	fmt.Println("FFS calculation:", FFS)
+	log.Debug("calculated FFS", "FFS", FFS)
 
 	// TODO: Register timeout goroutine to send INFORM_VOTE
 
@@ -91,16 +123,23 @@ func (p *provider) handleBuyEvent(payload buyPayload) {
 			continue
 		}
 		go func(peer peerInfo) {
+			sendSpan := p.tracer.Start("send REQUEST_VOTE", tracing.KindClient, payload.TransactionID,
+				voteSpan.Traceparent())
+			sendSpan.SetAttribute(logging.KeyPeer, peer.ProviderID)
+			defer sendSpan.End()
+
 			conn, err := net.Dial("tcp", peer.Address)
 			if err != nil {
-				fmt.Printf("failed to dial remote peer: %v\n", err)
+				p.metrics.sent(events.REQUEST_VOTE, metrics.OutcomeDialFailed)
+				sendSpan.SetError(err)
+				log.Error("failed to dial remote peer", logging.KeyPeer, peer.ProviderID, logging.Err(err))
 				return
 			}
 			defer conn.Close()
 
-			myPrice := p.price
-			if p.isFaulty {
-				myPrice = getRandomizedVal(p.price, 0.5, 0.1, 1)
+			myPrice := price
+			if isFaulty {
+				myPrice = getRandomizedVal(price, 0.5, 0.1, 1)
 			}
 
 			// Build response
@@ -110,6 +149,7 @@ func (p *provider) handleBuyEvent(payload buyPayload) {
 					TransactionID: payload.TransactionID,
 					OriginID:      p.id,
 					OriginAddress: p.address,
+					Traceparent:   sendSpan.Traceparent(),
 				},
 				CandidateID: p.id,
 				Price:       myPrice,
@@ -118,14 +158,18 @@ func (p *provider) handleBuyEvent(payload buyPayload) {
 			// Send REQUEST_VOTE event
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
-				fmt.Printf("failed to marshal payload: %v\n", err)
+				log.Error("failed to marshal payload", logging.Err(err))
 				return
 			}
 			if _, err = conn.Write(jsonResponse); err != nil {
-				fmt.Printf("failed to send REQUEST_VOTE from %s to address %s\n", p.id, peer.Address)
+				p.metrics.sent(events.REQUEST_VOTE, metrics.OutcomeSendFailed)
+				sendSpan.SetError(err)
+				log.Error("failed to send REQUEST_VOTE", logging.KeyPeer, peer.ProviderID, logging.Err(err))
 				return
 			} else {
-				fmt.Println("sent REQUEST_VOTE to", peer.Address)
+				p.metrics.sent(events.REQUEST_VOTE, metrics.OutcomeSent)
+				timeline.Mark(phases.REQUEST_VOTE_SENT, peer.ProviderID)
+				log.Debug("sent REQUEST_VOTE", logging.KeyPeer, peer.ProviderID)
 				return
 			}
 		}(peer)
@@ -134,6 +178,11 @@ func (p *provider) handleBuyEvent(payload buyPayload) {
 
 // Handle REQUEST_VOTE event and respond by sending REPLY_VOTE event
 func (p *provider) handleRequestVote(payload requestVotePayload) {
+	log := p.log.With(logging.Event(events.REQUEST_VOTE), logging.Transaction(payload.TransactionID),
+		logging.KeyPeer, payload.OriginID)
+	span := p.tracer.Start("handle REQUEST_VOTE", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute(logging.KeyPeer, payload.OriginID)
+	defer span.End()
 	p.mutex.Lock()
 	senderAddress, err := p.getPeerAddressByID(payload.TransactionID.String(), payload.OriginID)
 	p.mutex.Unlock()
@@ -149,44 +198,62 @@ func (p *provider) handleRequestVote(payload requestVotePayload) {
 			}
 		}
 		if err != nil {
-			fmt.Printf("failed to obtain sender address: %v\n", err)
+			span.SetError(err)
+			log.Error("failed to obtain sender address", logging.Err(err))
 			return
 		}
 	}
 
 	conn, err := net.Dial("tcp", senderAddress)
 	if err != nil {
-		fmt.Printf("failed to dial remote peer: %v\n", err)
+		p.metrics.sent(events.REPLY_VOTE, metrics.OutcomeDialFailed)
+		span.SetError(err)
+		log.Error("failed to dial remote peer", logging.Err(err))
 		return
 	}
 	defer conn.Close()
 
 	transactionID := payload.TransactionID.String()
+	p.mutex.Lock()
 	trans, exists := p.transactions[transactionID]
+	p.mutex.Unlock()
 	if !exists {
-		fmt.Printf("transaction doesn't exist: %s\n", transactionID)
+		span.SetError(errTransactionNotFound)
+		log.Warn("transaction doesn't exist")
 		return
 	}
+	trans.timeline.Mark(phases.REQUEST_VOTE_RECEIVED, payload.OriginID)
 
-	// TODO wait for all connections
-	// Update peer's price and update its FF
+	// Update peer's price and update its FF, the price is also kept per
+	// transaction so overlapping transactions don't overwrite each other
 	p.mutex.Lock()
 	peerScore := p.peerScoreMatrix[payload.CandidateID]
 	peerScore.lastPrice = payload.Price
 	p.peerScoreMatrix[payload.CandidateID] = peerScore
+	trans.peerPrices[payload.CandidateID] = payload.Price
 	p.mutex.Unlock()
 
-	// Check if all peers' prices have been received
+	// Check if all peers' prices have been received for this transaction, the
+	// peers may change as providers that refused the BUY leave it
 	hasReceivedAll := false
 	for {
 		p.mutex.Lock()
+		// Stop waiting once the transaction has expired
+		current, exists := p.transactions[transactionID]
+		if !exists {
+			p.mutex.Unlock()
+			span.SetError(errTransactionTimedOut)
+			log.Warn("transaction expired while waiting for prices")
+			return
+		}
+		trans = current
 		hasReceivedAll = true
 		for _, peer := range trans.peerList {
 			if peer.ProviderID == p.id {
 				continue
-			} else if p.peerScoreMatrix[peer.ProviderID].lastPrice == 0 {
+			} else if _, exists := trans.peerPrices[peer.ProviderID]; !exists {
 				hasReceivedAll = false
-				fmt.Println("haven't received price for provider:", peer.ProviderID)
+				log.Debug("haven't received price for provider", "provider", peer.ProviderID)
 			}
 		}
 
@@ -199,9 +266,9 @@ func (p *provider) handleRequestVote(payload requestVotePayload) {
 
 	p.mutex.Lock()
 	if p.isFaulty {
-		trans.allFFS[p.id] = p.calculateFaultyFFS(p.transactions[transactionID])
+		trans.allFFS[p.id] = p.calculateFaultyFFS(trans)
 	} else {
-		trans.allFFS[p.id] = p.calculateFFS(p.transactions[transactionID])
+		trans.allFFS[p.id] = p.calculateFFS(trans)
 	}
 
 	FFS := trans.allFFS[p.id]
@@ -211,12 +278,16 @@ func (p *provider) handleRequestVote(payload requestVotePayload) {
 	// p.transactions[payload.TransactionID.String()].allFFS[p.id] = FFS
 
 	// Build response
+	sendSpan := p.tracer.Start("send REPLY_VOTE", tracing.KindClient, payload.TransactionID, span.Traceparent())
+	sendSpan.SetAttribute(logging.KeyPeer, payload.OriginID)
+	defer sendSpan.End()
 	response := replyVotePayload{
 		PayloadMeta: PayloadMeta{
 			PayloadType:   events.REPLY_VOTE,
 			TransactionID: payload.TransactionID,
 			OriginID:      p.id,
 			OriginAddress: p.address,
+			Traceparent:   sendSpan.Traceparent(),
 		},
 		FFS: FFS,
 	}
@@ -224,130 +295,249 @@ func (p *provider) handleRequestVote(payload requestVotePayload) {
 	// Send REPLY_VOTE event
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		fmt.Printf("failed to marshal payload: %v\n", err)
+		log.Error("failed to marshal payload", logging.Err(err))
 		return
 	}
 	if _, err = conn.Write(jsonResponse); err != nil {
-		fmt.Printf("failed to send REPLY_VOTE from %s to address %s: %v\n", p.id, payload.OriginAddress, err)
+		p.metrics.sent(events.REPLY_VOTE, metrics.OutcomeSendFailed)
+		sendSpan.SetError(err)
+		log.Error("failed to send REPLY_VOTE", logging.Err(err))
 		return
 	} else {
-		fmt.Println("sent REPLY_VOTE to address:", payload.OriginAddress)
+		p.metrics.sent(events.REPLY_VOTE, metrics.OutcomeSent)
+		trans.timeline.Mark(phases.REPLY_VOTE_SENT, payload.OriginID)
+		log.Debug("sent REPLY_VOTE")
 		return
 	}
 }
 
 // Handle REPLY_VOTE event and respond by sending INFORM_VOTE event to consumer
 func (p *provider) handleReplyVote(payload replyVotePayload) {
+	log := p.log.With(logging.Event(events.REPLY_VOTE), logging.Transaction(payload.TransactionID),
+		logging.KeyPeer, payload.OriginID)
+	span := p.tracer.Start("handle REPLY_VOTE", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute(logging.KeyPeer, payload.OriginID)
+	defer span.End()
 	transactionID := payload.TransactionID.String()
 
-	peerCount := p.transactions[transactionID].peerCount
 	peerID := payload.OriginID
 
 	// Save current FFS to allFFS
 	p.mutex.Lock()
-	p.transactions[transactionID].allFFS[peerID] = payload.FFS
-	allFFS := p.transactions[transactionID].allFFS
-
-	// If haven't received all FFS yet
-	// fmt.Println("len of allFFS:", allFFS)
-	// fmt.Println("peer count:", peerCount)
-	if len(allFFS) < peerCount {
+	transaction, exists := p.transactions[transactionID]
+	if !exists {
 		p.mutex.Unlock()
-		fmt.Println("haven't received all FFS yet, current count:", len(allFFS))
-		// TODO: create new goroutine with timeout to send
+		span.SetError(errTransactionNotFound)
+		log.Warn("transaction doesn't exist")
 		return
 	}
+	transaction.timeline.Mark(phases.REPLY_VOTE_RECEIVED, peerID)
+	transaction.allFFS[peerID] = payload.FFS
 	p.mutex.Unlock()
 
-	transaction, exists := p.transactions[transactionID]
-	if !exists {
-		fmt.Printf("transaction doesn't exist: %s\n", transactionID)
+	p.sendInformVote(payload.TransactionID, log)
+}
+
+// sendInformVote sends INFORM_VOTE to the consumer once the FFS of every peer
+// of the transaction is in, only the first call that finds them all sends it
+func (p *provider) sendInformVote(transactionID uuid.UUID, log *slog.Logger) {
+	p.mutex.Lock()
+	transaction, exists := p.transactions[transactionID.String()]
+	if !exists || transaction.informed {
+		p.mutex.Unlock()
+		return
+	}
+	allFFS := transaction.allFFS
+
+	// If haven't received all FFS yet
+	if len(allFFS) < transaction.peerCount {
+		p.mutex.Unlock()
+		log.Debug("haven't received all FFS yet", "count", len(allFFS), "want", transaction.peerCount)
+		// TODO: create new goroutine with timeout to send
 		return
 	}
+	transaction.informed = true
+	p.transactions[transactionID.String()] = transaction
 
-	fmt.Println("allFFS calculation:", allFFS)
+	log.Debug("received all FFS", "allFFS", allFFS)
 	var FFSnew FFS
 	if p.isFaulty {
-		FFSnew = p.calculateFaultyFFSnew(transaction.peerList, transaction.allFFS)
+		FFSnew = p.calculateFaultyFFSnew(transaction.peerList, transaction.allFFS, transaction.params.Tau)
 	} else {
-		FFSnew = p.calculateFFSnew(transaction.peerList, transaction.allFFS)
+		FFSnew = p.calculateFFSnew(transaction.peerList, transaction.allFFS, transaction.params.Tau)
 	}
-	fmt.Println("FFSnew calculation:", FFSnew)
+	price := p.price
+	p.mutex.Unlock()
+	log.Debug("calculated FFSnew", "FFSnew", FFSnew)
+
+	// The vote round ends once INFORM_VOTE is out, whether it made it or not
+	voteSpan := transaction.voteSpan
+	defer voteSpan.End()
+	sendSpan := p.tracer.Start("send INFORM_VOTE", tracing.KindClient, transactionID, voteSpan.Traceparent())
+	sendSpan.SetAttribute(logging.KeyPeer, transaction.consumerID)
+	defer sendSpan.End()
 
 	// Build response
 	response := informVotePayload{
 		PayloadMeta: PayloadMeta{
 			PayloadType:   events.INFORM_VOTE,
-			TransactionID: payload.TransactionID,
+			TransactionID: transactionID,
 			OriginID:      p.id,
 			OriginAddress: p.address,
+			Traceparent:   sendSpan.Traceparent(),
 		},
 		peerInfo: peerInfo{
 			ProviderID:           p.id,
 			Address:              p.address,
 			Iperf3BaseServerPort: p.iperf3BaseServerPort,
 			Iperf3ServerCount:    p.iperf3ServerCount,
+			ThroughputEngine:     p.engine.Name(),
+			Saturated:            p.ports.Free() < portsPerFlow,
+			HealthyPorts:         p.ports.Healthy(),
 		},
 		FFSnew: FFSnew,
-		Price:  p.price,
+		Price:  price,
 	}
 
 	// TODO: This should be sent to consumer, not peers
 	// Send INFORM_VOTE event to all peers concurrently
 	conn, err := net.Dial("tcp", transaction.consumerAddress)
 	if err != nil {
-		fmt.Println("failed to dial consumer:", err)
+		p.metrics.sent(events.INFORM_VOTE, metrics.OutcomeDialFailed)
+		sendSpan.SetError(err)
+		voteSpan.SetError(err)
+		log.Error("failed to dial consumer", logging.Err(err))
 		return
 	}
 	defer conn.Close()
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		fmt.Println("failed to marshal payload", err)
+		log.Error("failed to marshal payload", logging.Err(err))
 		return
 	}
 
 	if _, err := conn.Write(jsonResponse); err != nil {
-		fmt.Printf("failed to send INFORM_VOTE to consumer %s: %v\n", transaction.consumerAddress, err)
+		p.metrics.sent(events.INFORM_VOTE, metrics.OutcomeSendFailed)
+		sendSpan.SetError(err)
+		voteSpan.SetError(err)
+		log.Error("failed to send INFORM_VOTE", logging.Err(err))
 		return
 	} else {
-		fmt.Println("sent INFORM_VOTE to consumer:", transaction.consumerAddress)
+		p.metrics.sent(events.INFORM_VOTE, metrics.OutcomeSent)
+		transaction.timeline.Mark(phases.INFORM_VOTE_SENT, transaction.consumerID)
+		p.metrics.voteRoundDuration.Observe(
+			transaction.timeline.Between(phases.BUY_RECEIVED, phases.INFORM_VOTE_SENT) / 1000)
+		log.Info("sent INFORM_VOTE", "consumer", transaction.consumerID, "FFSnew", FFSnew)
 		return
 	}
 }
 
-func (p *provider) handleStartFlow(payload startFlowPayload) {
-	transaction, exists := p.transactions[payload.TransactionID.String()]
+// Handle START_FLOW event, the winner leases the ports of the flow and replies
+// with them, the flow is refused when the pool is exhausted
+func (p *provider) handleStartFlow(payload startFlowPayload, conn net.Conn) {
+	log := p.log.With(logging.Event(events.START_FLOW), logging.Transaction(payload.TransactionID))
+	span := p.tracer.Start("handle START_FLOW", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute("winner", payload.Winner.ProviderID)
+	defer span.End()
+	transactionID := payload.TransactionID.String()
+	p.mutex.Lock()
+
+	transaction, exists := p.transactions[transactionID]
 	if !exists {
-		fmt.Printf("transaction doesn't exist: %s\n", payload.TransactionID.String())
+		p.mutex.Unlock()
+		span.SetError(errTransactionNotFound)
+		log.Warn("transaction doesn't exist")
+		return
+	}
+
+	transaction.timeline.Mark(phases.START_FLOW_RECEIVED, payload.OriginID)
+	transaction.winner = payload.Winner
+	transaction.flowStartTime = time.Now().UnixMilli()
+
+	if payload.Winner.ProviderID != p.id {
+		p.transactions[transactionID] = transaction
+		p.mutex.Unlock()
 		return
 	}
 
-	if payload.Winner.ProviderID == p.id {
+	reply := startFlowReply{}
+	leased, err := p.ports.Lease(transactionID, portsPerFlow, time.Now())
+	if err != nil {
+		reply.Error = err.Error()
+		span.SetError(err)
+		log.Warn("refused flow", logging.Err(err))
+	} else {
 		// Increase active flow count, to calculate current channel utilization
 		// rate (sent in beacon)
 		p.activeFlowCount += 1
+		transaction.ports = leased
+		reply.Ports = leased
+		span.SetAttribute("ports", fmt.Sprint(leased))
 	}
 
-	transaction.winner = payload.Winner
-
 	// Reassign
-	p.mutex.Lock()
-	p.transactions[payload.TransactionID.String()] = transaction
+	p.transactions[transactionID] = transaction
 	p.mutex.Unlock()
+
+	jsonReply, err := json.Marshal(reply)
+	if err != nil {
+		log.Error("failed to marshal payload", logging.Err(err))
+		return
+	}
+	if _, err := conn.Write(jsonReply); err != nil {
+		log.Error("failed to send START_FLOW reply", logging.Err(err))
+		return
+	}
+	log.Info("sent START_FLOW reply", "ports", reply.Ports)
 }
 
 func (p *provider) handleTransactionEnd(payload transactionEndPayload) {
+	log := p.log.With(logging.Event(events.TRANSACTION_END), logging.Transaction(payload.TransactionID))
+	span := p.tracer.Start("handle TRANSACTION_END", tracing.KindServer, payload.TransactionID, payload.Traceparent)
+	span.SetAttribute("rating", payload.Rating)
+	defer span.End()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
 	transaction, exists := p.transactions[payload.TransactionID.String()]
 	if !exists {
-		fmt.Printf("transaction doesn't exist: %s\n", payload.TransactionID.String())
+		span.SetError(errTransactionNotFound)
+		log.Warn("transaction doesn't exist")
 		return
 	}
 
+	transaction.timeline.Mark(phases.TRANSACTION_END_RECEIVED, payload.OriginID)
+	transaction.flowEndTime = time.Now().UnixMilli()
+	transaction.rating = payload.Rating
+	transaction.ratingBreakdown = payload.RatingBreakdown
+	transaction.uplinkSpeed = payload.UplinkSpeed
+	transaction.downlinkSpeed = payload.DownlinkSpeed
+	p.transactions[payload.TransactionID.String()] = transaction
+
 	if transaction.winner.ProviderID == p.id {
 		// Decrease active flow count, to calculate current channel utilization
-		// rate (sent in beacon)
-		p.activeFlowCount -= 1
+		// rate (sent in beacon), and free the ports of the flow
+		switch {
+		case len(transaction.ports) > 0:
+			p.activeFlowCount -= 1
+			p.ports.Release(payload.TransactionID.String())
+			p.wins += 1
+			p.metrics.transactions.Inc("won")
+		case transaction.leaseExpired:
+			p.wins += 1
+			p.metrics.transactions.Inc("won")
+		default:
+			p.metrics.transactions.Inc(transactionRefused)
+		}
+	} else {
+		p.metrics.transactions.Inc("lost")
+	}
+
+	// A refusal is the winner running out of ports, admission control and not
+	// bad service, its peer score is left as it was
+	if payload.Refused {
+		return
 	}
 
 	for _, peer := range transaction.peerList {
@@ -355,59 +545,136 @@ func (p *provider) handleTransactionEnd(payload transactionEndPayload) {
 			continue
 		}
 
-		p.mutex.Lock()
 		peerScore := p.peerScoreMatrix[peer.ProviderID]
 
 		peerScore.uplinkSpeed = payload.UplinkSpeed
 		peerScore.downlinkSpeed = payload.DownlinkSpeed
 		peerScore.consumerFeedback = calculateCustomerFeedback(peerScore.consumerFeedback,
-			payload.Rating, p.params.Gamma)
+			payload.Rating, transaction.params.Gamma)
 
 		// Reassign
 		p.peerScoreMatrix[peer.ProviderID] = peerScore
-		p.mutex.Unlock()
 	}
 }
 
 func (p *provider) handleGetProviderStats(conn net.Conn) {
-
+	log := p.log.With(logging.Event(events.GET_PROVIDER_STATS), "remote", conn.RemoteAddr().String())
+	p.mutex.Lock()
 	jsonResponse, err := json.Marshal(struct {
-		ID               string          `json:"id"`
-		Address          string          `json:"address"`
-		Price            float64         `json:"price"`
-		UplinkSpeed      float64         `json:"uplink_speed"`
-		DownlinkSpeed    float64         `json:"downlink_speed"`
-		Params           params          `json:"params"`
-		PeerScoreMatrix  peerScoreMatrix `json:"peer_score_matrix"`
-		Transactions     transactions    `json:"transactions"`
-		Iperf3ServerPort string          `json:"iperf3_server_port"`
+		ID               string                        `json:"id"`
+		Address          string                        `json:"address"`
+		Price            float64                       `json:"price"`
+		Behaviour        string                        `json:"behaviour"`
+		UplinkSpeed      float64                       `json:"uplink_speed"`
+		DownlinkSpeed    float64                       `json:"downlink_speed"`
+		ActiveFlows      int                           `json:"active_flows"`
+		Wins             int                           `json:"wins"`
+		FreePorts        int                           `json:"free_ports"`
+		HealthyPorts     []int                         `json:"healthy_ports"`
+		Params           params                        `json:"params"`
+		PeerScoreMatrix  map[string]peerScoreView      `json:"peer_score_matrix"`
+		Transactions     map[string]transactionView    `json:"transactions"`
+		Iperf3ServerPort string                        `json:"iperf3_server_port"`
+		Timings          map[string]transactionTimings `json:"transaction_timings"`
 	}{
 		ID:               p.id,
 		Address:          p.address,
 		Price:            p.price,
+		Behaviour:        p.behaviour(),
 		UplinkSpeed:      p.uplinkSpeed,
 		DownlinkSpeed:    p.downlinkSpeed,
+		ActiveFlows:      p.activeFlowCount,
+		Wins:             p.wins,
+		FreePorts:        p.ports.Free(),
+		HealthyPorts:     p.ports.Healthy(),
 		Params:           p.params,
-		PeerScoreMatrix:  p.peerScoreMatrix,
-		Transactions:     p.transactions,
+		PeerScoreMatrix:  p.peerScoreViews(),
+		Transactions:     p.transactionViews(),
 		Iperf3ServerPort: p.iperf3BaseServerPort,
+		Timings:          p.transactionTimings(),
 	})
-
-	fmt.Println("provider stats:", string(jsonResponse))
+	p.mutex.Unlock()
 
 	if err != nil {
-		fmt.Printf("failed to marshal payload: %v\n", err)
+		log.Error("failed to marshal payload", logging.Err(err))
 		return
 	}
+	log.Debug("provider stats", "stats", string(jsonResponse))
+
 	if n, err := conn.Write(jsonResponse); err != nil {
-		fmt.Printf("failed to send PROVIDER_STATS from %s to address %s\n", p.id, conn.RemoteAddr().String())
+		log.Error("failed to send provider stats", logging.Err(err))
 		return
 	} else {
-		fmt.Println("n bytes written", n)
+		log.Info("sent provider stats", "bytes", n)
 		return
 	}
 }
 
+func (p *provider) handleUpdateProvider(payload updateProviderPayload) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Validate everything before applying anything
+	newParams := p.params
+	if len(payload.Params) > 0 {
+		// A misspelled key would otherwise change nothing and still succeed
+		decoder := json.NewDecoder(bytes.NewReader(payload.Params))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&newParams); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+		if err := newParams.validate(); err != nil {
+			return err
+		}
+	}
+
+	isFaulty := p.isFaulty
+	switch payload.Behaviour {
+	case "":
+	case behaviourHonest:
+		isFaulty = false
+	case behaviourFaulty:
+		isFaulty = true
+	default:
+		return fmt.Errorf("unknown behaviour profile: %s", payload.Behaviour)
+	}
+
+	newPrice := p.price
+	if payload.Price != nil {
+		newPrice = *payload.Price
+	}
+	if payload.PriceMultiplier != nil {
+		newPrice *= *payload.PriceMultiplier
+	}
+	// Held to the same bounds as the config file
+	problems := config.Problems{}
+	if payload.PriceMultiplier != nil {
+		problems.Positive("price_multiplier", *payload.PriceMultiplier)
+	}
+	problems.Positive("price", newPrice)
+	if err := problems.Err(); err != nil {
+		return err
+	}
+
+	p.log.Info("updating provider", logging.Event(events.UPDATE_PROVIDER),
+		"old_price", p.price, "new_price", newPrice,
+		"old_params", p.params, "new_params", newParams,
+		"old_faulty", p.isFaulty, "new_faulty", isFaulty)
+	p.price = newPrice
+	p.params = newParams
+	p.isFaulty = isFaulty
+
+	return nil
+}
+
+// behaviour returns the current behaviour profile, p.mutex must be held
+func (p *provider) behaviour() string {
+	if p.isFaulty {
+		return behaviourFaulty
+	}
+	return behaviourHonest
+}
+
 func (p *provider) getPeerAddressByID(transactionID string, peerID string) (string, error) {
 	transaction, exists := p.transactions[transactionID]
 	if !exists {
@@ -421,3 +688,27 @@ func (p *provider) getPeerAddressByID(transactionID string, peerID string) (stri
 	}
 	return "", fmt.Errorf("failed to find peer info: %s", peerID)
 }
+
+// transactionTimings are the phases this provider saw for a transaction, with
+// the latencies derived from them in ms
+type transactionTimings struct {
+	Timeline  []phases.Mark `json:"timeline"`
+	VoteRound float64       `json:"vote_round"` // BUY received to INFORM_VOTE sent
+	Flow      float64       `json:"flow"`       // START_FLOW received to TRANSACTION_END received
+	EndToEnd  float64       `json:"end_to_end"` // BUY received to TRANSACTION_END received
+}
+
+// transactionTimings must be called with p.mutex held
+func (p *provider) transactionTimings() map[string]transactionTimings {
+	timings := make(map[string]transactionTimings, len(p.transactions))
+	for transactionID, transaction := range p.transactions {
+		timeline := transaction.timeline
+		timings[transactionID] = transactionTimings{
+			Timeline:  timeline.Marks(),
+			VoteRound: timeline.Between(phases.BUY_RECEIVED, phases.INFORM_VOTE_SENT),
+			Flow:      timeline.Between(phases.START_FLOW_RECEIVED, phases.TRANSACTION_END_RECEIVED),
+			EndToEnd:  timeline.Between(phases.BUY_RECEIVED, phases.TRANSACTION_END_RECEIVED),
+		}
+	}
+	return timings
+}
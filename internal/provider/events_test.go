@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"math"
+	"testing"
+	"time"
+	"wifi-trade-consensus/internal/pkg/timeline"
+
+	"github.com/google/uuid"
+)
+
+// addLostTransaction adds a transaction whose flow the peer p1 won, its
+// feedback starts at 0.8
+func addLostTransaction(p *provider) uuid.UUID {
+	transactionID := uuid.New()
+	winner := peerInfo{ProviderID: "p1"}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.peerScoreMatrix[winner.ProviderID] = peerScore{consumerFeedback: 0.8, uplinkSpeed: 10, downlinkSpeed: 10}
+	p.transactions[transactionID.String()] = transaction{
+		transactionID:   transactionID,
+		params:          params{Gamma: 0.5},
+		transactionTime: time.Now().UnixMilli(),
+		peerList:        peers{{ProviderID: p.id}, winner},
+		winner:          winner,
+		flowStartTime:   time.Now().UnixMilli(),
+		timeline:        timeline.New(),
+	}
+	return transactionID
+}
+
+func TestTransactionEndUpdatesFeedback(t *testing.T) {
+	p := newTestProvider(t)
+	transactionID := addLostTransaction(p)
+
+	payload := transactionEndPayload{Rating: 0.2, UplinkSpeed: 4, DownlinkSpeed: 6}
+	payload.TransactionID = transactionID
+	p.handleTransactionEnd(payload)
+
+	score := p.peerScoreMatrix["p1"]
+	if math.Abs(score.consumerFeedback-0.5) > 1e-9 || score.uplinkSpeed != 4 || score.downlinkSpeed != 6 {
+		t.Errorf("peer score = %+v, want feedback 0.5 and the measured speeds", score)
+	}
+}
+
+func TestRefusedTransactionEndKeepsFeedback(t *testing.T) {
+	p := newTestProvider(t)
+	transactionID := addLostTransaction(p)
+
+	payload := transactionEndPayload{Refused: true}
+	payload.TransactionID = transactionID
+	p.handleTransactionEnd(payload)
+
+	score := p.peerScoreMatrix["p1"]
+	if score.consumerFeedback != 0.8 || score.uplinkSpeed != 10 || score.downlinkSpeed != 10 {
+		t.Errorf("peer score = %+v after a refusal, want it unchanged", score)
+	}
+	if transaction := p.transactions[transactionID.String()]; transaction.flowEndTime == 0 {
+		t.Errorf("refused transaction didn't end")
+	}
+}
+
+func TestUpdateProviderRejectsUnknownParams(t *testing.T) {
+	p := newAdminTestProvider(t, testAdminToken)
+
+	err := p.handleUpdateProvider(updateProviderPayload{Params: []byte(`{"gama": 0.5, "tau": 2}`)})
+	if err == nil {
+		t.Fatalf("update with a misspelled key succeeded")
+	}
+	if p.params.Gamma != 0 || p.params.Tau != 3 {
+		t.Errorf("params = %+v after a rejected update, want them unchanged", p.params)
+	}
+
+	if err := p.handleUpdateProvider(updateProviderPayload{Params: []byte(`{"gamma": 0.5}`)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if p.params.Gamma != 0.5 || p.params.Tau != 3 {
+		t.Errorf("params = %+v, want gamma 0.5 and the other params kept", p.params)
+	}
+}
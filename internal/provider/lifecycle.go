@@ -0,0 +1,219 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/metrics"
+	"wifi-trade-consensus/internal/pkg/shutdown"
+
+	"github.com/google/uuid"
+)
+
+// Shutdown stops taking new transactions, sends LEAVE to the peers and waits
+// for the transactions in flight until ctx is done. The listener is closed
+// afterwards and the archive, trace file and throughput servers are cleaned up
+func (p *provider) Shutdown(ctx context.Context) error {
+	p.draining.Store(true)
+	p.log.Info("shutting down, draining transactions")
+	p.sendLeave()
+
+	if inFlight, err := shutdown.Drain(ctx, p.inFlightCount); err != nil {
+		p.log.Warn("abandoning transactions in flight", "count", inFlight, logging.Err(err))
+	} else {
+		p.log.Info("drained transactions")
+	}
+
+	p.mutex.Lock()
+	listener := p.listener
+	p.mutex.Unlock()
+	if listener != nil {
+		if err := listener.Close(); err != nil {
+			p.log.Error("failed to close listener", logging.Err(err))
+		}
+	}
+	if handling, err := shutdown.Drain(ctx, func() int { return int(p.handlers.Load()) }); err != nil {
+		p.log.Warn("abandoning connections still being handled", "count", handling, logging.Err(err))
+	}
+
+	if p.adminServer != nil {
+		if err := p.adminServer.Close(); err != nil {
+			p.log.Error("failed to close admin server", logging.Err(err))
+		}
+	}
+	if err := p.metrics.registry.Close(); err != nil {
+		p.log.Error("failed to close metrics server", logging.Err(err))
+	}
+	if err := p.cleanup(); err != nil {
+		return fmt.Errorf("failed to cleanup: %w", err)
+	}
+	return nil
+}
+
+// staleTransactionTimeout bounds the age of a transaction still waited for on
+// shutdown when transaction_timeout is off
+const staleTransactionTimeout = 10 * time.Minute
+
+// inFlightCount counts the transactions that haven't received TRANSACTION_END
+// and may still get one. Those past transaction_timeout or whose port lease
+// expired are stuck, the sweeper would have expired them, and aren't waited for
+func (p *provider) inFlightCount() int {
+	timeout := staleTransactionTimeout
+	if p.retention.Timeout > 0 {
+		timeout = time.Duration(p.retention.Timeout) * time.Second
+	}
+	oldest := time.Now().Add(-timeout).UnixMilli()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	count := 0
+	for _, transaction := range p.transactions {
+		if transaction.flowEndTime == 0 && !transaction.leaseExpired && transaction.transactionTime > oldest {
+			count++
+		}
+	}
+	return count
+}
+
+// sendLeave tells the beacon peers and the peers of every transaction held
+// that this provider is going away
+func (p *provider) sendLeave() {
+	p.mutex.Lock()
+	addresses := map[string]bool{}
+	for _, peer := range p.beaconPeers {
+		addresses[peer.Address] = true
+	}
+	for _, transaction := range p.transactions {
+		for _, peer := range transaction.peerList {
+			if peer.ProviderID != p.id {
+				addresses[peer.Address] = true
+			}
+		}
+	}
+	p.mutex.Unlock()
+
+	sent := p.broadcastLeave(uuid.Nil, addresses)
+	p.log.Info("sent LEAVE", "peers", sent)
+}
+
+// refuseBuy answers a BUY received while shutting down, or one whose QoS
+// can't be scored, with a LEAVE for its transaction, so the consumer and the
+// other providers stop waiting for votes this provider will never cast
+func (p *provider) refuseBuy(payload buyPayload) {
+	addresses := map[string]bool{payload.OriginAddress: true}
+	for _, peer := range payload.PeerList {
+		if peer.ProviderID != p.id {
+			addresses[peer.Address] = true
+		}
+	}
+	sent := p.broadcastLeave(payload.TransactionID, addresses)
+	p.log.Info("refused BUY", logging.Transaction(payload.TransactionID), "peers", sent)
+}
+
+// broadcastLeave sends LEAVE to addresses, for a single transaction unless
+// transactionID is nil, and counts the peers reached
+func (p *provider) broadcastLeave(transactionID uuid.UUID, addresses map[string]bool) int {
+	jsonPayload, err := json.Marshal(PayloadMeta{
+		PayloadType:   events.LEAVE,
+		TransactionID: transactionID,
+		OriginID:      p.id,
+		OriginAddress: p.address,
+	})
+	if err != nil {
+		p.log.Error("failed to marshal payload", logging.Event(events.LEAVE), logging.Err(err))
+		return 0
+	}
+
+	wg := sync.WaitGroup{}
+	sent := atomic.Int32{}
+	for address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			conn, err := net.DialTimeout("tcp", address, shutdown.LeaveTimeout)
+			if err != nil {
+				p.metrics.sent(events.LEAVE, metrics.OutcomeDialFailed)
+				p.log.Debug("failed to send LEAVE", "address", address, logging.Err(err))
+				return
+			}
+			defer conn.Close()
+
+			if _, err := conn.Write(jsonPayload); err != nil {
+				p.metrics.sent(events.LEAVE, metrics.OutcomeSendFailed)
+				p.log.Debug("failed to send LEAVE", "address", address, logging.Err(err))
+				return
+			}
+			p.metrics.sent(events.LEAVE, metrics.OutcomeSent)
+			sent.Add(1)
+		}(address)
+	}
+	wg.Wait()
+	return int(sent.Load())
+}
+
+// handleLeave forgets the peer score of a node that is shutting down, it is
+// scored from scratch if it comes back. A LEAVE for a transaction only drops
+// the node from that transaction
+func (p *provider) handleLeave(payload PayloadMeta) {
+	if payload.TransactionID != uuid.Nil {
+		p.leaveTransaction(payload)
+		return
+	}
+
+	p.mutex.Lock()
+	_, known := p.peerScoreMatrix[payload.OriginID]
+	delete(p.peerScoreMatrix, payload.OriginID)
+	p.mutex.Unlock()
+
+	if known {
+		p.log.Info("peer left", logging.Event(events.LEAVE), logging.KeyPeer, payload.OriginID)
+	}
+}
+
+// leaveTransaction removes a provider that refused the BUY from the peers of
+// the transaction, the vote round goes on without it. The BUY may still be on
+// its way here, so a missing transaction is waited for like in REQUEST_VOTE
+func (p *provider) leaveTransaction(payload PayloadMeta) {
+	log := p.log.With(logging.Event(events.LEAVE), logging.Transaction(payload.TransactionID),
+		logging.KeyPeer, payload.OriginID)
+	transactionID := payload.TransactionID.String()
+
+	removed := false
+	for retryCount := 0; retryCount < 5; retryCount++ {
+		p.mutex.Lock()
+		transaction, exists := p.transactions[transactionID]
+		if exists {
+			peerList := peers{}
+			for _, peer := range transaction.peerList {
+				if peer.ProviderID != payload.OriginID {
+					peerList = append(peerList, peer)
+				}
+			}
+			removed = len(peerList) < len(transaction.peerList)
+			transaction.peerList = peerList
+			transaction.peerCount = len(peerList)
+			delete(transaction.allFFS, payload.OriginID)
+			p.transactions[transactionID] = transaction
+		}
+		p.mutex.Unlock()
+		if exists {
+			break
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	if !removed {
+		log.Warn("peer left a transaction it isn't part of")
+		return
+	}
+
+	log.Info("peer left the transaction")
+	// The votes of the remaining peers may all be in already
+	p.sendInformVote(payload.TransactionID, log)
+}
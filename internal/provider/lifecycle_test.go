@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+
+	"github.com/google/uuid"
+)
+
+func newTestProvider(t *testing.T) *provider {
+	t.Helper()
+	return New(options{
+		ID:                   "p0",
+		Address:              "127.0.0.1:0",
+		Iperf3BaseServerPort: "5300",
+		Iperf3ServerCount:    2,
+		Logging:              logging.Options{Level: "error"},
+	})
+}
+
+// addTransaction adds a transaction in flight, its flow hasn't ended
+func addTransaction(p *provider) string {
+	transactionID := uuid.New()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.transactions[transactionID.String()] = transaction{
+		transactionID:   transactionID,
+		transactionTime: time.Now().UnixMilli(),
+	}
+	return transactionID.String()
+}
+
+func TestShutdownWaitsForTransactionsInFlight(t *testing.T) {
+	p := newTestProvider(t)
+	transactionID := addTransaction(p)
+
+	const flowTime = 300 * time.Millisecond
+	go func() {
+		time.Sleep(flowTime)
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		transaction := p.transactions[transactionID]
+		transaction.flowEndTime = time.Now().UnixMilli()
+		p.transactions[transactionID] = transaction
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if !p.draining.Load() {
+		t.Errorf("provider isn't draining after Shutdown")
+	}
+	if elapsed < flowTime {
+		t.Errorf("Shutdown returned after %v, before the transaction ended", elapsed)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("Shutdown waited for the deadline although the transaction ended")
+	}
+}
+
+func TestShutdownAbandonsTransactionsAtDeadline(t *testing.T) {
+	p := newTestProvider(t)
+	addTransaction(p)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if ctx.Err() == nil {
+		t.Errorf("Shutdown returned before the deadline with a transaction in flight")
+	}
+	if count := p.inFlightCount(); count != 1 {
+		t.Errorf("inFlightCount = %d, want the abandoned transaction", count)
+	}
+}
+
+func TestInFlightCountSkipsStaleTransactions(t *testing.T) {
+	p := newTestProvider(t)
+	p.retention.Timeout = 60
+	addTransaction(p)
+	timedOut := addTransaction(p)
+	leaseExpired := addTransaction(p)
+	ended := addTransaction(p)
+
+	p.mutex.Lock()
+	transaction := p.transactions[timedOut]
+	transaction.transactionTime = time.Now().Add(-2 * time.Minute).UnixMilli()
+	p.transactions[timedOut] = transaction
+	transaction = p.transactions[leaseExpired]
+	transaction.leaseExpired = true
+	p.transactions[leaseExpired] = transaction
+	transaction = p.transactions[ended]
+	transaction.flowEndTime = time.Now().UnixMilli()
+	p.transactions[ended] = transaction
+	p.mutex.Unlock()
+
+	if count := p.inFlightCount(); count != 1 {
+		t.Errorf("inFlightCount = %d, want only the transaction still in flight", count)
+	}
+}
+
+func TestShutdownWithoutTransactions(t *testing.T) {
+	p := newTestProvider(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := p.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v with nothing in flight", elapsed)
+	}
+}
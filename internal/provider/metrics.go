@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/metrics"
+)
+
+type providerMetrics struct {
+	registry          *metrics.Registry
+	messagesReceived  *metrics.Counter   // event
+	messagesSent      *metrics.Counter   // event, outcome
+	dialFailures      *metrics.Counter   // event
+	voteRoundDuration *metrics.Histogram // BUY received to INFORM_VOTE sent
+	transactions      *metrics.Counter   // state
+	activeFlows       *metrics.Gauge
+	freePorts         *metrics.Gauge
+	healthyPorts      *metrics.Gauge
+	price             *metrics.Gauge
+	peerScore         *metrics.Gauge // peer, component
+}
+
+func newProviderMetrics(id string) *providerMetrics {
+	registry := metrics.NewRegistry(id)
+	return &providerMetrics{
+		registry: registry,
+		messagesReceived: registry.Counter("wifi_trade_messages_received_total",
+			"Messages received by event type.", "event"),
+		messagesSent: registry.Counter("wifi_trade_messages_sent_total",
+			"Messages sent by event type and outcome.", "event", "outcome"),
+		dialFailures: registry.Counter("wifi_trade_dial_failures_total",
+			"Failed dials by the event type being sent.", "event"),
+		voteRoundDuration: registry.Histogram("wifi_trade_vote_round_duration_seconds",
+			"Time from BUY received to INFORM_VOTE sent.", metrics.DurationBuckets),
+		transactions: registry.Counter("wifi_trade_transactions_total",
+			"Transactions by final state, won or lost by this provider.", "state"),
+		activeFlows: registry.Gauge("wifi_trade_active_flows",
+			"Flows currently served by this provider."),
+		freePorts: registry.Gauge("wifi_trade_free_ports",
+			"Data-plane ports this provider can still lease to flows."),
+		healthyPorts: registry.Gauge("wifi_trade_healthy_ports",
+			"Data-plane ports whose servers passed their health check."),
+		price: registry.Gauge("wifi_trade_price",
+			"Current price of this provider."),
+		peerScore: registry.Gauge("wifi_trade_peer_score",
+			"Peer score components this provider holds for every peer.", "peer", "component"),
+	}
+}
+
+func (m *providerMetrics) received(event int) {
+	m.messagesReceived.Inc(events.Name(event))
+}
+
+// sent records the outcome of an outbound message, a dial failure is also
+// counted on its own
+func (m *providerMetrics) sent(event int, outcome string) {
+	m.messagesSent.Inc(events.Name(event), outcome)
+	if outcome == metrics.OutcomeDialFailed {
+		m.dialFailures.Inc(events.Name(event))
+	}
+}
+
+// collectMetrics copies the provider state into the gauges before every scrape
+func (p *provider) collectMetrics() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.metrics.activeFlows.Set(float64(p.activeFlowCount))
+	p.metrics.freePorts.Set(float64(p.ports.Free()))
+	p.metrics.healthyPorts.Set(float64(len(p.ports.Healthy())))
+	p.metrics.price.Set(p.price)
+
+	p.metrics.peerScore.Reset()
+	for peerID, score := range p.peerScoreMatrix {
+		p.metrics.peerScore.Set(score.uptime, peerID, "uptime")
+		p.metrics.peerScore.Set(score.load, peerID, "load")
+		p.metrics.peerScore.Set(score.signalStrength, peerID, "signal_strength")
+		p.metrics.peerScore.Set(score.uplinkSpeed, peerID, "uplink_speed")
+		p.metrics.peerScore.Set(score.downlinkSpeed, peerID, "downlink_speed")
+		p.metrics.peerScore.Set(score.lastPrice, peerID, "last_price")
+		p.metrics.peerScore.Set(score.consumerFeedback, peerID, "consumer_feedback")
+	}
+}
+
+// NewMetricsServer serves the metrics at metrics_address, nothing is served
+// when it isn't set
+func (p *provider) NewMetricsServer() error {
+	if p.metricsAddress == "" {
+		return nil
+	}
+	p.metrics.registry.OnScrape(p.collectMetrics)
+	return p.metrics.registry.Serve(p.metricsAddress)
+}
@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/logging"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// validate checks the params against the ranges documented on the struct
+func (params params) validate() error {
+	problems := config.Problems{}
+	problems.Positive("beacon_t_limit", float64(params.BeaconTLimit))
+	problems.Open("k_uptime", params.KUptime, 0, 1)
+	problems.Open("k_load", params.KLoad, 0, 1)
+	problems.Open("k_strength", params.KStrength, 0, 1)
+	problems.Open("gamma", params.Gamma, 0, 1)
+	problems.Positive("tau", params.Tau)
+	problems.Open("default_peer_ff", params.DefaultPeerFF, -1, 1)
+
+	if err := problems.Err(); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+	return nil
+}
+
+// WatchConfig reloads the params whenever the config file of source changes,
+// they are applied like an UPDATE_PROVIDER event. Other keys still need a
+// restart
+func (p *provider) WatchConfig(source config.Source) {
+	watcher := viper.New()
+	watcher.SetConfigFile(source.Path)
+	watcher.SetConfigType("json")
+	if err := watcher.ReadInConfig(); err != nil {
+		p.log.Error("failed to watch config file", "path", source.Path, logging.Err(err))
+		return
+	}
+
+	watcher.OnConfigChange(func(event fsnotify.Event) {
+		settings, err := source.Load()
+		if err != nil {
+			p.log.Error("failed to reload params, keeping the current ones", "path", event.Name, logging.Err(err))
+			return
+		}
+		options, err := NewOptionsFromConfig(settings)
+		if err != nil {
+			p.log.Error("failed to reload params, keeping the current ones", "path", event.Name, logging.Err(err))
+			return
+		}
+		newParams := options.Params
+
+		p.mutex.Lock()
+		unchanged := newParams == p.params
+		p.mutex.Unlock()
+		if unchanged {
+			return
+		}
+
+		jsonParams, err := json.Marshal(newParams)
+		if err != nil {
+			p.log.Error("failed to marshal params", logging.Err(err))
+			return
+		}
+		if err := p.handleUpdateProvider(updateProviderPayload{Params: jsonParams}); err != nil {
+			p.log.Error("failed to reload params, keeping the current ones", "path", event.Name, logging.Err(err))
+		}
+	})
+	watcher.WatchConfig()
+	p.log.Info("watching config file for params changes", "path", source.Path)
+}
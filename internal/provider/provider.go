@@ -1,23 +1,29 @@
 package provider
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
-	"os/exec"
-	"os/signal"
 	"strconv"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 	"wifi-trade-consensus/internal/pkg/events"
-	"wifi-trade-consensus/internal/pkg/iperf3"
+	"wifi-trade-consensus/internal/pkg/logging"
 	"wifi-trade-consensus/internal/pkg/payload"
+	"wifi-trade-consensus/internal/pkg/ports"
+	"wifi-trade-consensus/internal/pkg/retention"
+	"wifi-trade-consensus/internal/pkg/throughput"
+	phases "wifi-trade-consensus/internal/pkg/timeline"
+	"wifi-trade-consensus/internal/pkg/tracing"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 )
 
 type PayloadMeta = payload.Meta
@@ -29,12 +35,16 @@ type beaconPayload struct {
 }
 
 type customerQOS struct {
-	PriceConsumer         float64 `json:"price"`    // consumer price requirement
-	UplinkSpeedConsumer   float64 `json:"uplink"`   // consumer uplink speed requirement
-	DownlinkSpeedConsumer float64 `json:"downlink"` // consumer downlink speed requirement
-	Mu                    float64 `json:"mu"`       // uplink weight
-	Delta                 float64 `json:"delta"`    // downlink weight
-	Epsilon               float64 `json:"epsilon"`  // price range multiplier limit
+	PriceConsumer         float64 `json:"price"`                // consumer price requirement
+	UplinkSpeedConsumer   float64 `json:"uplink"`               // consumer uplink speed requirement
+	DownlinkSpeedConsumer float64 `json:"downlink"`             // consumer downlink speed requirement
+	Mu                    float64 `json:"mu"`                   // uplink weight
+	Delta                 float64 `json:"delta"`                // downlink weight
+	Epsilon               float64 `json:"epsilon"`              // price range multiplier limit
+	Protocol              string  `json:"protocol,omitempty"`   // tcp (default) or udp
+	MaxJitter             float64 `json:"max_jitter,omitempty"` // ms, udp only
+	MaxLoss               float64 `json:"max_loss,omitempty"`   // percent, udp only
+	MaxRTT                float64 `json:"max_rtt,omitempty"`    // ms
 }
 
 type buyPayload struct {
@@ -55,9 +65,19 @@ type FFS map[string]float64 // index: provider id
 
 type transactionEndPayload struct {
 	PayloadMeta
-	Rating        float64 `json:"rating"`
-	UplinkSpeed   float64 `json:"uplink_speed"`
-	DownlinkSpeed float64 `json:"downlink_speed"`
+	Rating          float64                `json:"rating"`
+	RatingBreakdown map[string]ratingScore `json:"rating_breakdown,omitempty"` // index: dimension
+	UplinkSpeed     float64                `json:"uplink_speed"`
+	DownlinkSpeed   float64                `json:"downlink_speed"`
+	Refused         bool                   `json:"refused,omitempty"` // the winner refused the flow, which isn't rated
+}
+
+// ratingScore is one dimension of the consumer rating
+type ratingScore struct {
+	Actual   float64 `json:"actual"`
+	Required float64 `json:"required"`
+	Score    float64 `json:"score"` // 0 to 1
+	Weight   float64 `json:"weight"`
 }
 
 type startFlowPayload struct {
@@ -87,12 +107,16 @@ type peerInfo struct {
 	Address              string `json:"address"`
 	Iperf3BaseServerPort string `json:"iperf3_base_server_port"`
 	Iperf3ServerCount    int    `json:"iperf3_server_count"`
+	ThroughputEngine     string `json:"throughput_engine,omitempty"` // engine of the servers, iperf3 if empty
+	Saturated            bool   `json:"saturated,omitempty"`         // no ports free for another flow
+	HealthyPorts         []int  `json:"healthy_ports,omitempty"`     // ports whose servers passed their health check
 }
 
 type peers []peerInfo
 
 type transaction struct {
 	transactionID   uuid.UUID
+	params          params // snapshot taken at BUY
 	transactionTime int64
 	consumerID      string
 	consumerAddress string
@@ -100,22 +124,65 @@ type transaction struct {
 	peerCount       int
 	allFFS          allFFS
 	customerQOS     customerQOS
+	peerPrices      map[string]float64 // index: provider id, prices quoted in REQUEST_VOTE
+	informed        bool               // INFORM_VOTE was sent
 	// Flow details
-	winner        peerInfo
-	flowStartTime int
-	flowEndTime   int
+	winner          peerInfo
+	flowStartTime   int64 // unix ms
+	flowEndTime     int64
+	ports           []int   // data-plane ports leased to the flow, only set on the winner
+	leaseExpired    bool    // the ports were released after port_lease_timeout
+	rating          float64 // consumer rating and measured speeds, from TRANSACTION_END
+	ratingBreakdown map[string]ratingScore
+	uplinkSpeed     float64
+	downlinkSpeed   float64
+	timeline        *phases.Timeline
+	voteSpan        *tracing.Span // BUY received to INFORM_VOTE sent
 }
 
 type transactions map[string]transaction
 
 type params struct {
-	BeaconTLimit  int64   `mapstructure:"beacon_t_limit"`  // 0 < beaconTLimit (ms) < 1000
-	KUptime       float64 `mapstructure:"k_uptime"`        // 0 < kUptime < 1
-	KLoad         float64 `mapstructure:"k_load"`          // 0 < kLoad < 1
-	KStrength     float64 `mapstructure:"k_strength"`      // 0 < kStrength < 1
-	Tau           float64 `mapstructure:"tau"`             // z-score threshold
-	Gamma         float64 `mapstructure:"gamma"`           // 0 < gamma < 1
-	DefaultPeerFF float64 `mapstructure:"default_peer_ff"` // -1 < defaultPeerFF < 1
+	BeaconTLimit  int64   `mapstructure:"beacon_t_limit" json:"beacon_t_limit"`   // 0 < beaconTLimit (ms), gap between beacons that restarts uptime
+	KUptime       float64 `mapstructure:"k_uptime" json:"k_uptime"`               // 0 < kUptime < 1
+	KLoad         float64 `mapstructure:"k_load" json:"k_load"`                   // 0 < kLoad < 1
+	KStrength     float64 `mapstructure:"k_strength" json:"k_strength"`           // 0 < kStrength < 1
+	Tau           float64 `mapstructure:"tau" json:"tau"`                         // z-score threshold
+	Gamma         float64 `mapstructure:"gamma" json:"gamma"`                     // 0 < gamma < 1
+	DefaultPeerFF float64 `mapstructure:"default_peer_ff" json:"default_peer_ff"` // -1 < defaultPeerFF < 1
+}
+
+// Behaviour profiles, a faulty provider randomizes its price, beacons and votes
+const (
+	behaviourHonest = "honest"
+	behaviourFaulty = "faulty"
+)
+
+// portsPerFlow are leased to a flow, its uplink and downlink run at the same
+// time and an iperf3 server serves one test at a time
+const portsPerFlow = 2
+
+// startFlowReply answers the START_FLOW of the consumer on the same
+// connection, only the winner replies
+type startFlowReply struct {
+	Ports []int  `json:"ports"`           // leased data-plane ports, uplink then downlink
+	Error string `json:"error,omitempty"` // why none were leased
+}
+
+// updateProviderReply answers an UPDATE_PROVIDER on the same connection
+type updateProviderReply struct {
+	Error string `json:"error,omitempty"` // why the update was rejected, empty if it was applied
+}
+
+// updateProviderPayload changes a provider at runtime, only the fields that
+// are set are applied
+type updateProviderPayload struct {
+	PayloadMeta
+	Price           *float64        `json:"price,omitempty"`
+	PriceMultiplier *float64        `json:"price_multiplier,omitempty"`
+	Params          json.RawMessage `json:"params,omitempty"` // partial params, keyed like the config file
+	Behaviour       string          `json:"behaviour,omitempty"`
+	AdminToken      string          `json:"admin_token,omitempty"` // required over TCP, like for the admin API writes
 }
 
 type options struct {
@@ -123,15 +190,26 @@ type options struct {
 	Address              string  `mapstructure:"address"`
 	Iperf3BaseServerPort string  `mapstructure:"iperf3_base_server_port"`
 	Iperf3ServerCount    int     `mapstructure:"iperf3_server_count"`
+	ThroughputEngine     string  `mapstructure:"throughput_engine"` // iperf3 (default) or native
 	Price                float64 `mapstructure:"price"`
 	UplinkSpeed          float64 `mapstructure:"uplink_speed"`
 	DownlinkSpeed        float64 `mapstructure:"downlink_speed"`
-	Params               params  `mapstructure:"params"`
+	Params               params  `mapstructure:",squash"`
 	// peer-score default values
-	DefaultPeerUplinkSpeed      float64 `mapstructure:"default_peer_uplink_speed"`
-	DefaultPeerDownlinkSpeed    float64 `mapstructure:"default_peer_downlink_speed"`
-	DefaultPeerLastPrice        float64 `mapstructure:"default_peer_last_price"`
-	DefaultPeerConsumerFeedback float64 `mapstructure:"default_peer_consumer_feedback"`
+	DefaultPeerUplinkSpeed      float64                  `mapstructure:"default_peer_uplink_speed"`
+	DefaultPeerDownlinkSpeed    float64                  `mapstructure:"default_peer_downlink_speed"`
+	DefaultPeerLastPrice        float64                  `mapstructure:"default_peer_last_price"`
+	DefaultPeerConsumerFeedback float64                  `mapstructure:"default_peer_consumer_feedback"`
+	MetricsAddress              string                   `mapstructure:"metrics_address"`    // serve prometheus metrics, optional
+	TracePath                   string                   `mapstructure:"trace_path"`         // export spans to this file, optional
+	AdminAddress                string                   `mapstructure:"admin_address"`      // serve the admin API, optional
+	AdminToken                  string                   `mapstructure:"admin_token"`        // bearer token of the admin write endpoints
+	ArchivePath                 string                   `mapstructure:"archive_path"`       // append evicted transactions to this file, optional
+	DrainTimeout                int                      `mapstructure:"drain_timeout"`      // s the transactions in flight get to finish on shutdown
+	PortLeaseTimeout            int                      `mapstructure:"port_lease_timeout"` // s before the ports of a flow that never ended are leased again, 0 never
+	Servers                     throughput.ServerOptions `mapstructure:",squash"`
+	Retention                   retention.Options        `mapstructure:",squash"`
+	Logging                     logging.Options          `mapstructure:",squash"`
 }
 
 type provider struct {
@@ -145,9 +223,13 @@ type provider struct {
 	transactions         transactions
 	iperf3BaseServerPort string
 	iperf3ServerCount    int
-	iperf3Cmds           []*exec.Cmd
+	engine               throughput.Engine
+	servers              []throughput.Server
+	serverOptions        throughput.ServerOptions
+	ports                *ports.Pool // leases the servers to flows
 	mutex                sync.Mutex
 	activeFlowCount      int
+	wins                 int // flows served since start, evicted transactions included
 	// peer-score default values
 	defaultPeerUplinkSpeed      float64
 	defaultPeerDownlinkSpeed    float64
@@ -156,6 +238,24 @@ type provider struct {
 	// Beacon attributes
 	channelUtilizationRate int // 0-255
 	isFaulty               bool
+	// Observability
+	metricsAddress string
+	metrics        *providerMetrics
+	log            *slog.Logger
+	tracePath      string
+	tracer         *tracing.Tracer
+	adminAddress   string
+	adminToken     string
+	adminServer    *http.Server
+	// Lifecycle
+	listener    net.Listener
+	handlers    atomic.Int32 // connections being handled
+	draining    atomic.Bool  // set once Shutdown starts, new transactions are turned away
+	beaconPeers peers        // told about the shutdown with LEAVE
+	// Transaction retention
+	retention   retention.Options
+	archivePath string
+	archive     *archive
 }
 
 // func NewParamsFromConfig() (*params, error) {
@@ -183,46 +283,13 @@ type provider struct {
 // 	return &params, nil
 // }
 
-func NewParamsOptionsFromConfigFile() (*options, error) {
-	params := params{}
-	options := options{}
-
-	// Name of config file (without extension)
-	// Get docker container's env variable
-	if nodeNum := os.Getenv("node_num"); nodeNum != "" {
-		viper.SetConfigName("config" + nodeNum)
-	} else {
-		viper.SetConfigName("config")
-	}
-
-	viper.SetConfigType("json") // REQUIRED if the config file does not have the extension in the name
-	viper.AddConfigPath(".")    // Path to look for the config file in
-	viper.AddConfigPath("cmd/provider")
-
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// config not found, ignore err
-			return nil, fmt.Errorf("config file not found")
-		} else {
-			// other errors, ignore err
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
-	}
-
-	err := viper.Unmarshal(&params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal params config file: %w", err)
-	}
-
-	err = viper.Unmarshal(&options)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal options config file: %w", err)
+// LogValue keeps the admin token out of the logs
+func (o options) LogValue() slog.Value {
+	if o.AdminToken != "" {
+		o.AdminToken = "REDACTED"
 	}
-
-	options.Params = params
-
-	return &options, nil
-
+	type plain options // without the LogValue method
+	return slog.AnyValue(plain(o))
 }
 
 func NewParams(beaconTLimit int64, kUptime float64, kLoad float64, kStrength float64, tau float64, defaultPeerFF float64) params {
@@ -246,15 +313,34 @@ func NewOptions(address string, price float64, uplinkSpeed float64, downlinkSpee
 	}
 }
 
-func New(opt options) provider {
+func New(opt options) *provider {
+	logger, err := logging.New(opt.ID, opt.Logging)
+	if err != nil {
+		logger, _ = logging.New(opt.ID, logging.Options{})
+		logger.Warn("falling back to default logging", logging.Err(err))
+	}
+
 	val := os.Getenv("is_faulty")
 	isFaulty, err := strconv.ParseBool(val)
 	if err != nil {
-		fmt.Println("failed to parse environment variable is_faulty:", err)
+		logger.Warn("failed to parse environment variable is_faulty", logging.Err(err))
 		isFaulty = false
 	}
 
-	provider := provider{
+	engine, err := throughput.New(opt.ThroughputEngine)
+	if err != nil {
+		engine, _ = throughput.New(throughput.EngineIperf3)
+		logger.Warn("falling back to the iperf3 engine", logging.Err(err))
+	}
+
+	pool, err := ports.NewPool(opt.Iperf3BaseServerPort, opt.Iperf3ServerCount,
+		time.Duration(opt.PortLeaseTimeout)*time.Second)
+	if err != nil {
+		pool, _ = ports.NewPool("0", 0, 0)
+		logger.Error("failed to create port pool, no flow will be served", logging.Err(err))
+	}
+
+	provider := &provider{
 		id:                   opt.ID,
 		address:              opt.Address,
 		price:                opt.Price,
@@ -265,6 +351,9 @@ func New(opt options) provider {
 		transactions:         make(transactions),
 		iperf3BaseServerPort: opt.Iperf3BaseServerPort,
 		iperf3ServerCount:    opt.Iperf3ServerCount,
+		engine:               engine,
+		serverOptions:        opt.Servers,
+		ports:                pool,
 		activeFlowCount:      0,
 		// peer-score default values
 		defaultPeerUplinkSpeed:      opt.DefaultPeerUplinkSpeed,
@@ -272,54 +361,76 @@ func New(opt options) provider {
 		defaultPeerLastPrice:        opt.DefaultPeerLastPrice,
 		defaultPeerConsumerFeedback: opt.DefaultPeerConsumerFeedback,
 		isFaulty:                    isFaulty,
+		metricsAddress:              opt.MetricsAddress,
+		metrics:                     newProviderMetrics(opt.ID),
+		log:                         logger,
+		tracePath:                   opt.TracePath,
+		adminAddress:                opt.AdminAddress,
+		adminToken:                  opt.AdminToken,
+		retention:                   opt.Retention,
+		archivePath:                 opt.ArchivePath,
 	}
 
-	// Register cleanup for interrupt signal i.e. Ctrl^c
-	channel := make(chan os.Signal)
-	signal.Notify(channel, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-channel
-		if err := provider.cleanup(); err != nil {
-			fmt.Println("failed to cleanup:", err)
-		}
-		os.Exit(1)
-	}()
-
 	return provider
 }
 
-// Creates a new listener, this is a blocking function so wrapping the function
-// call in a goroutine is required.
-func (p *provider) NewListener() error {
+// Logger is the logger of the provider, tagged with its id
+func (p *provider) Logger() *slog.Logger {
+	return p.log
+}
+
+// Creates a new listener and handles its connections until ctx is cancelled,
+// this is a blocking function so wrapping the function call in a goroutine is
+// required. The listener stays open for the transactions in flight until
+// Shutdown
+func (p *provider) NewListener(ctx context.Context) error {
 	l, err := net.Listen("tcp", p.address)
 	if err != nil {
 		return fmt.Errorf("failed to listen tcp address: %w", err)
 	}
-	defer l.Close()
+	p.mutex.Lock()
+	p.listener = l
+	p.mutex.Unlock()
+	p.log.Info("listening for new connections", "address", p.address)
+
+	go p.accept(l)
+	<-ctx.Done()
+	return nil
+}
 
+// accept handles the connections of l until it is closed
+func (p *provider) accept(l net.Listener) {
 	for {
 		// Wait for a connection
-		fmt.Println("listening for new connection at", p.address)
 		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
-			fmt.Println("failed to accept new connection:", err)
+			p.log.Error("failed to accept new connection", logging.Err(err))
+			continue
 		}
 		// Concurrently handle the new connections
+		p.handlers.Add(1)
 		go func(conn net.Conn) {
+			defer p.handlers.Add(-1)
 			defer conn.Close()
 
+			remote := slog.String("remote", conn.RemoteAddr().String())
 			data, err := io.ReadAll(conn)
 			if err != nil {
-				fmt.Println("failed to read connection data:", err)
+				p.log.Error("failed to read connection data", remote, logging.Err(err))
 			}
 
 			payloadMeta := payload.Meta{}
 			err = json.Unmarshal(data, &payloadMeta)
 			if err != nil {
-				fmt.Printf("failed to unmarshal payload meta from %s: %v\n", conn.RemoteAddr().String(), err)
+				p.log.Error("failed to unmarshal payload meta", remote, logging.Err(err))
 				return
 			}
-			// fmt.Printf("received payload meta from %s: %v\n", conn.RemoteAddr(), payloadMeta)
+			log := p.log.With(logging.Event(payloadMeta.PayloadType), logging.Transaction(payloadMeta.TransactionID),
+				logging.KeyPeer, payloadMeta.OriginID)
+			p.metrics.received(payloadMeta.PayloadType)
 
 			switch payloadMeta.PayloadType {
 
@@ -327,94 +438,177 @@ func (p *provider) NewListener() error {
 			case events.BEACON:
 				beaconPayload := beaconPayload{}
 				if err := json.Unmarshal(data, &beaconPayload); err != nil {
-					fmt.Printf("failed to unmarshal BEACON payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
 					return
 				}
-				// fmt.Printf("received BEACON payload from %s: %v\n", conn.RemoteAddr().String(), beaconPayload)
+				log.Debug("received payload", "payload", beaconPayload)
 				p.handleBeaconPayload(beaconPayload)
 
-			// Handle BUY event
+			// Handle BUY event, new transactions are refused while shutting down
 			case events.BUY:
 				buyPayload := buyPayload{}
 				if err := json.Unmarshal(data, &buyPayload); err != nil {
-					fmt.Printf("failed to unmarshal BUY payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
+					return
+				}
+				if p.draining.Load() {
+					log.Warn("shutting down, refusing payload", remote)
+					p.refuseBuy(buyPayload)
 					return
 				}
-				fmt.Printf("received BUY payload from %s: %v\n", conn.RemoteAddr().String(), buyPayload)
+				if err := buyPayload.customerQOS.validate(); err != nil {
+					log.Warn("invalid QoS requirements, refusing payload", remote, logging.Err(err))
+					p.refuseBuy(buyPayload)
+					return
+				}
+				log.Info("received payload", "payload", buyPayload)
 				p.handleBuyEvent(buyPayload)
 
 			// Handle REQUEST_VOTE event
 			case events.REQUEST_VOTE:
 				requestVotePayload := requestVotePayload{}
 				if err := json.Unmarshal(data, &requestVotePayload); err != nil {
-					fmt.Printf("failed to unmarshal REQUEST_VOTE payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
 					return
 				}
-				fmt.Printf("received REQUEST_VOTE payload from %s: %v\n", conn.RemoteAddr().String(), requestVotePayload)
+				log.Info("received payload", "payload", requestVotePayload)
 				p.handleRequestVote(requestVotePayload)
 
 			// Handle REPLY_VOTE event
 			case events.REPLY_VOTE:
 				replyVotePayload := replyVotePayload{}
 				if err := json.Unmarshal(data, &replyVotePayload); err != nil {
-					fmt.Printf("failed to unmarshal REPLY_VOTE payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
 					return
 				}
-				fmt.Printf("received REPLY_VOTE payload from %s: %v\n", conn.RemoteAddr().String(), replyVotePayload)
+				log.Info("received payload", "payload", replyVotePayload)
 				p.handleReplyVote(replyVotePayload)
 
 			// Handle START_FLOW event
 			case events.START_FLOW:
 				startFlowPayload := startFlowPayload{}
 				if err := json.Unmarshal(data, &startFlowPayload); err != nil {
-					fmt.Printf("failed to unmarshal START_FLOW payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
 					return
 				}
-				fmt.Printf("received START_FLOW payload from %s: %v\n", conn.RemoteAddr().String(), startFlowPayload)
-				p.handleStartFlow(startFlowPayload)
+				log.Info("received payload", "payload", startFlowPayload)
+				p.handleStartFlow(startFlowPayload, conn)
 
 			// Handle TRANSACTION_END event
 			case events.TRANSACTION_END:
 				transactionEndPayload := transactionEndPayload{}
 				if err := json.Unmarshal(data, &transactionEndPayload); err != nil {
-					fmt.Printf("failed to unmarshal TRANSACTION_END payload from %s: %v\n", conn.RemoteAddr().String(), err)
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
 					return
 				}
-				fmt.Printf("received TRANSACTION_END payload from %s: %v\n", conn.RemoteAddr().String(), transactionEndPayload)
+				log.Info("received payload", "payload", transactionEndPayload)
 				p.handleTransactionEnd(transactionEndPayload)
 
 			// Handle GET_PROVIDER_STATS debug event
 			case events.GET_PROVIDER_STATS:
-				fmt.Printf("received GET_PROVIDER_STATS from %s\n", conn.RemoteAddr().String())
+				log.Info("received stats request", remote)
 				p.handleGetProviderStats(conn)
 
+			// Handle UPDATE_PROVIDER event
+			case events.UPDATE_PROVIDER:
+				updateProviderPayload := updateProviderPayload{}
+				if err := json.Unmarshal(data, &updateProviderPayload); err != nil {
+					log.Error("failed to unmarshal payload", remote, logging.Err(err))
+					return
+				}
+				// The sender reads whether the update was applied
+				reply := updateProviderReply{}
+				if err := p.checkAdminToken(updateProviderPayload.AdminToken); err != nil {
+					log.Warn("rejecting payload", remote, logging.Err(err))
+					reply.Error = err.Error()
+				} else {
+					updateProviderPayload.AdminToken = ""
+					redacted, _ := json.Marshal(updateProviderPayload)
+					log.Info("received payload", "payload", string(redacted))
+					if err := p.handleUpdateProvider(updateProviderPayload); err != nil {
+						log.Error("failed to update provider", logging.Err(err))
+						reply.Error = err.Error()
+					}
+				}
+				jsonReply, _ := json.Marshal(reply)
+				if _, err := conn.Write(jsonReply); err != nil {
+					log.Error("failed to send UPDATE_PROVIDER reply", logging.Err(err))
+				}
+
+			// Handle LEAVE event
+			case events.LEAVE:
+				log.Info("received payload", "payload", payloadMeta)
+				p.handleLeave(payloadMeta)
+
 			// Handle unknown events
 			default:
-				fmt.Println("failed to determine event type:", payloadMeta)
+				log.Error("failed to determine event type", remote, "payload_type", payloadMeta.PayloadType)
 				return
 			}
 		}(conn)
 	}
 }
 
-func (p *provider) NewIperf3Server() error {
-	cmds, err := iperf3.StartServers(p.iperf3BaseServerPort, p.iperf3ServerCount)
+// NewTracer exports the spans of this provider to trace_path, nothing is
+// traced when it isn't set
+func (p *provider) NewTracer() error {
+	if p.tracePath == "" {
+		return nil
+	}
+
+	tracer, err := tracing.New(p.id, p.tracePath)
+	if err != nil {
+		return err
+	}
+	p.tracer = tracer
+	p.log.Info("exporting spans", "path", p.tracePath)
+	return nil
+}
+
+// NewThroughputServer starts the measurement servers of throughput_engine
+func (p *provider) NewThroughputServer() error {
+	p.serverOptions.InUse = p.ports.Leased
+	servers, err := p.engine.StartServers(p.iperf3BaseServerPort, p.iperf3ServerCount, p.serverOptions)
 	if err != nil {
-		return fmt.Errorf("failed to start iperf3 server: %w", err)
+		return fmt.Errorf("failed to start %s server: %w", p.engine.Name(), err)
+	}
+	p.servers = servers
+
+	// Only lease the ports of servers that accept flows
+	byPort := make(map[int]throughput.Server, len(servers))
+	for _, server := range servers {
+		byPort[server.Port()] = server
 	}
-	p.iperf3Cmds = cmds
+	p.ports.Check(func(port int) bool {
+		server, exists := byPort[port]
+		return exists && server.Healthy()
+	})
 
 	return nil
 }
 
+// cleanup flushes the archive and the trace file and stops the throughput
+// servers
 func (p *provider) cleanup() error {
-	for _, cmd := range p.iperf3Cmds {
-		if err := iperf3.StopServer(cmd); err != nil {
-			return fmt.Errorf("failed to stop iperf3 server: %w", err)
+	// Export the vote rounds that never finished before closing the trace file
+	p.mutex.Lock()
+	for _, transaction := range p.transactions {
+		transaction.voteSpan.SetError(errVoteRoundUnfinished)
+		transaction.voteSpan.End()
+	}
+	p.mutex.Unlock()
+	if err := p.persistArchive(); err != nil {
+		return fmt.Errorf("failed to persist archive: %w", err)
+	}
+	if err := p.tracer.Close(); err != nil {
+		return fmt.Errorf("failed to close trace file: %w", err)
+	}
+	for _, server := range p.servers {
+		if err := server.Stop(); err != nil {
+			return fmt.Errorf("failed to stop %s server: %w", p.engine.Name(), err)
 		}
 	}
 
-	fmt.Println("cleanup ran, preparing to shutdown...")
-	time.Sleep(time.Second * 3)
+	p.log.Info("cleanup ran")
 	return nil
 }
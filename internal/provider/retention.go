@@ -0,0 +1,190 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/retention"
+)
+
+var errTransactionTimedOut = errors.New("transaction timed out")
+
+// archive appends the transactions evicted from memory to archive_path
+type archive struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// archiveRecord is a line of the archive, the transaction as the stats event
+// showed it when it was evicted
+type archiveRecord struct {
+	RecordType string `json:"record_type"` // always "provider_transaction"
+	ProviderID string `json:"provider_id"`
+	ArchivedAt int64  `json:"archived_at"` // unix ms
+	transactionView
+}
+
+// NewArchive opens archive_path for appending, evicted transactions are
+// dropped when it isn't set
+func (p *provider) NewArchive() error {
+	if p.archivePath == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.archivePath), 0777); err != nil {
+		return fmt.Errorf("failed to make new dir: %w", err)
+	}
+	file, err := os.OpenFile(p.archivePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	p.archive = &archive{file: file}
+	p.log.Info("archiving transactions", "path", p.archivePath)
+	return nil
+}
+
+// NewTransactionSweeper applies the retention policy and port_lease_timeout
+// every sweep interval until ctx is cancelled, this is a blocking function so
+// wrapping the function call in a goroutine is required
+func (p *provider) NewTransactionSweeper(ctx context.Context) {
+	if p.retention.Enabled() {
+		p.log.Info("sweeping transactions", "max_age", p.retention.MaxAge, "max_count", p.retention.MaxCount,
+			"timeout", p.retention.Timeout)
+	}
+
+	ticker := time.NewTicker(retention.SweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.expireLeases(now)
+			if p.retention.Enabled() {
+				p.sweepTransactions(now)
+			}
+		}
+	}
+}
+
+// expireLeases releases the ports of the flows that outlived
+// port_lease_timeout, the flows no longer load the channel either
+func (p *provider) expireLeases(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, transactionID := range p.ports.Expired(now) {
+		p.ports.Release(transactionID)
+		transaction, exists := p.transactions[transactionID]
+		if !exists || len(transaction.ports) == 0 {
+			continue
+		}
+		p.activeFlowCount -= 1
+		p.log.Warn("port lease expired", logging.KeyTransaction, transactionID, "ports", transaction.ports)
+		transaction.ports = nil
+		transaction.leaseExpired = true
+		p.transactions[transactionID] = transaction
+	}
+}
+
+// sweepTransactions expires the stuck transactions and evicts the finished
+// ones the policy no longer keeps, both are archived first
+func (p *provider) sweepTransactions(now time.Time) {
+	p.mutex.Lock()
+	entries := make([]retention.Entry, 0, len(p.transactions))
+	for transactionID, transaction := range p.transactions {
+		entries = append(entries, retention.Entry{
+			ID:    transactionID,
+			Start: transaction.transactionTime,
+			End:   transaction.flowEndTime,
+		})
+	}
+	expired, evicted := p.retention.Sweep(entries, now)
+
+	views := make([]transactionView, 0, len(expired)+len(evicted))
+	for _, transactionID := range expired {
+		transaction := p.transactions[transactionID]
+		view := p.transactionView(transaction)
+		view.State = transactionTimedOut
+		views = append(views, view)
+
+		// A flow that never ended no longer loads the channel nor holds its
+		// ports
+		if len(transaction.ports) > 0 {
+			p.activeFlowCount -= 1
+			p.ports.Release(transactionID)
+		}
+		transaction.voteSpan.SetError(errTransactionTimedOut)
+		transaction.voteSpan.End()
+		p.metrics.transactions.Inc(transactionTimedOut)
+		delete(p.transactions, transactionID)
+	}
+	for _, transactionID := range evicted {
+		views = append(views, p.transactionView(p.transactions[transactionID]))
+		delete(p.transactions, transactionID)
+	}
+	p.mutex.Unlock()
+
+	for _, view := range views[:len(expired)] {
+		p.log.Warn("transaction timed out", logging.KeyTransaction, view.TransactionID, "state", view.State,
+			"age_ms", now.UnixMilli()-view.TransactionTime)
+	}
+	if len(evicted) > 0 {
+		p.log.Debug("evicted transactions", "count", len(evicted))
+	}
+	p.archiveTransactions(views, now)
+}
+
+// archiveTransactions appends the views to the archive, if there is one
+func (p *provider) archiveTransactions(views []transactionView, now time.Time) {
+	if p.archive == nil || len(views) == 0 {
+		return
+	}
+
+	p.archive.mutex.Lock()
+	defer p.archive.mutex.Unlock()
+	for _, view := range views {
+		jsonRecord, err := json.Marshal(archiveRecord{
+			RecordType:      "provider_transaction",
+			ProviderID:      p.id,
+			ArchivedAt:      now.UnixMilli(),
+			transactionView: view,
+		})
+		if err != nil {
+			p.log.Error("failed to marshal archive record", logging.KeyTransaction, view.TransactionID, logging.Err(err))
+			continue
+		}
+		if _, err := p.archive.file.Write(append(jsonRecord, '\n')); err != nil {
+			p.log.Error("failed to archive transaction", logging.KeyTransaction, view.TransactionID, logging.Err(err))
+		}
+	}
+	if err := p.archive.file.Sync(); err != nil {
+		p.log.Error("failed to sync archive", logging.Err(err))
+	}
+}
+
+// persistArchive archives the transactions still held in memory and closes
+// the archive
+func (p *provider) persistArchive() error {
+	if p.archive == nil {
+		return nil
+	}
+
+	p.mutex.Lock()
+	views := make([]transactionView, 0, len(p.transactions))
+	for _, transaction := range p.transactions {
+		views = append(views, p.transactionView(transaction))
+	}
+	p.mutex.Unlock()
+	p.archiveTransactions(views, time.Now())
+
+	p.archive.mutex.Lock()
+	defer p.archive.mutex.Unlock()
+	return p.archive.file.Close()
+}
@@ -0,0 +1,137 @@
+package provider
+
+import (
+	phases "wifi-trade-consensus/internal/pkg/timeline"
+)
+
+// Transaction states as seen by a provider
+const (
+	transactionVoting   = "voting"  // INFORM_VOTE not sent yet
+	transactionVoted    = "voted"   // INFORM_VOTE sent, waiting for START_FLOW
+	transactionFlowing  = "flowing" // START_FLOW received, waiting for TRANSACTION_END
+	transactionWon      = "won"
+	transactionLost     = "lost"
+	transactionRefused  = "refused"   // won, but no ports were free for the flow
+	transactionTimedOut = "timed_out" // expired before TRANSACTION_END, only seen in the archive
+)
+
+// The provider state keeps unexported fields, these views are what the stats
+// event and the admin API serialize
+
+type peerScoreView struct {
+	Uptime           float64 `json:"uptime"`
+	Load             float64 `json:"load"`
+	SignalStrength   float64 `json:"signal_strength"`
+	UplinkSpeed      float64 `json:"uplink_speed"`
+	DownlinkSpeed    float64 `json:"downlink_speed"`
+	LastPrice        float64 `json:"last_price"`
+	ConsumerFeedback float64 `json:"consumer_feedback"`
+	BeaconInitial    int64   `json:"beacon_initial"` // T_0, unix ms
+	BeaconLast       int64   `json:"beacon_last"`    // T_n, unix ms
+}
+
+type transactionView struct {
+	TransactionID   string                 `json:"transaction_id"`
+	State           string                 `json:"state"`
+	TransactionTime int64                  `json:"transaction_time"` // unix ms
+	ConsumerID      string                 `json:"consumer_id"`
+	ConsumerAddress string                 `json:"consumer_address"`
+	PeerList        peers                  `json:"peer_list"`
+	CustomerQOS     customerQOS            `json:"customer_qos"`
+	PeerPrices      map[string]float64     `json:"peer_prices"`
+	AllFFS          allFFS                 `json:"all_FFS"`
+	Winner          string                 `json:"winner,omitempty"`
+	FlowStartTime   int64                  `json:"flow_start_time,omitempty"` // unix ms
+	FlowEndTime     int64                  `json:"flow_end_time,omitempty"`
+	Ports           []int                  `json:"ports,omitempty"` // leased to the flow
+	Rating          float64                `json:"rating,omitempty"`
+	RatingBreakdown map[string]ratingScore `json:"rating_breakdown,omitempty"`
+	UplinkSpeed     float64                `json:"uplink_speed,omitempty"` // measured by the consumer
+	DownlinkSpeed   float64                `json:"downlink_speed,omitempty"`
+	Timeline        []phases.Mark          `json:"timeline"`
+}
+
+func newPeerScoreView(score peerScore) peerScoreView {
+	return peerScoreView{
+		Uptime:           score.uptime,
+		Load:             score.load,
+		SignalStrength:   score.signalStrength,
+		UplinkSpeed:      score.uplinkSpeed,
+		DownlinkSpeed:    score.downlinkSpeed,
+		LastPrice:        score.lastPrice,
+		ConsumerFeedback: score.consumerFeedback,
+		BeaconInitial:    score.beaconTimestamps.initial,
+		BeaconLast:       score.beaconTimestamps.last,
+	}
+}
+
+// peerScoreViews must be called with p.mutex held
+func (p *provider) peerScoreViews() map[string]peerScoreView {
+	views := make(map[string]peerScoreView, len(p.peerScoreMatrix))
+	for peerID, score := range p.peerScoreMatrix {
+		views[peerID] = newPeerScoreView(score)
+	}
+	return views
+}
+
+// transactionState must be called with p.mutex held
+func (p *provider) transactionState(transaction transaction) string {
+	switch {
+	case transaction.flowStartTime > 0 && transaction.winner.ProviderID == p.id && len(transaction.ports) == 0 &&
+		!transaction.leaseExpired:
+		return transactionRefused
+	case transaction.flowEndTime > 0 && transaction.winner.ProviderID == p.id:
+		return transactionWon
+	case transaction.flowEndTime > 0:
+		return transactionLost
+	case transaction.flowStartTime > 0:
+		return transactionFlowing
+	}
+	if _, voted := transaction.timeline.First(phases.INFORM_VOTE_SENT); voted {
+		return transactionVoted
+	}
+	return transactionVoting
+}
+
+// transactionView must be called with p.mutex held, the maps are copied so
+// the view can be serialized after the mutex is released
+func (p *provider) transactionView(transaction transaction) transactionView {
+	peerPrices := make(map[string]float64, len(transaction.peerPrices))
+	for peerID, price := range transaction.peerPrices {
+		peerPrices[peerID] = price
+	}
+	allFFS := make(allFFS, len(transaction.allFFS))
+	for peerID, FFS := range transaction.allFFS {
+		allFFS[peerID] = FFS
+	}
+
+	return transactionView{
+		TransactionID:   transaction.transactionID.String(),
+		State:           p.transactionState(transaction),
+		TransactionTime: transaction.transactionTime,
+		ConsumerID:      transaction.consumerID,
+		ConsumerAddress: transaction.consumerAddress,
+		PeerList:        transaction.peerList,
+		CustomerQOS:     transaction.customerQOS,
+		PeerPrices:      peerPrices,
+		AllFFS:          allFFS,
+		Winner:          transaction.winner.ProviderID,
+		FlowStartTime:   transaction.flowStartTime,
+		FlowEndTime:     transaction.flowEndTime,
+		Ports:           transaction.ports,
+		Rating:          transaction.rating,
+		RatingBreakdown: transaction.ratingBreakdown,
+		UplinkSpeed:     transaction.uplinkSpeed,
+		DownlinkSpeed:   transaction.downlinkSpeed,
+		Timeline:        transaction.timeline.Marks(),
+	}
+}
+
+// transactionViews must be called with p.mutex held
+func (p *provider) transactionViews() map[string]transactionView {
+	views := make(map[string]transactionView, len(p.transactions))
+	for transactionID, transaction := range p.transactions {
+		views[transactionID] = p.transactionView(transaction)
+	}
+	return views
+}
@@ -0,0 +1,220 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Score dimensions of the peer score matrix compared across scorers
+const (
+	DimensionUptime   = "uptime"
+	DimensionLoad     = "load"
+	DimensionStrength = "signal_strength"
+	DimensionFeedback = "consumer_feedback"
+	DimensionPrice    = "last_price"
+)
+
+// stateWon is the state of a transaction the provider won, see
+// internal/provider/views.go
+const stateWon = "won"
+
+// Dimensions in display order
+var Dimensions = []string{DimensionUptime, DimensionLoad, DimensionStrength, DimensionFeedback, DimensionPrice}
+
+// Score is how a provider scores one of its peers
+type Score struct {
+	Uptime           float64 `json:"uptime"`
+	Load             float64 `json:"load"`
+	SignalStrength   float64 `json:"signal_strength"`
+	ConsumerFeedback float64 `json:"consumer_feedback"`
+	LastPrice        float64 `json:"last_price"`
+}
+
+func (s Score) dimension(dimension string) float64 {
+	switch dimension {
+	case DimensionUptime:
+		return s.Uptime
+	case DimensionLoad:
+		return s.Load
+	case DimensionStrength:
+		return s.SignalStrength
+	case DimensionFeedback:
+		return s.ConsumerFeedback
+	default:
+		return s.LastPrice
+	}
+}
+
+// providerStats is the part of a GET_PROVIDER_STATS reply used by the cluster
+// view
+type providerStats struct {
+	ID              string           `json:"id"`
+	Price           float64          `json:"price"`
+	Behaviour       string           `json:"behaviour"`
+	ActiveFlows     int              `json:"active_flows"`
+	Wins            *int             `json:"wins"` // since start, missing from older providers
+	PeerScoreMatrix map[string]Score `json:"peer_score_matrix"`
+	Transactions    map[string]struct {
+		State string `json:"state"`
+	} `json:"transactions"`
+}
+
+// Provider is a row of the cluster view
+type Provider struct {
+	ID           string  `json:"id"`
+	Address      string  `json:"address"`
+	Behaviour    string  `json:"behaviour"`
+	Price        float64 `json:"price"`
+	ActiveFlows  int     `json:"active_flows"`
+	Transactions int     `json:"transactions"`
+	Wins         int     `json:"wins"`
+}
+
+// Disagreement is a peer whose scores in one dimension spread more than the
+// threshold across the providers scoring it
+type Disagreement struct {
+	Peer       string  `json:"peer"`
+	Dimension  string  `json:"dimension"`
+	Low        float64 `json:"low"`
+	LowScorer  string  `json:"low_scorer"`
+	High       float64 `json:"high"`
+	HighScorer string  `json:"high_scorer"`
+	Spread     float64 `json:"spread"` // relative to high for last_price
+}
+
+// Cluster aggregates the stats of every provider queried
+type Cluster struct {
+	Time          int64                       `json:"time"` // unix ms
+	Providers     []Provider                  `json:"providers"`
+	Scores        map[string]map[string]Score `json:"scores"` // scorer id -> peer id -> score
+	Disagreements []Disagreement              `json:"disagreements"`
+	Unreachable   map[string]string           `json:"unreachable,omitempty"` // address -> error
+}
+
+// NewCluster builds the cluster view from the replies of FetchAll. Scores in
+// [0, 1] disagree when they spread more than threshold, last prices when they
+// spread more than threshold relative to the highest one
+func NewCluster(replies map[string]json.RawMessage, failures map[string]error, threshold float64) (*Cluster, error) {
+	cluster := &Cluster{
+		Time:          time.Now().UnixMilli(),
+		Providers:     []Provider{},
+		Scores:        map[string]map[string]Score{},
+		Disagreements: []Disagreement{},
+	}
+
+	for address, reply := range replies {
+		stats := providerStats{}
+		if err := json.Unmarshal(reply, &stats); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stats of %s: %w", address, err)
+		}
+		provider := Provider{
+			ID:           stats.ID,
+			Address:      address,
+			Behaviour:    stats.Behaviour,
+			Price:        stats.Price,
+			ActiveFlows:  stats.ActiveFlows,
+			Transactions: len(stats.Transactions),
+		}
+		if stats.Wins != nil {
+			provider.Wins = *stats.Wins
+		} else {
+			// Only the transactions still held in memory are known
+			for _, transaction := range stats.Transactions {
+				if transaction.State == stateWon {
+					provider.Wins++
+				}
+			}
+		}
+		cluster.Providers = append(cluster.Providers, provider)
+		cluster.Scores[stats.ID] = stats.PeerScoreMatrix
+	}
+	sort.Slice(cluster.Providers, func(i, j int) bool {
+		return cluster.Providers[i].ID < cluster.Providers[j].ID
+	})
+
+	if len(failures) > 0 {
+		cluster.Unreachable = map[string]string{}
+		for address, err := range failures {
+			cluster.Unreachable[address] = err.Error()
+		}
+	}
+
+	cluster.Disagreements = cluster.disagreements(threshold)
+	return cluster, nil
+}
+
+// Scorers returns the ids of the providers holding a peer score matrix
+func (c *Cluster) Scorers() []string {
+	return sortedKeys(c.Scores)
+}
+
+// Peers returns the ids of every provider scored by at least one scorer
+func (c *Cluster) Peers() []string {
+	peers := map[string]bool{}
+	for _, scores := range c.Scores {
+		for peer := range scores {
+			peers[peer] = true
+		}
+	}
+	return sortedKeys(peers)
+}
+
+func (c *Cluster) disagreements(threshold float64) []Disagreement {
+	disagreements := []Disagreement{}
+	for _, peer := range c.Peers() {
+		for _, dimension := range Dimensions {
+			disagreement := Disagreement{Peer: peer, Dimension: dimension, Low: math.Inf(1), High: math.Inf(-1)}
+			scorers := 0
+			for _, scorer := range c.Scorers() {
+				score, exists := c.Scores[scorer][peer]
+				if !exists {
+					continue
+				}
+				scorers++
+				value := score.dimension(dimension)
+				if value < disagreement.Low {
+					disagreement.Low, disagreement.LowScorer = value, scorer
+				}
+				if value > disagreement.High {
+					disagreement.High, disagreement.HighScorer = value, scorer
+				}
+			}
+			if scorers < 2 {
+				continue
+			}
+
+			disagreement.Spread = disagreement.High - disagreement.Low
+			if dimension == DimensionPrice && disagreement.High != 0 {
+				disagreement.Spread /= math.Abs(disagreement.High)
+			}
+			if disagreement.Spread > threshold {
+				disagreements = append(disagreements, disagreement)
+			}
+		}
+	}
+	return disagreements
+}
+
+// Disagrees reports whether the score of peer by scorer is an extreme of a
+// disagreement
+func (c *Cluster) Disagrees(scorer string, peer string, dimension string) bool {
+	for _, disagreement := range c.Disagreements {
+		if disagreement.Peer == peer && disagreement.Dimension == dimension &&
+			(disagreement.LowScorer == scorer || disagreement.HighScorer == scorer) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,70 @@
+// Package stats queries running providers for their GET_PROVIDER_STATS dump
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/payload"
+)
+
+// Fetch sends GET_PROVIDER_STATS to the provider at address and returns its
+// reply. The provider reads the request until EOF, so the write side is
+// closed before the reply is read
+func Fetch(address string, timeout time.Duration) (json.RawMessage, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request, err := json.Marshal(payload.Meta{PayloadType: events.GET_PROVIDER_STATS})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stats request: %w", err)
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to send stats request to %s: %w", address, err)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats of %s: %w", address, err)
+	}
+	if !json.Valid(reply) {
+		return nil, fmt.Errorf("invalid stats reply from %s", address)
+	}
+	return reply, nil
+}
+
+// FetchAll fetches the stats of every address concurrently, the providers
+// that couldn't be queried are in failures
+func FetchAll(addresses []string, timeout time.Duration) (replies map[string]json.RawMessage, failures map[string]error) {
+	replies = map[string]json.RawMessage{}
+	failures = map[string]error{}
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, address := range addresses {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			reply, err := Fetch(address, timeout)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				failures[address] = err
+				return
+			}
+			replies[address] = reply
+		}(address)
+	}
+	wg.Wait()
+	return replies, failures
+}
@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// Write renders the cluster in format. JSON is a single line so watch mode
+// output is JSONL
+func Write(w io.Writer, format string, cluster *Cluster) error {
+	switch format {
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(cluster)
+	case FormatTable:
+		return writeTable(w, cluster)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+func writeTable(w io.Writer, cluster *Cluster) error {
+	writer := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "cluster at %s\n\n", time.UnixMilli(cluster.Time).Format(time.RFC3339))
+
+	fmt.Fprintln(writer, "provider\taddress\tbehaviour\tprice\tactive flows\ttransactions\twins")
+	for _, provider := range cluster.Providers {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%g\t%d\t%d\t%d\n", provider.ID, provider.Address, provider.Behaviour,
+			provider.Price, provider.ActiveFlows, provider.Transactions, provider.Wins)
+	}
+	for _, address := range sortedKeys(cluster.Unreachable) {
+		fmt.Fprintf(writer, "?\t%s\tunreachable: %s\n", address, cluster.Unreachable[address])
+	}
+
+	// One matrix per dimension, rows are the scorers and columns the peers
+	// they score, * marks the extremes of a disagreement
+	peers := cluster.Peers()
+	for _, dimension := range Dimensions {
+		fmt.Fprintf(writer, "\n%s\n", dimension)
+		fmt.Fprint(writer, "scorer \\ peer")
+		for _, peer := range peers {
+			fmt.Fprintf(writer, "\t%s", peer)
+		}
+		fmt.Fprintln(writer)
+		for _, scorer := range cluster.Scorers() {
+			fmt.Fprint(writer, scorer)
+			for _, peer := range peers {
+				score, exists := cluster.Scores[scorer][peer]
+				switch {
+				case !exists:
+					fmt.Fprint(writer, "\t-")
+				case cluster.Disagrees(scorer, peer, dimension):
+					fmt.Fprintf(writer, "\t%.4g*", score.dimension(dimension))
+				default:
+					fmt.Fprintf(writer, "\t%.4g", score.dimension(dimension))
+				}
+			}
+			fmt.Fprintln(writer)
+		}
+	}
+
+	fmt.Fprintf(writer, "\ndisagreements: %d\n", len(cluster.Disagreements))
+	if len(cluster.Disagreements) > 0 {
+		fmt.Fprintln(writer, "peer\tdimension\tlow\tscored by\thigh\tscored by\tspread")
+		for _, disagreement := range cluster.Disagreements {
+			fmt.Fprintf(writer, "%s\t%s\t%.4g\t%s\t%.4g\t%s\t%.3g\n", disagreement.Peer, disagreement.Dimension,
+				disagreement.Low, disagreement.LowScorer, disagreement.High, disagreement.HighScorer, disagreement.Spread)
+		}
+	}
+	return writer.Flush()
+}
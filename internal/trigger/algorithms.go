@@ -1,9 +1,19 @@
 package trigger
 
-import "math/rand"
+import (
+	"math"
+	"math/rand"
+)
 
-func getRandomizedVal(mean, stdDev, lowest, highest float64) float64 {
-	val := rand.NormFloat64()*stdDev + mean
+// newRand returns a source of random draws seeded from seed and the index of
+// its stream, every goroutine draws from a stream of its own so a seed
+// reproduces a run regardless of scheduling
+func newRand(seed int64, stream int) *rand.Rand {
+	return rand.New(rand.NewSource(seed + int64(stream)))
+}
+
+func getRandomizedVal(rng *rand.Rand, mean, stdDev, lowest, highest float64) float64 {
+	val := rng.NormFloat64()*stdDev + mean
 	if val < lowest {
 		return lowest
 	} else if val > highest {
@@ -12,3 +22,13 @@ func getRandomizedVal(mean, stdDev, lowest, highest float64) float64 {
 		return val
 	}
 }
+
+// getParetoVal draws from a pareto distribution with the given shape, using
+// lowest as the scale, values above highest are clipped
+func getParetoVal(rng *rand.Rand, alpha, lowest, highest float64) float64 {
+	val := lowest / math.Pow(1-rng.Float64(), 1/alpha)
+	if highest > 0 && val > highest {
+		return highest
+	}
+	return val
+}
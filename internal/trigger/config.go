@@ -0,0 +1,230 @@
+package trigger
+
+import (
+	"fmt"
+
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/throughput"
+)
+
+// triggerConfig is the layout of the config file, each consumer is decoded on
+// top of the top level workload and overrides only the keys it sets
+type triggerConfig struct {
+	options   `mapstructure:",squash"`
+	Consumers []map[string]interface{} `mapstructure:"consumers"`
+}
+
+// NewOptionsFromConfig decodes and validates the settings of a trigger config
+// file
+func NewOptionsFromConfig(settings map[string]interface{}) (*options, error) {
+	triggerConfig := triggerConfig{}
+	if err := config.Decode(settings, &triggerConfig); err != nil {
+		return nil, err
+	}
+
+	options := triggerConfig.options
+	for idx, rawConsumer := range triggerConfig.Consumers {
+		consumer := consumerInfo{
+			ProviderList: options.ProviderList,
+			workload:     options.workload,
+		}
+		if err := config.Decode(rawConsumer, &consumer); err != nil {
+			return nil, fmt.Errorf("consumers[%d]: %w", idx, err)
+		}
+		if consumer.ConsumerID == "" {
+			consumer.ConsumerID = "consumer-" + fmt.Sprint(idx)
+		}
+		options.Consumers = append(options.Consumers, consumer)
+	}
+
+	if len(options.Consumers) == 0 {
+		options.Consumers = append(options.Consumers, consumerInfo{
+			ConsumerID:   "consumer-0",
+			Address:      options.ConsumerAddress,
+			ProviderList: options.ProviderList,
+			workload:     options.workload,
+		})
+	}
+
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	return &options, nil
+}
+
+func (options options) validate() error {
+	problems := config.Problems{}
+	problems.OptionalAddress("listen_address", options.ListenAddress)
+	problems.OptionalAddress("advertise_address", options.AdvertiseAddress)
+	if options.ResultTimeout < 0 {
+		problems.Add("result_timeout must be >= 0, got %v", options.ResultTimeout)
+	}
+	if options.SummaryInterval < 0 {
+		problems.Add("summary_interval must be >= 0, got %v", options.SummaryInterval)
+	}
+	problems.Merge(options.Logging.Validate())
+
+	consumerIDs := map[string]bool{}
+	for idx, consumer := range options.Consumers {
+		if consumerIDs[consumer.ConsumerID] {
+			problems.Add("consumers[%d]: duplicate consumer_id %q", idx, consumer.ConsumerID)
+		}
+		consumerIDs[consumer.ConsumerID] = true
+		if err := consumer.validate(); err != nil {
+			problems.Add("consumers[%d] (%s): %v", idx, consumer.ConsumerID, err)
+		}
+	}
+	if options.ScenarioPath != "" {
+		if err := options.validateScenario(options.ScenarioPath); err != nil {
+			problems.Add("scenario_path: %v", err)
+		}
+	}
+	return problems.Err()
+}
+
+func (consumer consumerInfo) validate() error {
+	problems := config.Problems{}
+	problems.Address("address", consumer.Address)
+	if len(consumer.ProviderList) == 0 {
+		problems.Add("provider_list must not be empty")
+	}
+	for idx, provider := range consumer.ProviderList {
+		if provider.ProviderID == "" {
+			problems.Add("provider_list[%d]: provider_id must be set", idx)
+		}
+		problems.Address(fmt.Sprintf("provider_list[%d].address", idx), provider.Address)
+	}
+	problems.Merge(consumer.workload.validate())
+	return problems.Err()
+}
+
+func (w workload) validate() error {
+	problems := config.Problems{}
+	if w.BuyEventCount < 0 {
+		problems.Add("buy_event_count must be >= 0, got %d", w.BuyEventCount)
+	}
+	if w.MaxOutstanding < 0 {
+		problems.Add("max_outstanding must be >= 0, got %d", w.MaxOutstanding)
+	}
+
+	// A trace carries its own arrivals, QoS requirements and flow sizes
+	if w.ArrivalProcess == arrivalTrace {
+		if w.TracePath == "" {
+			problems.Add("trace_path must be set for trace arrivals")
+		}
+		return problems.Err()
+	}
+
+	if _, err := newArrivalProcess(w, nil); err != nil {
+		problems.Add("%v", err)
+	}
+	// Draws are clipped to [lowest, highest], so the bounds are what has to
+	// stay in range
+	if w.ArrivalProcess == "" || w.ArrivalProcess == arrivalNormal {
+		checkDistribution(&problems, "buy_event_interval", w.BuyEventIntervalStdDev, w.BuyEventIntervalLowest, w.BuyEventIntervalHighest)
+		if w.BuyEventIntervalLowest < 0 {
+			problems.Add("buy_event_interval_lowest must be >= 0, got %v", w.BuyEventIntervalLowest)
+		}
+	}
+	checkDistribution(&problems, "uplink", w.UplinkStdDev, w.UplinkLowest, w.UplinkHighest)
+	problems.Positive("uplink_lowest", w.UplinkLowest)
+	checkDistribution(&problems, "downlink", w.DownlinkStdDev, w.DownlinkLowest, w.DownlinkHighest)
+	problems.Positive("downlink_lowest", w.DownlinkLowest)
+	checkDistribution(&problems, "price", w.PriceStdDev, w.PriceLowest, w.PriceHighest)
+	problems.Positive("price_lowest", w.PriceLowest)
+	checkDistribution(&problems, "mu", w.MuStdDev, w.MuLowest, w.MuHighest)
+	problems.Positive("mu_lowest", w.MuLowest)
+	if w.MuHighest > 1 {
+		problems.Add("mu_highest must be <= 1, got %v", w.MuHighest)
+	}
+	checkDistribution(&problems, "delta", w.DeltaStdDev, w.DeltaLowest, w.DeltaHighest)
+	problems.Positive("delta_lowest", w.DeltaLowest)
+	if w.DeltaHighest > 1 {
+		problems.Add("delta_highest must be <= 1, got %v", w.DeltaHighest)
+	}
+	checkDistribution(&problems, "epsilon", w.EpsilonStdDev, w.EpsilonLowest, w.EpsilonHighest)
+	if w.EpsilonLowest < 1 {
+		problems.Add("epsilon_lowest must be >= 1, got %v", w.EpsilonLowest)
+	}
+	problems.Positive("flow_size_lowest", w.FlowSizeLowest)
+
+	problems.Closed("session_share", w.SessionShare, 0, 1)
+	if w.SessionShare > 0 {
+		checkDistribution(&problems, "session_duration", w.SessionDurationStdDev, w.SessionDurationLowest,
+			w.SessionDurationHighest)
+		problems.Positive("session_duration_lowest", w.SessionDurationLowest)
+	}
+
+	problems.Closed("udp_share", w.UDPShare, 0, 1)
+	// iperf3 would otherwise send udp at its 1 Mbit/s default, far below the
+	// speeds bought
+	if w.UDPShare > 0 && w.UDPBitrate <= 0 {
+		problems.Add("udp_bitrate must be set when udp_share is > 0")
+	}
+	if w.MaxJitter < 0 {
+		problems.Add("max_jitter must be >= 0, got %v", w.MaxJitter)
+	}
+	problems.Closed("max_loss", w.MaxLoss, 0, 100)
+	if w.MaxRTT < 0 {
+		problems.Add("max_rtt must be >= 0, got %v", w.MaxRTT)
+	}
+
+	switch w.FlowSizeDistribution {
+	case "", flowSizeNormal:
+		checkDistribution(&problems, "flow_size", w.FlowSizeStdDev, w.FlowSizeLowest, w.FlowSizeHighest)
+	case flowSizePareto:
+		problems.Positive("flow_size_pareto_alpha", w.FlowSizeParetoAlpha)
+	default:
+		problems.Add("unknown flow size distribution: %s", w.FlowSizeDistribution)
+	}
+	return problems.Err()
+}
+
+// checkDistribution checks a clipped normal distribution read from the
+// <key>_std_dev, <key>_lowest and <key>_highest keys
+func checkDistribution(problems *config.Problems, key string, stdDev float64, lowest float64, highest float64) {
+	if stdDev < 0 {
+		problems.Add("%s_std_dev must be >= 0, got %v", key, stdDev)
+	}
+	if lowest > highest {
+		problems.Add("%s_lowest must be <= %s_highest, got %v > %v", key, key, lowest, highest)
+	}
+}
+
+// ValidateConfigFile checks a trigger config file without starting a trigger
+func ValidateConfigFile(path string) error {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return err
+	}
+	_, err = NewOptionsFromConfig(settings)
+	return err
+}
+
+// ValidateProviderEngines checks the trigger config file against the
+// throughput_engine of each provider, by id. The native engine fails UDP
+// flows, so a consumer with a udp_share may only buy from iperf3 providers
+func ValidateProviderEngines(path string, engines map[string]string) error {
+	settings, err := config.Source{Path: path}.Load()
+	if err != nil {
+		return err
+	}
+	options, err := NewOptionsFromConfig(settings)
+	if err != nil {
+		return err
+	}
+
+	problems := config.Problems{}
+	for idx, consumer := range options.Consumers {
+		if consumer.UDPShare == 0 {
+			continue
+		}
+		for _, provider := range consumer.ProviderList {
+			if engines[provider.ProviderID] == throughput.EngineNative {
+				problems.Add("consumers[%d] (%s): udp_share needs the %s throughput_engine, provider %s runs %s",
+					idx, consumer.ConsumerID, throughput.EngineIperf3, provider.ProviderID, throughput.EngineNative)
+			}
+		}
+	}
+	return problems.Err()
+}
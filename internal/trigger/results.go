@@ -0,0 +1,260 @@
+package trigger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
+	"wifi-trade-consensus/internal/pkg/payload"
+
+	"github.com/google/uuid"
+)
+
+const defaultResultTimeout = 600 // seconds
+
+// triggerResultPayload is the outcome of a TRIGGER_BUY reported by a consumer
+type triggerResultPayload struct {
+	PayloadMeta
+	ConsumerID    string  `json:"consumer_id"`
+	Success       bool    `json:"success"`
+	FailureReason string  `json:"failure_reason,omitempty"`
+	WinnerID      string  `json:"winner_id"`
+	Price         float64 `json:"price"`
+	UplinkSpeed   float64 `json:"uplink_speed"`
+	DownlinkSpeed float64 `json:"downlink_speed"`
+	Rating        float64 `json:"rating"`
+	// Phase latencies in ms measured by the consumer
+	ConsensusLatency float64 `json:"consensus_latency"`
+	EndToEndLatency  float64 `json:"end_to_end_latency"`
+}
+
+// summary counts the outcome of every BUY sent by the trigger
+type summary struct {
+	Sent                  int     `json:"sent"`
+	SendFailed            int     `json:"send_failed"`
+	Completed             int     `json:"completed"`
+	Failed                int     `json:"failed"`
+	TimedOut              int     `json:"timed_out"`
+	Outstanding           int     `json:"outstanding"`
+	TotalPrice            float64 `json:"total_price"`
+	TotalUplink           float64 `json:"total_uplink"`
+	TotalDownlink         float64 `json:"total_downlink"`
+	TotalRating           float64 `json:"total_rating"`
+	TotalConsensusLatency float64 `json:"total_consensus_latency"` // ms
+	TotalEndToEndLatency  float64 `json:"total_end_to_end_latency"`
+}
+
+func (s summary) String() string {
+	str := fmt.Sprintf("sent %d, send failed %d, completed %d, failed %d, timed out %d, outstanding %d",
+		s.Sent, s.SendFailed, s.Completed, s.Failed, s.TimedOut, s.Outstanding)
+	if s.Completed > 0 {
+		n := float64(s.Completed)
+		str += fmt.Sprintf(", mean price %.6f, mean uplink %.2f, mean downlink %.2f, mean rating %.3f"+
+			", mean consensus latency %.1fms, mean end-to-end latency %.1fms",
+			s.TotalPrice/n, s.TotalUplink/n, s.TotalDownlink/n, s.TotalRating/n,
+			s.TotalConsensusLatency/n, s.TotalEndToEndLatency/n)
+	}
+	return str
+}
+
+// resultCollector matches TRIGGER_RESULT replies to the BUY events waiting for
+// them
+type resultCollector struct {
+	mutex   sync.Mutex
+	pending map[uuid.UUID]chan triggerResultPayload
+	summary summary
+	log     *slog.Logger
+}
+
+func newResultCollector(log *slog.Logger) *resultCollector {
+	return &resultCollector{
+		pending: make(map[uuid.UUID]chan triggerResultPayload),
+		log:     log,
+	}
+}
+
+// register returns the channel the result of transactionID will be sent on
+func (r *resultCollector) register(transactionID uuid.UUID) chan triggerResultPayload {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	channel := make(chan triggerResultPayload, 1)
+	r.pending[transactionID] = channel
+	r.summary.Sent++
+	r.summary.Outstanding++
+	return channel
+}
+
+func (r *resultCollector) sendFailed(transactionID uuid.UUID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.pending, transactionID)
+	r.summary.Sent--
+	r.summary.Outstanding--
+	r.summary.SendFailed++
+}
+
+// forget stops tracking a transaction whose result won't be collected
+func (r *resultCollector) forget(transactionID uuid.UUID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.pending, transactionID)
+	r.summary.Outstanding--
+}
+
+func (r *resultCollector) deliver(result triggerResultPayload) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	channel, exists := r.pending[result.TransactionID]
+	if !exists {
+		r.log.Warn("received result for unknown or timed out transaction", logging.Event(events.TRIGGER_RESULT),
+			logging.Transaction(result.TransactionID), logging.KeyPeer, result.ConsumerID)
+		return
+	}
+	delete(r.pending, result.TransactionID)
+	channel <- result
+}
+
+// wait blocks until the result of transactionID arrives or timeout passes
+func (r *resultCollector) wait(transactionID uuid.UUID, channel chan triggerResultPayload, timeout time.Duration) {
+	select {
+	case result := <-channel:
+		r.mutex.Lock()
+		r.summary.Outstanding--
+		if result.Success {
+			r.summary.Completed++
+			r.summary.TotalPrice += result.Price
+			r.summary.TotalUplink += result.UplinkSpeed
+			r.summary.TotalDownlink += result.DownlinkSpeed
+			r.summary.TotalRating += result.Rating
+			r.summary.TotalConsensusLatency += result.ConsensusLatency
+			r.summary.TotalEndToEndLatency += result.EndToEndLatency
+		} else {
+			r.summary.Failed++
+		}
+		r.mutex.Unlock()
+
+		if result.Success {
+			r.log.Info("transaction completed", logging.Transaction(transactionID), logging.KeyPeer, result.ConsumerID,
+				"winner", result.WinnerID, "price", result.Price, "uplink", result.UplinkSpeed,
+				"downlink", result.DownlinkSpeed, "rating", result.Rating,
+				"consensus_ms", result.ConsensusLatency, "end_to_end_ms", result.EndToEndLatency)
+		} else {
+			r.log.Warn("transaction failed", logging.Transaction(transactionID), logging.KeyPeer, result.ConsumerID,
+				"reason", result.FailureReason)
+		}
+
+	case <-time.After(timeout):
+		r.mutex.Lock()
+		delete(r.pending, transactionID)
+		r.summary.Outstanding--
+		r.summary.TimedOut++
+		r.mutex.Unlock()
+		r.log.Warn("transaction timed out", logging.Transaction(transactionID), "timeout", timeout)
+	}
+}
+
+func (r *resultCollector) getSummary() summary {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.summary
+}
+
+// NewResultListener listens for TRIGGER_RESULT replies in the background,
+// results are only collected when listen_address is set
+func (t *trigger) NewResultListener() error {
+	if t.ListenAddress == "" {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", t.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen tcp address: %w", err)
+	}
+	t.log.Info("listening for results", "address", t.ListenAddress)
+
+	go func() {
+		defer l.Close()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				t.log.Error("failed to accept new connection", logging.Err(err))
+				continue
+			}
+			go t.handleResultConn(conn)
+		}
+	}()
+
+	return nil
+}
+
+func (t *trigger) handleResultConn(conn net.Conn) {
+	defer conn.Close()
+
+	remote := slog.String("remote", conn.RemoteAddr().String())
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.log.Error("failed to read connection data", remote, logging.Err(err))
+		return
+	}
+
+	payloadMeta := payload.Meta{}
+	if err := json.Unmarshal(data, &payloadMeta); err != nil {
+		t.log.Error("failed to unmarshal payload meta", remote, logging.Err(err))
+		return
+	}
+	if payloadMeta.PayloadType != events.TRIGGER_RESULT {
+		t.log.Error("failed to determine event type", remote, "payload_type", payloadMeta.PayloadType)
+		return
+	}
+
+	result := triggerResultPayload{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.log.Error("failed to unmarshal payload", logging.Event(events.TRIGGER_RESULT), remote, logging.Err(err))
+		return
+	}
+	t.results.deliver(result)
+}
+
+// NewSummaryPrinter prints the live summary every summary_interval in the
+// background, the returned function stops it
+func (t *trigger) NewSummaryPrinter() func() {
+	done := make(chan struct{})
+	if t.SummaryInterval <= 0 {
+		return func() {}
+	}
+
+	go func() {
+		ticker := time.NewTicker(secondsToDuration(t.SummaryInterval))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.log.Info("summary", "summary", t.results.getSummary().String())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Summary returns the outcome of every BUY sent so far
+func (t *trigger) Summary() summary {
+	return t.results.getSummary()
+}
+
+// Failed reports whether any BUY could not be sent, failed or timed out
+func (t *trigger) Failed() bool {
+	s := t.results.getSummary()
+	return s.SendFailed > 0 || s.Failed > 0 || s.TimedOut > 0
+}
@@ -0,0 +1,523 @@
+package trigger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"wifi-trade-consensus/internal/pkg/config"
+	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
+)
+
+// Scenario event types
+const (
+	scenarioBuy          = "buy"           // send count BUY events to the target consumers
+	scenarioCrash        = "crash"         // kill the target provider containers
+	scenarioRestart      = "restart"       // start the target provider containers again
+	scenarioSetParams    = "set_params"    // change price and/or params of the target providers
+	scenarioSetBehaviour = "set_behaviour" // switch the behaviour profile of the target providers
+	scenarioSetNetwork   = "set_network"   // change the rate limits of the target provider containers
+)
+
+// Behaviour profiles a set_behaviour event may switch providers to
+const (
+	behaviourHonest = "honest"
+	behaviourFaulty = "faulty"
+)
+
+const containerRuntime = "docker"
+
+// updateProviderReplyTimeout bounds the wait for a provider to say whether it
+// applied an UPDATE_PROVIDER
+const updateProviderReplyTimeout = 5 * time.Second
+
+// scenario is a timeline of events executed by the trigger, see readme for the
+// file format
+type scenario struct {
+	Name         string          `json:"name"`
+	RunWorkloads bool            `json:"run_workloads"` // also run every consumer's workload
+	LogPath      string          `json:"log_path"`      // JSONL log of executed events
+	Events       []scenarioEvent `json:"events"`
+}
+
+type scenarioEvent struct {
+	At        string   `json:"at"` // offset from the start, e.g. "10m"
+	Type      string   `json:"type"`
+	Providers []string `json:"providers,omitempty"` // provider ids, defaults to every provider
+	Consumers []string `json:"consumers,omitempty"` // consumer ids, defaults to every consumer
+	Fraction  float64  `json:"fraction,omitempty"`  // pick this fraction of providers at random instead
+	// buy
+	Count int `json:"count,omitempty"`
+	// set_params
+	Price           *float64        `json:"price,omitempty"`
+	PriceMultiplier *float64        `json:"price_multiplier,omitempty"`
+	Params          json.RawMessage `json:"params,omitempty"`
+	// set_behaviour
+	Behaviour string `json:"behaviour,omitempty"`
+	// set_network, tc rates such as "10mbps"
+	Uplink   string `json:"uplink,omitempty"`
+	Downlink string `json:"downlink,omitempty"`
+	Burst    string `json:"burst,omitempty"`
+
+	at time.Duration
+}
+
+// scenarioLogEntry is a line of the scenario log
+type scenarioLogEntry struct {
+	Scenario  string        `json:"scenario"`
+	At        string        `json:"at"`
+	Timestamp int64         `json:"timestamp"` // unix ms
+	Event     scenarioEvent `json:"event"`
+	Targets   []string      `json:"targets"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// scenarioEventPayload notifies consumers so they can record the event next to
+// their in-flight transactions
+type scenarioEventPayload struct {
+	PayloadMeta
+	Scenario    string   `json:"scenario"`
+	At          string   `json:"at"`
+	EventType   string   `json:"event_type"`
+	Targets     []string `json:"targets"`
+	Description string   `json:"description"`
+	Timestamp   int64    `json:"timestamp"`
+}
+
+type updateProviderPayload struct {
+	PayloadMeta
+	Price           *float64        `json:"price,omitempty"`
+	PriceMultiplier *float64        `json:"price_multiplier,omitempty"`
+	Params          json.RawMessage `json:"params,omitempty"`
+	Behaviour       string          `json:"behaviour,omitempty"`
+	AdminToken      string          `json:"admin_token,omitempty"`
+}
+
+// updateProviderReply is the outcome of an UPDATE_PROVIDER
+type updateProviderReply struct {
+	Error string `json:"error,omitempty"`
+}
+
+func loadScenario(path string) (*scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+
+	// A misspelled key such as "provider" would otherwise be dropped and widen
+	// the event to every provider
+	scenario := scenario{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal scenario file: %w", err)
+	}
+
+	problems := config.Problems{}
+	for idx := range scenario.Events {
+		if err := scenario.Events[idx].validate(); err != nil {
+			problems.Add("events[%d]: %v", idx, err)
+		}
+	}
+	if err := problems.Err(); err != nil {
+		return nil, fmt.Errorf("invalid scenario file: %w", err)
+	}
+
+	// Events at the same offset keep their file order
+	sort.SliceStable(scenario.Events, func(i, j int) bool {
+		return scenario.Events[i].at < scenario.Events[j].at
+	})
+
+	return &scenario, nil
+}
+
+// validate parses the offset of the event and checks it carries what its type
+// needs
+func (event *scenarioEvent) validate() error {
+	problems := config.Problems{}
+	var err error
+	if event.at, err = time.ParseDuration(event.At); err != nil {
+		problems.Add("invalid at: %v", err)
+	} else if event.at < 0 {
+		problems.Add("at must be >= 0, got %s", event.At)
+	}
+	problems.Closed("fraction", event.Fraction, 0, 1)
+	if len(event.Providers) > 0 && event.Fraction > 0 {
+		problems.Add("providers and fraction are exclusive")
+	}
+
+	switch event.Type {
+	case scenarioBuy:
+		if event.Count < 0 {
+			problems.Add("count must be >= 0, got %d", event.Count)
+		}
+	case scenarioCrash, scenarioRestart:
+		// Killing every provider is never the intent of an event without a target
+		if len(event.Providers) == 0 && event.Fraction == 0 {
+			problems.Add("%s needs providers or fraction", event.Type)
+		}
+	case scenarioSetParams:
+		if event.Price == nil && event.PriceMultiplier == nil && len(event.Params) == 0 {
+			problems.Add("set_params needs price, price_multiplier or params")
+		}
+		if event.Price != nil {
+			problems.Positive("price", *event.Price)
+		}
+		if event.PriceMultiplier != nil {
+			problems.Positive("price_multiplier", *event.PriceMultiplier)
+		}
+	case scenarioSetBehaviour:
+		if event.Behaviour != behaviourHonest && event.Behaviour != behaviourFaulty {
+			problems.Add("behaviour must be %s or %s, got %q", behaviourHonest, behaviourFaulty, event.Behaviour)
+		}
+	case scenarioSetNetwork:
+		if event.Uplink == "" || event.Downlink == "" {
+			problems.Add("set_network needs uplink and downlink")
+		}
+	default:
+		problems.Add("unknown type: %q", event.Type)
+	}
+	return problems.Err()
+}
+
+// validateScenario checks the scenario at path and that its events only name
+// providers and consumers of the config
+func (options options) validateScenario(path string) error {
+	scenario, err := loadScenario(path)
+	if err != nil {
+		return err
+	}
+
+	providerIDs := map[string]bool{}
+	for _, provider := range options.ProviderList {
+		providerIDs[provider.ProviderID] = true
+	}
+	consumerIDs := map[string]bool{}
+	for _, consumer := range options.Consumers {
+		consumerIDs[consumer.ConsumerID] = true
+	}
+
+	problems := config.Problems{}
+	for _, event := range scenario.Events {
+		for _, id := range event.Providers {
+			if !providerIDs[id] {
+				problems.Add("event at %s: unknown provider %q", event.At, id)
+			}
+		}
+		for _, id := range event.Consumers {
+			if !consumerIDs[id] {
+				problems.Add("event at %s: unknown consumer %q", event.At, id)
+			}
+		}
+	}
+	return problems.Err()
+}
+
+// RunScenario executes the scenario at path on its timeline and returns once
+// every event, and every workload if enabled, is done
+func (t *trigger) RunScenario(path string) error {
+	scenario, err := loadScenario(path)
+	if err != nil {
+		return err
+	}
+
+	var logFile *os.File
+	if scenario.LogPath != "" {
+		if err := os.MkdirAll(filepath.Dir(scenario.LogPath), 0777); err != nil {
+			return fmt.Errorf("failed to make scenario log dir: %w", err)
+		}
+		logFile, err = os.OpenFile(scenario.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return fmt.Errorf("failed to open scenario log: %w", err)
+		}
+		defer logFile.Close()
+	}
+
+	wg := sync.WaitGroup{}
+	if scenario.RunWorkloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.Start()
+		}()
+	}
+
+	// The scenario draws from the stream after those of the consumers
+	rng := newRand(t.Seed, len(t.Consumers))
+	generators := map[string]buyGenerator{}
+	start := time.Now()
+	t.log.Info("starting scenario", "scenario", scenario.Name, "events", len(scenario.Events))
+	for _, event := range scenario.Events {
+		time.Sleep(time.Until(start.Add(event.at)))
+
+		targets, err := t.executeScenarioEvent(event, rng, generators, &wg)
+		entry := scenarioLogEntry{
+			Scenario:  scenario.Name,
+			At:        event.At,
+			Timestamp: time.Now().UnixMilli(),
+			Event:     event,
+			Targets:   targets,
+		}
+		if err != nil {
+			entry.Error = err.Error()
+			t.log.Error("failed to execute scenario event", "type", event.Type, "at", event.At, logging.Err(err))
+		} else {
+			t.log.Info("executed scenario event", "type", event.Type, "at", event.At, "targets", targets)
+		}
+
+		if logFile != nil {
+			jsonEntry, _ := json.Marshal(entry)
+			if _, err := logFile.Write(append(jsonEntry, '\n')); err != nil {
+				t.log.Error("failed to write scenario log", logging.Err(err))
+			}
+		}
+		t.notifyConsumers(entry)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+func (t *trigger) executeScenarioEvent(event scenarioEvent, rng *rand.Rand, generators map[string]buyGenerator,
+	wg *sync.WaitGroup) ([]string, error) {
+	if event.Type == scenarioBuy {
+		return t.executeScenarioBuy(event, rng, generators, wg)
+	}
+
+	providers, err := t.selectProviders(event, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []string{}
+	errs := []string{}
+	for _, provider := range providers {
+		targets = append(targets, provider.ProviderID)
+
+		var err error
+		switch event.Type {
+		case scenarioCrash:
+			err = runContainerCommand(provider, "kill", provider.Container)
+		case scenarioRestart:
+			err = runContainerCommand(provider, "start", provider.Container)
+		case scenarioSetNetwork:
+			err = runContainerCommand(provider, "exec", provider.Container,
+				"/app/scripts/network_limiter.sh", event.Uplink, event.Downlink, event.Burst)
+		case scenarioSetParams, scenarioSetBehaviour:
+			err = sendUpdateProvider(provider, updateProviderPayload{
+				PayloadMeta:     PayloadMeta{PayloadType: events.UPDATE_PROVIDER},
+				Price:           event.Price,
+				PriceMultiplier: event.PriceMultiplier,
+				Params:          event.Params,
+				Behaviour:       event.Behaviour,
+				AdminToken:      t.AdminToken,
+			})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", provider.ProviderID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return targets, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return targets, nil
+}
+
+func (t *trigger) executeScenarioBuy(event scenarioEvent, rng *rand.Rand, generators map[string]buyGenerator,
+	wg *sync.WaitGroup) ([]string, error) {
+	count := event.Count
+	if count <= 0 {
+		count = 1
+	}
+
+	targets := []string{}
+	errs := []string{}
+	for _, consumer := range t.Consumers {
+		if len(event.Consumers) > 0 && !contains(event.Consumers, consumer.ConsumerID) {
+			continue
+		}
+		targets = append(targets, consumer.ConsumerID)
+
+		// QoS requirements come from the consumer's workload, its arrival times
+		// are ignored
+		generator, exists := generators[consumer.ConsumerID]
+		if !exists {
+			w := consumer.workload
+			w.BuyEventCount = int(^uint(0) >> 1)
+			var err error
+			generator, err = newBuyGenerator(w, rng)
+			if err != nil {
+				return targets, fmt.Errorf("failed to create workload for consumer %s: %w", consumer.ConsumerID, err)
+			}
+			generators[consumer.ConsumerID] = generator
+		}
+
+		for i := 0; i < count; i++ {
+			buyEvent, ok := generator.next()
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: workload exhausted", consumer.ConsumerID))
+				break
+			}
+			if err := t.issueBuy(consumer, buyEvent.qosRequirements, wg, func() {}); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", consumer.ConsumerID, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return targets, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return targets, nil
+}
+
+// selectProviders returns the providers listed in the event, a random fraction
+// of all providers, or every provider for the updates that allow it
+func (t *trigger) selectProviders(event scenarioEvent, rng *rand.Rand) (providers, error) {
+	if len(event.Providers) > 0 {
+		selected := providers{}
+		for _, id := range event.Providers {
+			found := false
+			for _, provider := range t.ProviderList {
+				if provider.ProviderID == id {
+					selected = append(selected, provider)
+					found = true
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unknown provider: %s", id)
+			}
+		}
+		return selected, nil
+	}
+
+	if event.Fraction > 0 {
+		count := int(event.Fraction*float64(len(t.ProviderList)) + 0.5)
+		if count > len(t.ProviderList) {
+			count = len(t.ProviderList)
+		}
+		selected := providers{}
+		for _, idx := range rng.Perm(len(t.ProviderList))[:count] {
+			selected = append(selected, t.ProviderList[idx])
+		}
+		return selected, nil
+	}
+
+	if event.Type == scenarioCrash || event.Type == scenarioRestart {
+		return nil, fmt.Errorf("%s needs providers or fraction", event.Type)
+	}
+	return t.ProviderList, nil
+}
+
+func (t *trigger) notifyConsumers(entry scenarioLogEntry) {
+	description := fmt.Sprintf("%s on %v", entry.Event.Type, entry.Targets)
+	if entry.Error != "" {
+		description += ", failed: " + entry.Error
+	}
+
+	payload := scenarioEventPayload{
+		PayloadMeta: PayloadMeta{PayloadType: events.SCENARIO_EVENT},
+		Scenario:    entry.Scenario,
+		At:          entry.At,
+		EventType:   entry.Event.Type,
+		Targets:     entry.Targets,
+		Description: description,
+		Timestamp:   entry.Timestamp,
+	}
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		t.log.Error("failed to marshal payload", logging.Event(events.SCENARIO_EVENT), logging.Err(err))
+		return
+	}
+
+	for _, consumer := range t.Consumers {
+		if err := send(consumer.Address, jsonPayload); err != nil {
+			t.log.Error("failed to send payload", logging.Event(events.SCENARIO_EVENT), logging.KeyPeer, consumer.ConsumerID,
+				logging.Err(err))
+		}
+	}
+}
+
+// sendUpdateProvider returns an error if the provider rejected the update, a
+// provider that doesn't reply is taken to have applied it
+func sendUpdateProvider(provider providerInfo, payload updateProviderPayload) error {
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	conn, err := net.Dial("tcp", provider.Address)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", provider.Address, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(jsonPayload); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", provider.Address, err)
+	}
+	// The provider reads until EOF before it replies
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(updateProviderReplyTimeout))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read reply from %s: %w", provider.Address, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	reply := updateProviderReply{}
+	if err := json.Unmarshal(data, &reply); err != nil {
+		return fmt.Errorf("failed to unmarshal reply: %w", err)
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("update rejected: %s", reply.Error)
+	}
+	return nil
+}
+
+func runContainerCommand(provider providerInfo, args ...string) error {
+	if provider.Container == "" {
+		return fmt.Errorf("no container configured for provider")
+	}
+	out, err := exec.Command(containerRuntime, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run %s %s: %w: %s", containerRuntime, strings.Join(args, " "), err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func send(address string, data []byte) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", address, err)
+	}
+	return nil
+}
+
+func contains(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
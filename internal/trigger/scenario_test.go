@@ -0,0 +1,53 @@
+package trigger
+
+import "testing"
+
+func TestLoadExampleScenario(t *testing.T) {
+	scenario, err := loadScenario("../../cmd/trigger/scenarios/provider_failures.json")
+	if err != nil {
+		t.Fatalf("loadScenario: %v", err)
+	}
+	for idx := 1; idx < len(scenario.Events); idx++ {
+		if scenario.Events[idx].at < scenario.Events[idx-1].at {
+			t.Errorf("events aren't sorted by at: %s before %s", scenario.Events[idx-1].At, scenario.Events[idx].At)
+		}
+	}
+}
+
+func TestInvalidScenarios(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unknown key", `{"events": [{"at": "1s", "type": "set_params", "provider": ["p0"], "price": 1}]}`},
+		{"crash without target", `{"events": [{"at": "1s", "type": "crash"}]}`},
+		{"restart without target", `{"events": [{"at": "1s", "type": "restart"}]}`},
+		{"fraction above 1", `{"events": [{"at": "1s", "type": "crash", "fraction": 1.5}]}`},
+		{"bad behaviour", `{"events": [{"at": "1s", "type": "set_behaviour", "behaviour": "evil"}]}`},
+		{"missing downlink", `{"events": [{"at": "1s", "type": "set_network", "uplink": "10mbps"}]}`},
+		{"empty set_params", `{"events": [{"at": "1s", "type": "set_params"}]}`},
+		{"bad at", `{"events": [{"at": "soon", "type": "buy"}]}`},
+		{"unknown type", `{"events": [{"at": "1s", "type": "explode"}]}`},
+	}
+	for _, test := range tests {
+		path := writeTrace(t, "scenario.json", test.content)
+		if _, err := loadScenario(path); err == nil {
+			t.Errorf("%s: loadScenario succeeded", test.name)
+		}
+	}
+}
+
+func TestScenarioUnknownIDs(t *testing.T) {
+	options := options{ProviderList: providers{{ProviderID: "p0"}}, Consumers: []consumerInfo{{ConsumerID: "c0"}}}
+	valid := writeTrace(t, "valid.json", `{"events": [{"at": "1s", "type": "crash", "providers": ["p0"]},
+		{"at": "2s", "type": "buy", "consumers": ["c0"]}]}`)
+	if err := options.validateScenario(valid); err != nil {
+		t.Errorf("validateScenario: %v", err)
+	}
+
+	invalid := writeTrace(t, "invalid.json", `{"events": [{"at": "1s", "type": "crash", "providers": ["p1"]},
+		{"at": "2s", "type": "buy", "consumers": ["c1"]}]}`)
+	if err := options.validateScenario(invalid); err == nil {
+		t.Errorf("validateScenario of unknown ids succeeded")
+	}
+}
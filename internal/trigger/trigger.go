@@ -3,13 +3,16 @@ package trigger
 import (
 	"encoding/json"
 	"fmt"
-	"net"
-	"strconv"
+	"log/slog"
+	"math/rand"
+	"sync"
 	"time"
 	"wifi-trade-consensus/internal/pkg/events"
+	"wifi-trade-consensus/internal/pkg/logging"
 	"wifi-trade-consensus/internal/pkg/payload"
+	"wifi-trade-consensus/internal/pkg/units"
 
-	"github.com/spf13/viper"
+	"github.com/google/uuid"
 )
 
 type PayloadMeta payload.Meta
@@ -22,26 +25,43 @@ type providerInfo struct {
 	Iperf3BaseServerPort string  `json:"iperf3_base_server_port"`
 	Iperf3ServerCount    int     `json:"iperf3_server_count"`
 	Price                float64 `json:"price"`
+	Container            string  `mapstructure:"container" json:"-"` // docker container, used by scenarios
 }
 
 type qosRequirements struct {
-	PriceConsumer         float64 `mapstructure:"price" json:"price"`         // consumer price requirement
-	UplinkSpeedConsumer   float64 `mapstructure:"uplink" json:"uplink"`       // consumer uplink speed requirement
-	DownlinkSpeedConsumer float64 `mapstructure:"downlink" json:"downlink"`   // consumer downlink speed requirement
-	Mu                    float64 `mapstructure:"mu" json:"mu"`               // uplink weight
-	Delta                 float64 `mapstructure:"delta" json:"delta"`         // downlink weight
-	Epsilon               float64 `mapstructure:"epsilon" json:"epsilon"`     // price range multiplier limit
-	FlowSize              string  `mapstructure:"flow_size" json:"flow_size"` // size of data to upload/download to/from provider
+	PriceConsumer         float64       `mapstructure:"price" json:"price"`                       // consumer price requirement
+	UplinkSpeedConsumer   float64       `mapstructure:"uplink" json:"uplink"`                     // consumer uplink speed requirement
+	DownlinkSpeedConsumer float64       `mapstructure:"downlink" json:"downlink"`                 // consumer downlink speed requirement
+	Mu                    float64       `mapstructure:"mu" json:"mu"`                             // uplink weight
+	Delta                 float64       `mapstructure:"delta" json:"delta"`                       // downlink weight
+	Epsilon               float64       `mapstructure:"epsilon" json:"epsilon"`                   // price range multiplier limit
+	FlowSize              units.Bytes   `mapstructure:"flow_size" json:"flow_size"`               // size of data to upload/download to/from provider
+	Duration              float64       `mapstructure:"duration" json:"duration,omitempty"`       // s, a session lasting this long instead of FlowSize
+	RateCapped            bool          `mapstructure:"rate_capped" json:"rate_capped,omitempty"` // cap each direction at the purchased speed
+	Protocol              string        `mapstructure:"protocol" json:"protocol,omitempty"`       // tcp (default) or udp
+	Bitrate               units.Bitrate `mapstructure:"bitrate" json:"bitrate,omitempty"`         // caps both directions, the rate of a udp flow, e.g. 10M
+	MaxJitter             float64       `mapstructure:"max_jitter" json:"max_jitter,omitempty"`   // ms, udp only
+	MaxLoss               float64       `mapstructure:"max_loss" json:"max_loss,omitempty"`       // percent of datagrams lost, udp only
+	MaxRTT                float64       `mapstructure:"max_rtt" json:"max_rtt,omitempty"`         // ms
 }
 
 type buyPayload struct {
 	PayloadMeta
 	ProviderList providers `json:"provider_list"`
+	Seed         int64     `json:"seed"` // recorded by the consumer to label the run
 	qosRequirements
 }
 
-type options struct {
-	ConsumerAddress         string    `mapstructure:"consumer_address"`
+// workload describes how BUY events are generated for a consumer, QoS values
+// are drawn from clipped normal distributions
+type workload struct {
+	ArrivalProcess          string    `mapstructure:"arrival_process"`        // normal, poisson, diurnal or trace
+	ArrivalRate             float64   `mapstructure:"arrival_rate"`           // events per second, poisson arrivals
+	DiurnalRates            []float64 `mapstructure:"diurnal_rates"`          // events per second, evenly spaced over diurnal_period
+	DiurnalPeriod           float64   `mapstructure:"diurnal_period"`         // seconds, defaults to one day
+	TracePath               string    `mapstructure:"trace_path"`             // CSV or JSONL trace of (timestamp, QoS, flow size)
+	FlowSizeDistribution    string    `mapstructure:"flow_size_distribution"` // normal or pareto
+	FlowSizeParetoAlpha     float64   `mapstructure:"flow_size_pareto_alpha"` // pareto shape, flow_size_lowest is the scale
 	BuyEventCount           int       `mapstructure:"buy_event_count"`
 	BuyEventIntervalMean    float64   `mapstructure:"buy_event_interval_mean"` // seconds
 	BuyEventIntervalStdDev  float64   `mapstructure:"buy_event_interval_std_dev"`
@@ -75,86 +95,197 @@ type options struct {
 	FlowSizeStdDev          float64   `mapstructure:"flow_size_std_dev"`
 	FlowSizeLowest          float64   `mapstructure:"flow_size_lowest"`
 	FlowSizeHighest         float64   `mapstructure:"flow_size_highest"`
-	ProviderList            providers `mapstructure:"provider_list"`
+	// Sessions last a drawn duration instead of moving a drawn flow size
+	SessionShare           float64       `mapstructure:"session_share"`         // share of BUY events that are sessions
+	SessionDurationMean    float64       `mapstructure:"session_duration_mean"` // s
+	SessionDurationStdDev  float64       `mapstructure:"session_duration_std_dev"`
+	SessionDurationLowest  float64       `mapstructure:"session_duration_lowest"`
+	SessionDurationHighest float64       `mapstructure:"session_duration_highest"`
+	SessionRateCapped      bool          `mapstructure:"session_rate_capped"` // cap sessions at the purchased speeds, as streaming does
+	UDPShare               float64       `mapstructure:"udp_share"`           // share of BUY events with a udp flow
+	UDPBitrate             units.Bitrate `mapstructure:"udp_bitrate"`         // target bitrate of the udp flows, e.g. 10M
+	MaxJitter              float64       `mapstructure:"max_jitter"`          // ms, udp flows, 0 is no bound
+	MaxLoss                float64       `mapstructure:"max_loss"`            // percent, udp flows, 0 is no bound
+	MaxRTT                 float64       `mapstructure:"max_rtt"`             // ms, 0 is no bound
+	ClosedLoop             bool          `mapstructure:"closed_loop"`         // wait for each transaction to finish before the next BUY
+	MaxOutstanding         int           `mapstructure:"max_outstanding"`     // cap of unfinished transactions, 0 is unlimited
 }
 
-type trigger struct {
-	options
+// consumerInfo is a consumer driven by the trigger, its workload and provider
+// list default to the top level values of the config file
+type consumerInfo struct {
+	ConsumerID   string    `mapstructure:"consumer_id"`
+	Address      string    `mapstructure:"address"`
+	ProviderList providers `mapstructure:"provider_list"`
+	workload     `mapstructure:",squash"`
 }
 
-func NewOptionsFromConfigFile() (*options, error) {
-	options := options{}
+type options struct {
+	ConsumerAddress  string          `mapstructure:"consumer_address"` // single consumer setup, used when consumers is empty
+	Consumers        []consumerInfo  `mapstructure:"-"`
+	ProviderList     providers       `mapstructure:"provider_list"`
+	ScenarioPath     string          `mapstructure:"scenario_path"`     // optional timeline of scenario events
+	ListenAddress    string          `mapstructure:"listen_address"`    // TRIGGER_RESULT replies, results aren't collected if empty
+	AdvertiseAddress string          `mapstructure:"advertise_address"` // address consumers use to reach the trigger
+	ResultTimeout    float64         `mapstructure:"result_timeout"`    // seconds to wait for a TRIGGER_RESULT
+	SummaryInterval  float64         `mapstructure:"summary_interval"`  // seconds between live summaries, 0 disables them
+	Seed             int64           `mapstructure:"seed"`              // seed of every random draw, 0 picks one from the clock
+	AdminToken       string          `mapstructure:"admin_token"`       // admin_token of the providers, sent with set_params and set_behaviour
+	Logging          logging.Options `mapstructure:",squash"`
+	workload         `mapstructure:",squash"`
+}
 
-	viper.SetConfigName("config") // Name of config file (without extension)
-	viper.SetConfigType("json")   // REQUIRED if the config file does not have the extension in the name
-	viper.AddConfigPath(".")      // Path to look for the config file in
-	viper.AddConfigPath("cmd/trigger")
+type trigger struct {
+	options
+	results *resultCollector
+	log     *slog.Logger
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// config not found, ignore err
-			return nil, fmt.Errorf("config file not found")
-		} else {
-			// other errors, ignore err
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
+func New(opt *options) *trigger {
+	if opt.Seed == 0 {
+		opt.Seed = time.Now().UnixNano()
 	}
-
-	err := viper.Unmarshal(&options)
+	logger, err := logging.New("trigger", opt.Logging)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal options config file: %w", err)
+		logger, _ = logging.New("trigger", logging.Options{})
+		logger.Warn("falling back to default logging", logging.Err(err))
 	}
+	logger.Info("random seed", "seed", opt.Seed)
 
-	return &options, nil
+	return &trigger{
+		options: *opt,
+		results: newResultCollector(logger),
+		log:     logger,
+	}
 }
 
-func New(opt *options) trigger {
-	return trigger{
-		*opt,
-	}
+// Logger is the logger of the trigger
+func (t *trigger) Logger() *slog.Logger {
+	return t.log
 }
 
+// Start drives every consumer concurrently and returns once all of them have
+// sent their BUY events
 func (t *trigger) Start() {
-	for i := 0; i < t.BuyEventCount; i++ {
-		interval := getRandomizedVal(t.BuyEventIntervalMean, t.BuyEventIntervalStdDev, 1, 300)
-		time.Sleep(time.Second * time.Duration(interval))
+	wg := sync.WaitGroup{}
+	for idx, consumer := range t.Consumers {
+		wg.Add(1)
+		go func(consumer consumerInfo, rng *rand.Rand) {
+			defer wg.Done()
+			t.runConsumer(consumer, rng)
+		}(consumer, newRand(t.Seed, idx))
+	}
+	wg.Wait()
+}
 
-		conn, err := net.Dial("tcp", t.ConsumerAddress)
-		if err != nil {
-			fmt.Println("failed to dial consumer:", err)
-		}
+func (t *trigger) runConsumer(consumer consumerInfo, rng *rand.Rand) {
+	generator, err := newBuyGenerator(consumer.workload, rng)
+	if err != nil {
+		t.log.Error("failed to create workload", logging.KeyPeer, consumer.ConsumerID, logging.Err(err))
+		return
+	}
 
-		buyPayload := buyPayload{
-			PayloadMeta: PayloadMeta{
-				PayloadType: events.TRIGGER_BUY,
-			},
-			ProviderList: t.ProviderList,
-			qosRequirements: qosRequirements{
-				PriceConsumer:         getRandomizedVal(t.PriceMean, t.PriceStdDev, t.PriceLowest, t.PriceHighest),
-				UplinkSpeedConsumer:   getRandomizedVal(t.UplinkMean, t.UplinkStdDev, t.UplinkLowest, t.UplinkHighest),
-				DownlinkSpeedConsumer: getRandomizedVal(t.DownlinkMean, t.DownlinkStdDev, t.DownlinkLowest, t.DownlinkHighest),
-				Mu:                    getRandomizedVal(t.MuMean, t.MuStdDev, t.MuLowest, t.MuHighest),
-				Delta:                 getRandomizedVal(t.DeltaMean, t.DeltaStdDev, t.DeltaLowest, t.DeltaHighest),
-				Epsilon:               getRandomizedVal(t.EpsilonMean, t.EpsilonStdDev, t.EpsilonLowest, t.EpsilonHighest),
-				FlowSize: strconv.FormatFloat(
-					getRandomizedVal(t.FlowSizeMean, t.FlowSizeStdDev, t.FlowSizeLowest, t.FlowSizeHighest),
-					'f',
-					2,
-					64,
-				) + "M",
-			},
+	// Outstanding transactions are capped with a semaphore, which needs results
+	maxOutstanding := consumer.MaxOutstanding
+	if consumer.ClosedLoop {
+		maxOutstanding = 1
+	}
+	var slots chan struct{}
+	if maxOutstanding > 0 {
+		if t.ListenAddress == "" {
+			t.log.Warn("ignoring closed_loop and max_outstanding, listen_address is not set",
+				logging.KeyPeer, consumer.ConsumerID)
+		} else {
+			slots = make(chan struct{}, maxOutstanding)
 		}
+	}
 
-		jsonPayload, err := json.Marshal(buyPayload)
-		if err != nil {
-			fmt.Println("failed to marshal buy payload:", err)
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+	for {
+		event, ok := generator.next()
+		if !ok {
+			return
 		}
+		time.Sleep(event.delay)
 
-		if _, err = conn.Write(jsonPayload); err != nil {
-			fmt.Println("failed to send TRIGGER_BUY event to consumer:", err)
-		} else {
-			fmt.Println("sent TRIGGER_BUY to consumer:", string(jsonPayload))
+		if slots != nil {
+			slots <- struct{}{}
 		}
-		conn.Close()
+		t.issueBuy(consumer, event.qosRequirements, &wg, func() {
+			if slots != nil {
+				<-slots
+			}
+		})
+	}
+}
+
+// issueBuy sends a BUY to the consumer and tracks its result in the
+// background, release is called once the transaction is done
+func (t *trigger) issueBuy(consumer consumerInfo, qosRequirements qosRequirements, wg *sync.WaitGroup, release func()) error {
+	transactionID := uuid.New()
+	channel := t.results.register(transactionID)
+
+	if err := t.sendBuy(consumer, transactionID, qosRequirements); err != nil {
+		t.log.Error("failed to send payload", logging.Event(events.TRIGGER_BUY), logging.Transaction(transactionID),
+			logging.KeyPeer, consumer.ConsumerID, logging.Err(err))
+		t.results.sendFailed(transactionID)
+		release()
+		return err
+	}
+
+	if t.ListenAddress == "" {
+		t.results.forget(transactionID)
+		release()
+		return nil
+	}
+
+	timeout := t.ResultTimeout
+	if timeout <= 0 {
+		timeout = defaultResultTimeout
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer release()
+		t.results.wait(transactionID, channel, secondsToDuration(timeout))
+	}()
+	return nil
+}
+
+func (t *trigger) sendBuy(consumer consumerInfo, transactionID uuid.UUID, qosRequirements qosRequirements) error {
+	buyPayload := buyPayload{
+		PayloadMeta: PayloadMeta{
+			PayloadType:   events.TRIGGER_BUY,
+			TransactionID: transactionID,
+		},
+		ProviderList:    consumer.ProviderList,
+		Seed:            t.Seed,
+		qosRequirements: qosRequirements,
+	}
+	// The consumer replies with TRIGGER_RESULT to the origin address
+	if t.ListenAddress != "" {
+		buyPayload.OriginAddress = t.advertisedListenAddress()
+	}
+
+	jsonPayload, err := json.Marshal(buyPayload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal buy payload: %w", err)
+	}
+
+	if err := send(consumer.Address, jsonPayload); err != nil {
+		return err
+	}
+	t.log.Info("sent payload", logging.Event(events.TRIGGER_BUY), logging.Transaction(transactionID),
+		logging.KeyPeer, consumer.ConsumerID, "payload", string(jsonPayload))
+	return nil
+}
+
+// advertisedListenAddress is the address consumers reply to, defaults to
+// listen_address
+func (t *trigger) advertisedListenAddress() string {
+	if t.AdvertiseAddress != "" {
+		return t.AdvertiseAddress
 	}
+	return t.ListenAddress
 }
@@ -0,0 +1,467 @@
+package trigger
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"wifi-trade-consensus/internal/pkg/units"
+)
+
+// Arrival processes, selected with arrival_process
+const (
+	arrivalNormal  = "normal"  // clipped normal inter-arrival times (default)
+	arrivalPoisson = "poisson" // exponential inter-arrival times at arrival_rate
+	arrivalDiurnal = "diurnal" // poisson process whose rate follows diurnal_rates
+	arrivalTrace   = "trace"   // replay of trace_path
+)
+
+// Flow size distributions, selected with flow_size_distribution
+const (
+	flowSizeNormal = "normal" // clipped normal flow sizes (default)
+	flowSizePareto = "pareto" // heavy-tailed flow sizes
+)
+
+// protocolUDP marks the flows that are sent as udp, see udp_share
+const protocolUDP = "udp"
+
+// buyEvent is a single BUY to be sent to a consumer after delay
+type buyEvent struct {
+	delay           time.Duration
+	qosRequirements qosRequirements
+}
+
+// buyGenerator yields the BUY events of a consumer, ok is false once the
+// workload is exhausted
+type buyGenerator interface {
+	next() (event buyEvent, ok bool)
+}
+
+// arrivalProcess returns the time to wait before the next BUY event, elapsed
+// is the time since the start of the workload
+type arrivalProcess interface {
+	nextInterval(elapsed time.Duration) time.Duration
+}
+
+// newBuyGenerator returns the generator of workload w drawing from rng
+func newBuyGenerator(w workload, rng *rand.Rand) (buyGenerator, error) {
+	if w.ArrivalProcess == arrivalTrace {
+		return newTraceReplay(w.TracePath, w.BuyEventCount)
+	}
+
+	arrival, err := newArrivalProcess(w, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	switch w.FlowSizeDistribution {
+	case "", flowSizeNormal, flowSizePareto:
+	default:
+		return nil, fmt.Errorf("unknown flow size distribution: %s", w.FlowSizeDistribution)
+	}
+
+	return &syntheticGenerator{
+		workload: w,
+		arrival:  arrival,
+		rng:      rng,
+	}, nil
+}
+
+func newArrivalProcess(w workload, rng *rand.Rand) (arrivalProcess, error) {
+	switch w.ArrivalProcess {
+	case "", arrivalNormal:
+		return normalArrival{
+			mean:    w.BuyEventIntervalMean,
+			stdDev:  w.BuyEventIntervalStdDev,
+			lowest:  w.BuyEventIntervalLowest,
+			highest: w.BuyEventIntervalHighest,
+			rng:     rng,
+		}, nil
+	case arrivalPoisson:
+		if w.ArrivalRate <= 0 {
+			return nil, fmt.Errorf("arrival_rate must be greater than 0 for poisson arrivals")
+		}
+		return poissonArrival{rate: w.ArrivalRate, rng: rng}, nil
+	case arrivalDiurnal:
+		return newDiurnalArrival(w.DiurnalRates, w.DiurnalPeriod, rng)
+	default:
+		return nil, fmt.Errorf("unknown arrival process: %s", w.ArrivalProcess)
+	}
+}
+
+// syntheticGenerator draws arrivals and QoS requirements from the configured
+// distributions
+type syntheticGenerator struct {
+	workload
+	arrival arrivalProcess
+	rng     *rand.Rand
+	sent    int
+	elapsed time.Duration
+}
+
+func (g *syntheticGenerator) next() (buyEvent, bool) {
+	if g.sent >= g.BuyEventCount {
+		return buyEvent{}, false
+	}
+	g.sent++
+
+	delay := g.arrival.nextInterval(g.elapsed)
+	g.elapsed += delay
+
+	event := buyEvent{
+		delay: delay,
+		qosRequirements: qosRequirements{
+			PriceConsumer:         getRandomizedVal(g.rng, g.PriceMean, g.PriceStdDev, g.PriceLowest, g.PriceHighest),
+			UplinkSpeedConsumer:   getRandomizedVal(g.rng, g.UplinkMean, g.UplinkStdDev, g.UplinkLowest, g.UplinkHighest),
+			DownlinkSpeedConsumer: getRandomizedVal(g.rng, g.DownlinkMean, g.DownlinkStdDev, g.DownlinkLowest, g.DownlinkHighest),
+			Mu:                    getRandomizedVal(g.rng, g.MuMean, g.MuStdDev, g.MuLowest, g.MuHighest),
+			Delta:                 getRandomizedVal(g.rng, g.DeltaMean, g.DeltaStdDev, g.DeltaLowest, g.DeltaHighest),
+			MaxRTT:                g.MaxRTT,
+			Epsilon:               getRandomizedVal(g.rng, g.EpsilonMean, g.EpsilonStdDev, g.EpsilonLowest, g.EpsilonHighest),
+		},
+	}
+	if g.SessionShare > 0 && g.rng.Float64() < g.SessionShare {
+		event.qosRequirements.Duration = getRandomizedVal(g.rng, g.SessionDurationMean, g.SessionDurationStdDev,
+			g.SessionDurationLowest, g.SessionDurationHighest)
+		event.qosRequirements.RateCapped = g.SessionRateCapped
+	} else {
+		event.qosRequirements.FlowSize = units.Megabytes(g.flowSize())
+	}
+	if g.UDPShare > 0 && g.rng.Float64() < g.UDPShare {
+		event.qosRequirements.Protocol = protocolUDP
+		event.qosRequirements.Bitrate = g.UDPBitrate
+		event.qosRequirements.MaxJitter = g.MaxJitter
+		event.qosRequirements.MaxLoss = g.MaxLoss
+	}
+	return event, true
+}
+
+// flowSize returns the flow size in megabytes
+func (g *syntheticGenerator) flowSize() float64 {
+	if g.FlowSizeDistribution == flowSizePareto {
+		return getParetoVal(g.rng, g.FlowSizeParetoAlpha, g.FlowSizeLowest, g.FlowSizeHighest)
+	}
+	return getRandomizedVal(g.rng, g.FlowSizeMean, g.FlowSizeStdDev, g.FlowSizeLowest, g.FlowSizeHighest)
+}
+
+type normalArrival struct {
+	mean    float64 // seconds
+	stdDev  float64
+	lowest  float64
+	highest float64
+	rng     *rand.Rand
+}
+
+func (a normalArrival) nextInterval(elapsed time.Duration) time.Duration {
+	return secondsToDuration(getRandomizedVal(a.rng, a.mean, a.stdDev, a.lowest, a.highest))
+}
+
+type poissonArrival struct {
+	rate float64 // events per second
+	rng  *rand.Rand
+}
+
+func (a poissonArrival) nextInterval(elapsed time.Duration) time.Duration {
+	return secondsToDuration(a.rng.ExpFloat64() / a.rate)
+}
+
+// diurnalArrival is a non-homogeneous poisson process, the rate is linearly
+// interpolated between evenly spaced points of a cyclic rate curve
+type diurnalArrival struct {
+	rates   []float64 // events per second
+	period  float64   // seconds
+	maxRate float64
+	rng     *rand.Rand
+}
+
+func newDiurnalArrival(rates []float64, period float64, rng *rand.Rand) (*diurnalArrival, error) {
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("diurnal_rates must not be empty for diurnal arrivals")
+	}
+	if period <= 0 {
+		period = 86400 // one day
+	}
+
+	maxRate := 0.0
+	for _, rate := range rates {
+		if rate < 0 {
+			return nil, fmt.Errorf("diurnal_rates must not be negative: %v", rate)
+		}
+		maxRate = math.Max(maxRate, rate)
+	}
+	if maxRate == 0 {
+		return nil, fmt.Errorf("diurnal_rates must contain a rate greater than 0")
+	}
+
+	return &diurnalArrival{
+		rates:   rates,
+		period:  period,
+		maxRate: maxRate,
+		rng:     rng,
+	}, nil
+}
+
+func (a *diurnalArrival) rateAt(t float64) float64 {
+	slot := math.Mod(t, a.period) / a.period * float64(len(a.rates))
+	idx := int(slot)
+	frac := slot - float64(idx)
+	current := a.rates[idx%len(a.rates)]
+	next := a.rates[(idx+1)%len(a.rates)]
+	return current + (next-current)*frac
+}
+
+// nextInterval uses thinning: candidates are drawn at the peak rate and
+// accepted with probability rate(t)/maxRate
+func (a *diurnalArrival) nextInterval(elapsed time.Duration) time.Duration {
+	start := elapsed.Seconds()
+	t := start
+	for {
+		t += a.rng.ExpFloat64() / a.maxRate
+		if a.rng.Float64()*a.maxRate <= a.rateAt(t) {
+			return secondsToDuration(t - start)
+		}
+	}
+}
+
+// traceReplay replays (timestamp, QoS, flow size) records from a CSV or JSONL
+// file, the delay between events is the difference between timestamps
+type traceReplay struct {
+	records []traceRecord
+	idx     int
+}
+
+type traceRecord struct {
+	Timestamp float64 `json:"timestamp"` // seconds
+	qosRequirements
+}
+
+var traceColumns = []string{"timestamp", "price", "uplink", "downlink", "mu", "delta", "epsilon", "flow_size"}
+
+func newTraceReplay(path string, limit int) (*traceReplay, error) {
+	if path == "" {
+		return nil, fmt.Errorf("trace_path must be set for trace arrivals")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	defer file.Close()
+
+	var records []traceRecord
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		records, err = readCSVTrace(file)
+	case ".jsonl", ".json":
+		records, err = readJSONLTrace(file)
+	default:
+		return nil, fmt.Errorf("unsupported trace file extension: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace file %s: %w", path, err)
+	}
+
+	if limit > 0 && limit < len(records) {
+		records = records[:limit]
+	}
+
+	return &traceReplay{records: records}, nil
+}
+
+func (r *traceReplay) next() (buyEvent, bool) {
+	if r.idx >= len(r.records) {
+		return buyEvent{}, false
+	}
+
+	record := r.records[r.idx]
+	delay := time.Duration(0)
+	if r.idx > 0 {
+		delay = secondsToDuration(record.Timestamp - r.records[r.idx-1].Timestamp)
+	}
+	r.idx++
+
+	return buyEvent{
+		delay:           delay,
+		qosRequirements: record.qosRequirements,
+	}, true
+}
+
+func readCSVTrace(reader io.Reader) ([]traceRecord, error) {
+	rows, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("trace is empty")
+	}
+
+	// Map header names to column indexes
+	columns := map[string]int{}
+	for idx, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = idx
+	}
+	for _, name := range traceColumns {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("missing trace column: %s", name)
+		}
+	}
+
+	records := []traceRecord{}
+	for line, row := range rows[1:] {
+		values := map[string]float64{}
+		for _, name := range traceColumns {
+			if name == "flow_size" {
+				continue
+			}
+			val, err := strconv.ParseFloat(strings.TrimSpace(row[columns[name]]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line+2, name, err)
+			}
+			values[name] = val
+		}
+
+		record := traceRecord{
+			Timestamp: values["timestamp"],
+			qosRequirements: qosRequirements{
+				PriceConsumer:         values["price"],
+				UplinkSpeedConsumer:   values["uplink"],
+				DownlinkSpeedConsumer: values["downlink"],
+				Mu:                    values["mu"],
+				Delta:                 values["delta"],
+				Epsilon:               values["epsilon"],
+			},
+		}
+		if err := readFlowColumns(row, columns, &record.qosRequirements); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+2, err)
+		}
+		records = append(records, record)
+	}
+
+	return records, checkTrace(records)
+}
+
+// readFlowColumns reads the flow_size of a CSV trace row and its optional
+// duration, rate_capped, protocol, bitrate, max_jitter, max_loss and max_rtt
+// columns
+func readFlowColumns(row []string, columns map[string]int, qos *qosRequirements) error {
+	if size := strings.TrimSpace(row[columns["flow_size"]]); size != "" {
+		flowSize, err := parseFlowSize(size)
+		if err != nil {
+			return err
+		}
+		qos.FlowSize = flowSize
+	}
+	if idx, exists := columns["rate_capped"]; exists && strings.TrimSpace(row[idx]) != "" {
+		rateCapped, err := strconv.ParseBool(strings.TrimSpace(row[idx]))
+		if err != nil {
+			return fmt.Errorf("invalid rate_capped: %w", err)
+		}
+		qos.RateCapped = rateCapped
+	}
+	if idx, exists := columns["protocol"]; exists {
+		qos.Protocol = strings.TrimSpace(row[idx])
+	}
+	if idx, exists := columns["bitrate"]; exists {
+		if err := qos.Bitrate.UnmarshalText([]byte(strings.TrimSpace(row[idx]))); err != nil {
+			return err
+		}
+	}
+	for name, dest := range map[string]*float64{"duration": &qos.Duration, "max_jitter": &qos.MaxJitter,
+		"max_loss": &qos.MaxLoss, "max_rtt": &qos.MaxRTT} {
+		idx, exists := columns[name]
+		if !exists || strings.TrimSpace(row[idx]) == "" {
+			continue
+		}
+		val, err := strconv.ParseFloat(strings.TrimSpace(row[idx]), 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*dest = val
+	}
+	return nil
+}
+
+func readJSONLTrace(reader io.Reader) ([]traceRecord, error) {
+	records := []traceRecord{}
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		record := struct {
+			traceRecord
+			FlowSize interface{} `json:"flow_size"` // megabytes or an iperf3 size string, none for a session
+		}{}
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		switch flowSize := record.FlowSize.(type) {
+		case nil:
+		case float64:
+			record.traceRecord.FlowSize = units.Megabytes(flowSize)
+		case string:
+			size, err := parseFlowSize(flowSize)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			record.traceRecord.FlowSize = size
+		default:
+			return nil, fmt.Errorf("line %d: invalid flow_size: %v", line, record.FlowSize)
+		}
+		records = append(records, record.traceRecord)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, checkTrace(records)
+}
+
+// checkTrace checks that the timestamps never decrease, that every record has
+// a flow size or a session duration and that udp records set their rate
+func checkTrace(records []traceRecord) error {
+	for idx, record := range records {
+		if idx > 0 && record.Timestamp < records[idx-1].Timestamp {
+			return fmt.Errorf("trace timestamps must be non-decreasing, record %d", idx)
+		}
+		if record.Duration < 0 {
+			return fmt.Errorf("record %d: duration must be >= 0, got %v", idx, record.Duration)
+		}
+		if record.FlowSize <= 0 && record.Duration == 0 {
+			return fmt.Errorf("record %d needs a flow_size or a duration", idx)
+		}
+		if record.Protocol == protocolUDP && record.Bitrate <= 0 && !record.RateCapped {
+			return fmt.Errorf("record %d: udp needs a bitrate or rate_capped", idx)
+		}
+	}
+	return nil
+}
+
+// parseFlowSize treats bare numbers as megabytes and reads sizes with a suffix
+// as iperf3 does
+func parseFlowSize(size string) (units.Bytes, error) {
+	if megabytes, err := strconv.ParseFloat(size, 64); err == nil {
+		if megabytes < 0 {
+			return 0, fmt.Errorf("invalid flow_size %q", size)
+		}
+		return units.Megabytes(megabytes), nil
+	}
+	return units.ParseBytes(size)
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,252 @@
+package trigger
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wifi-trade-consensus/internal/pkg/units"
+)
+
+func testWorkload() workload {
+	return workload{
+		ArrivalProcess:         arrivalPoisson,
+		ArrivalRate:            2,
+		FlowSizeDistribution:   flowSizePareto,
+		FlowSizeParetoAlpha:    1.5,
+		BuyEventCount:          50,
+		UplinkMean:             10,
+		UplinkStdDev:           5,
+		UplinkLowest:           1,
+		UplinkHighest:          20,
+		DownlinkMean:           20,
+		DownlinkStdDev:         5,
+		DownlinkLowest:         1,
+		DownlinkHighest:        40,
+		PriceMean:              0.5,
+		PriceStdDev:            0.2,
+		PriceLowest:            0.1,
+		PriceHighest:           1,
+		FlowSizeLowest:         1,
+		FlowSizeHighest:        100,
+		SessionShare:           0.3,
+		SessionDurationMean:    10,
+		SessionDurationStdDev:  2,
+		SessionDurationLowest:  1,
+		SessionDurationHighest: 20,
+	}
+}
+
+func drawEvents(t *testing.T, w workload, seed int64, stream int) []buyEvent {
+	t.Helper()
+	generator, err := newBuyGenerator(w, newRand(seed, stream))
+	if err != nil {
+		t.Fatalf("newBuyGenerator: %v", err)
+	}
+	events := []buyEvent{}
+	for {
+		event, ok := generator.next()
+		if !ok {
+			return events
+		}
+		events = append(events, event)
+	}
+}
+
+func TestSeedReproducesWorkload(t *testing.T) {
+	w := testWorkload()
+	first := drawEvents(t, w, 42, 0)
+	if len(first) != w.BuyEventCount {
+		t.Fatalf("drew %d events, want %d", len(first), w.BuyEventCount)
+	}
+
+	// Another stream drawn in between doesn't change the sequence of a consumer
+	drawEvents(t, w, 42, 1)
+	again := drawEvents(t, w, 42, 0)
+	for idx := range first {
+		if first[idx] != again[idx] {
+			t.Fatalf("event %d = %+v, then %+v with the same seed", idx, first[idx], again[idx])
+		}
+	}
+
+	other := drawEvents(t, w, 42, 1)
+	if first[0] == other[0] && first[1] == other[1] {
+		t.Errorf("streams 0 and 1 drew the same events")
+	}
+}
+
+func writeTrace(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatalf("failed to write trace: %v", err)
+	}
+	return path
+}
+
+func replayAll(t *testing.T, path string, limit int) []buyEvent {
+	t.Helper()
+	replay, err := newTraceReplay(path, limit)
+	if err != nil {
+		t.Fatalf("newTraceReplay: %v", err)
+	}
+	events := []buyEvent{}
+	for {
+		event, ok := replay.next()
+		if !ok {
+			return events
+		}
+		events = append(events, event)
+	}
+}
+
+func TestCSVTrace(t *testing.T) {
+	header := "timestamp, price, uplink, downlink, mu, delta, epsilon, flow_size, protocol, bitrate, duration\n"
+	path := writeTrace(t, "trace.csv", header+`10,0.5,10,20,0.5,0.5,1.2,8,,,
+10.5,0.6,5,5,0.3,0.7,1.1,2M,udp,10M,
+12,0.7,1,1,0.5,0.5,1,,,,30
+`)
+
+	events := replayAll(t, path, 0)
+	if len(events) != 3 {
+		t.Fatalf("replayed %d events, want 3", len(events))
+	}
+	// The first event is sent right away, the others keep the gaps of the trace
+	for idx, want := range []time.Duration{0, 500 * time.Millisecond, 1500 * time.Millisecond} {
+		if events[idx].delay != want {
+			t.Errorf("event %d delay = %v, want %v", idx, events[idx].delay, want)
+		}
+	}
+	first := events[0].qosRequirements
+	if first.PriceConsumer != 0.5 || first.DownlinkSpeedConsumer != 20 || first.Epsilon != 1.2 ||
+		first.FlowSize != units.Megabytes(8) {
+		t.Errorf("event 0 = %+v", first)
+	}
+	second := events[1].qosRequirements
+	if second.FlowSize != 2<<20 || second.Protocol != protocolUDP || second.Bitrate != 10_000_000 {
+		t.Errorf("event 1 flow = %v %s %v, want 2M udp 10M", second.FlowSize, second.Protocol, second.Bitrate)
+	}
+	if third := events[2].qosRequirements; third.FlowSize != 0 || third.Duration != 30 {
+		t.Errorf("event 2 = %v bytes for %v s, want a 30 s session", third.FlowSize, third.Duration)
+	}
+
+	if limited := replayAll(t, path, 2); len(limited) != 2 {
+		t.Errorf("replayed %d events with buy_event_count 2", len(limited))
+	}
+}
+
+func TestJSONLTrace(t *testing.T) {
+	path := writeTrace(t, "trace.jsonl", `{"timestamp": 1, "price": 0.5, "uplink": 10, "downlink": 10, "flow_size": 4}
+
+{"timestamp": 3, "price": 0.5, "uplink": 10, "downlink": 10, "flow_size": "1G", "max_rtt": 50}
+{"timestamp": 3, "price": 0.5, "uplink": 10, "downlink": 10, "duration": 5, "rate_capped": true}
+`)
+
+	events := replayAll(t, path, 0)
+	if len(events) != 3 {
+		t.Fatalf("replayed %d events, want 3", len(events))
+	}
+	if events[0].qosRequirements.FlowSize != units.Megabytes(4) {
+		t.Errorf("event 0 flow_size = %v, want 4 MB", events[0].qosRequirements.FlowSize)
+	}
+	if events[1].delay != 2*time.Second || events[1].qosRequirements.FlowSize != 1<<30 ||
+		events[1].qosRequirements.MaxRTT != 50 {
+		t.Errorf("event 1 = %v later, %+v", events[1].delay, events[1].qosRequirements)
+	}
+	if events[2].delay != 0 || !events[2].qosRequirements.RateCapped || events[2].qosRequirements.Duration != 5 {
+		t.Errorf("event 2 = %v later, %+v", events[2].delay, events[2].qosRequirements)
+	}
+}
+
+func TestInvalidTraces(t *testing.T) {
+	const header = "timestamp,price,uplink,downlink,mu,delta,epsilon,flow_size\n"
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"missing.csv", "timestamp,price,uplink,downlink,mu,delta,epsilon\n1,1,1,1,1,1,1\n"},
+		{"number.csv", header + "1,x,1,1,1,1,1,1\n"},
+		{"decreasing.csv", header + "2,1,1,1,1,1,1,1\n1,1,1,1,1,1,1,1\n"},
+		{"no_size.csv", header + "1,1,1,1,1,1,1,\n"},
+		{"negative.csv", header + "1,1,1,1,1,1,1,-3\n"},
+		{"empty.csv", ""},
+		{"syntax.jsonl", `{"timestamp": 1, "flow_size": 1` + "\n"},
+		{"flow_size.jsonl", `{"timestamp": 1, "flow_size": true}` + "\n"},
+		{"udp.jsonl", `{"timestamp": 1, "flow_size": 1, "protocol": "udp"}` + "\n"},
+		{"trace.txt", "1,1,1"},
+	}
+	for _, test := range tests {
+		path := writeTrace(t, test.name, test.content)
+		if _, err := newTraceReplay(path, 0); err == nil {
+			t.Errorf("%s: newTraceReplay succeeded", test.name)
+		}
+	}
+}
+
+func TestDiurnalRate(t *testing.T) {
+	arrival, err := newDiurnalArrival([]float64{0, 4, 2, 0}, 8, nil)
+	if err != nil {
+		t.Fatalf("newDiurnalArrival: %v", err)
+	}
+	tests := []struct {
+		t    float64
+		want float64
+	}{
+		{0, 0},
+		{1, 2},  // half way from 0 to 4
+		{2, 4},  // a point of the curve
+		{5, 1},  // half way from 2 to 0
+		{7, 0},  // the curve wraps around to its first point
+		{10, 4}, // next period
+	}
+	for _, test := range tests {
+		if got := arrival.rateAt(test.t); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("rateAt(%v) = %v, want %v", test.t, got, test.want)
+		}
+	}
+
+	for _, rates := range [][]float64{nil, {1, -1}, {0, 0}} {
+		if _, err := newDiurnalArrival(rates, 8, nil); err == nil {
+			t.Errorf("newDiurnalArrival(%v) succeeded", rates)
+		}
+	}
+}
+
+func TestDiurnalThinning(t *testing.T) {
+	// Arrivals only fit between 1 s and 3 s of each 4 s period, 4 of them per
+	// period on average
+	arrival, err := newDiurnalArrival([]float64{0, 0, 4, 0}, 4, newRand(1, 0))
+	if err != nil {
+		t.Fatalf("newDiurnalArrival: %v", err)
+	}
+
+	const periods = 2000
+	elapsed := time.Duration(0)
+	count := 0
+	for elapsed < periods*4*time.Second {
+		elapsed += arrival.nextInterval(elapsed)
+		offset := math.Mod(elapsed.Seconds(), 4)
+		if offset <= 1 || offset >= 3 {
+			t.Fatalf("arrival at %v s into a period whose rate is 0", offset)
+		}
+		count++
+	}
+	if perPeriod := float64(count) / periods; math.Abs(perPeriod-4) > 0.2 {
+		t.Errorf("%v arrivals per period, want 4", perPeriod)
+	}
+}
+
+func TestUDPShareNeedsBitrate(t *testing.T) {
+	w := testWorkload()
+	w.UDPShare = 0.5
+	if err := w.validate(); err == nil || !strings.Contains(err.Error(), "udp_bitrate") {
+		t.Errorf("validate = %v, want udp_bitrate required", err)
+	}
+
+	w.UDPBitrate = 10_000_000
+	if err := w.validate(); err != nil && strings.Contains(err.Error(), "udp_bitrate") {
+		t.Errorf("validate = %v with a udp_bitrate", err)
+	}
+}
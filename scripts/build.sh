@@ -1,9 +1,7 @@
 #!/bin/sh
 
-go build -o C:/Dev/AUC-DEMO/provider0 C:/Dev/AUC/wifi-trade-consensus/cmd/provider
-go build -o C:/Dev/AUC-DEMO/provider1 C:/Dev/AUC/wifi-trade-consensus/cmd/provider
-go build -o C:/Dev/AUC-DEMO/provider2 C:/Dev/AUC/wifi-trade-consensus/cmd/provider
+go build -o C:/Dev/AUC-DEMO/wtc C:/Dev/AUC/wifi-trade-consensus/cmd/wtc
 
-# wt --title "provider0" C:/Dev/AUC-DEMO/provider0/provider &
-# wt new-tab -w 0 --title "provider1" C:/Dev/AUC-DEMO/provider1/provider &
-# wt -w 0 --title "provider2" C:/Dev/AUC-DEMO/provider2/provider &
\ No newline at end of file
+# wt --title "provider0" C:/Dev/AUC-DEMO/wtc provider --config C:/Dev/AUC-DEMO/provider0/config.json --beacon-config C:/Dev/AUC-DEMO/provider0/beacon_config.json &
+# wt new-tab -w 0 --title "provider1" C:/Dev/AUC-DEMO/wtc provider --config C:/Dev/AUC-DEMO/provider1/config.json --beacon-config C:/Dev/AUC-DEMO/provider1/beacon_config.json &
+# wt -w 0 --title "provider2" C:/Dev/AUC-DEMO/wtc provider --config C:/Dev/AUC-DEMO/provider2/config.json --beacon-config C:/Dev/AUC-DEMO/provider2/beacon_config.json &
\ No newline at end of file
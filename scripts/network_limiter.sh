@@ -84,11 +84,21 @@ if [ "$is_faulty" = true ]; then
     burst=100K
 fi
 
+# Optional overrides, used by scenarios to change network conditions at runtime:
+# network_limiter.sh <up> <down> <burst>
+if [ -n "$1" ]; then up=$1; fi
+if [ -n "$2" ]; then down=$2; fi
+if [ -n "$3" ]; then burst=$3; fi
+
 echo is faulty ? $is_faulty
 echo up is $up
 echo down is $down
 echo burst is $burst
 
+# Remove previous limits, if any
+tc qdisc del dev eth0 ingress 2>/dev/null
+tc qdisc del dev eth0 root 2>/dev/null
+
 # Limit all incoming and outgoing network to megabytes/s
 tc qdisc add dev eth0 handle ffff: ingress
 tc filter add dev eth0 parent ffff: protocol ip prio 50 u32 match ip src 0.0.0.0/0 police rate $down burst $burst flowid :1 # download